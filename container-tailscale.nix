@@ -15,7 +15,7 @@ let
     version = "0.1.0";
     src = ./.;
     vendorHash = "sha256-b4XmnP0EJJsSC8f3Q4Gnj8tcsdlCo7cab/baDGRIT/0=";
-    ldflags = [ "-s" "-w" ];
+    ldflags = [ "-s" "-w" "-X main.version=0.1.0" ];
 
     meta = with linuxPkgs.lib; {
       description = "Web dashboard for failed Snowflake queries";
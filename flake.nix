@@ -18,9 +18,9 @@
 
           src = ./.;
 
-          vendorHash = "sha256-i9inf+GdM/qgQ7oydh+12A4CQJdWqYaSX7gEQR0a2Io=";
+          vendorHash = "sha256-O32oQT0AU1nPx5blsHeWoowMSrxRARY0y6ZJ7q6o7ss=";
 
-          ldflags = [ "-s" "-w" ];
+          ldflags = [ "-s" "-w" "-X main.version=0.1.0" "-X main.commit=${self.shortRev or "dirty"}" ];
 
           meta = with pkgs.lib; {
             description = "Web dashboard for failed Snowflake queries";
@@ -92,8 +92,8 @@
                 pname = "snowflake-dashboard";
                 version = "0.1.0";
                 src = ./.;
-                vendorHash = "sha256-i9inf+GdM/qgQ7oydh+12A4CQJdWqYaSX7gEQR0a2Io=";
-                ldflags = [ "-s" "-w" ];
+                vendorHash = "sha256-O32oQT0AU1nPx5blsHeWoowMSrxRARY0y6ZJ7q6o7ss=";
+                ldflags = [ "-s" "-w" "-X main.version=0.1.0" "-X main.commit=${self.shortRev or "dirty"}" ];
               };
             in
             linuxPkgs.dockerTools.buildImage {
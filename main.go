@@ -1,38 +1,341 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
 	"crypto/x509"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"flag"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
+	"log/slog"
+	"maps"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"net/mail"
+	"net/netip"
+	"net/smtp"
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	_ "time/tzdata" // Embedded zone database so DISPLAY_TIMEZONE works in minimal containers
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/joho/godotenv"
 	"github.com/snowflakedb/gosnowflake"
 	"github.com/youmark/pkcs8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
 	_ "github.com/snowflakedb/gosnowflake"
+	_ "modernc.org/sqlite"
 )
 
 type FailedQuery struct {
 	QueryID       string    `json:"query_id"`
 	QueryText     string    `json:"query_text"`
 	UserName      string    `json:"user_name"`
+	WarehouseName string    `json:"warehouse_name"` // Empty for queries that ran without a warehouse
+	DatabaseName  string    `json:"database_name"`  // Empty when no database was in use
+	SchemaName    string    `json:"schema_name"`    // Empty when no schema was in use
+	RoleName      string    `json:"role_name"`      // Role the query ran as
+	QueryType     string    `json:"query_type"`     // e.g. SELECT, INSERT, COPY, CREATE_TABLE
+	ErrorCode     string    `json:"error_code"`
 	ErrorMessage  string    `json:"error_message"`
 	StartTime     time.Time `json:"start_time"`
 	EndTime       time.Time `json:"end_time"`
 	ExecutionTime float64   `json:"execution_time_seconds"`
+
+	// Cost attribution (zero when Snowflake recorded no value)
+	BytesScanned             int64   `json:"bytes_scanned"`
+	CreditsUsedCloudServices float64 `json:"credits_used_cloud_services"`
+
+	// Account the query ran in; empty unless DATA_SOURCE=organization_usage
+	AccountName string `json:"account_name"`
+
+	// Derived from ErrorMessage/ErrorCode by classifyError
+	Category string `json:"category"`
+
+	// Derived from ExecutionTime by classifyDuration
+	DurationBucket string `json:"duration_bucket"`
+
+	// Set when QueryText has been cut down to a preview (see withQueryTextPreview)
+	QueryTextTruncated bool `json:"query_text_truncated"`
+
+	// Set from the AckStore, not QUERY_HISTORY
+	Acknowledged bool `json:"acknowledged"`
+}
+
+// withQueryTextPreview returns a copy of queries with QueryText cut to at most
+// maxChars characters and QueryTextTruncated set where it was cut. Cached slices
+// are shared, so the input is never modified.
+func withQueryTextPreview(queries []FailedQuery, maxChars int) []FailedQuery {
+	previewed := make([]FailedQuery, len(queries))
+	for i, q := range queries {
+		if len(q.QueryText) > maxChars {
+			if runes := []rune(q.QueryText); len(runes) > maxChars {
+				q.QueryText = string(runes[:maxChars])
+				q.QueryTextTruncated = true
+			}
+		}
+		previewed[i] = q
+	}
+	return previewed
+}
+
+// parseFreshParam reads ?fresh= (default false); true bypasses the background snapshot and cache
+func parseFreshParam(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("fresh")
+	if v == "" {
+		return false, nil
+	}
+	fresh, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid fresh (must be true or false)")
+	}
+	return fresh, nil
+}
+
+// parseFullTextParam reads ?full_text= (default true); false returns query text previews
+func parseFullTextParam(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("full_text")
+	if v == "" {
+		return true, nil
+	}
+	fullText, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid full_text (must be true or false)")
+	}
+	return fullText, nil
+}
+
+// CategoryClass is the CSS class used to color-code the query's card
+func (q FailedQuery) CategoryClass() string {
+	return categoryClass(q.Category)
+}
+
+// BytesScannedHuman formats BytesScanned for display, e.g. "1.2 GB"
+func (q FailedQuery) BytesScannedHuman() string {
+	return formatBytes(q.BytesScanned)
+}
+
+// formatBytes renders a byte count with a decimal (1000-based) unit
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 5; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
+
+// errorCategory buckets failures whose error code is one of Codes or whose
+// message matches Pattern. The first matching entry wins, so order matters:
+// Snowflake reports syntax and permission errors as "SQL compilation error" too.
+type errorCategory struct {
+	Name    string
+	Codes   []string
+	Pattern *regexp.Regexp
+}
+
+// errorCategories is the classification table; add entries here to extend it
+var errorCategories = []errorCategory{
+	{
+		Name:    "Permission/Access",
+		Codes:   []string{"003001"}, // Insufficient privileges
+		Pattern: regexp.MustCompile(`(?i)insufficient privileges|not authorized|access denied|permission denied`),
+	},
+	{
+		Name:    "Timeout",
+		Codes:   []string{"000630"}, // Statement reached its statement or warehouse timeout
+		Pattern: regexp.MustCompile(`(?i)timeout|timed out`),
+	},
+	{
+		Name:    "Resource/Memory",
+		Pattern: regexp.MustCompile(`(?i)out of memory|memory limit|resource|quota|exceeded`),
+	},
+	{
+		Name:    "Syntax",
+		Codes:   []string{"001003"}, // SQL compilation error: syntax error
+		Pattern: regexp.MustCompile(`(?i)syntax error|parse error`),
+	},
+	{
+		Name:    "Compilation",
+		Pattern: regexp.MustCompile(`(?i)sql compilation error`),
+	},
+}
+
+// otherErrorCategory is reported for failures no entry in errorCategories matches
+const otherErrorCategory = "Other"
+
+// classifyError returns the errorCategories name for a failure, or "Other"
+func classifyError(msg, code string) string {
+	for _, category := range errorCategories {
+		for _, c := range category.Codes {
+			if c == code {
+				return category.Name
+			}
+		}
+		if category.Pattern.MatchString(msg) {
+			return category.Name
+		}
+	}
+	return otherErrorCategory
+}
+
+// errorCategoryNames lists every category classifyError can return, in table order
+func errorCategoryNames() []string {
+	names := make([]string, 0, len(errorCategories)+1)
+	for _, c := range errorCategories {
+		names = append(names, c.Name)
+	}
+	return append(names, otherErrorCategory)
+}
+
+// durationBucket groups failures by how long they ran before failing, so timeouts
+// and resource errors stand apart from instant compilation errors. Min is inclusive
+// and Max exclusive, both in seconds; a zero Max means unbounded.
+type durationBucket struct {
+	Name  string // ?duration_bucket= value
+	Label string
+	Min   float64
+	Max   float64
+}
+
+// durationBuckets covers every execution time, shortest first
+var durationBuckets = []durationBucket{
+	{Name: "instant", Label: "<1s", Max: 1},
+	{Name: "short", Label: "1-10s", Min: 1, Max: 10},
+	{Name: "medium", Label: "10-60s", Min: 10, Max: 60},
+	{Name: "long", Label: ">60s", Min: 60},
+}
+
+// classifyDuration returns the name of the durationBuckets entry holding seconds
+func classifyDuration(seconds float64) string {
+	for _, b := range durationBuckets {
+		if b.Max == 0 || seconds < b.Max {
+			return b.Name
+		}
+	}
+	return durationBuckets[len(durationBuckets)-1].Name
+}
+
+// durationBucketNames lists every name classifyDuration can return, shortest first
+func durationBucketNames() []string {
+	names := make([]string, 0, len(durationBuckets))
+	for _, b := range durationBuckets {
+		names = append(names, b.Name)
+	}
+	return names
+}
+
+// findDurationBucket looks up a durationBuckets entry by name
+func findDurationBucket(name string) (durationBucket, bool) {
+	for _, b := range durationBuckets {
+		if b.Name == name {
+			return b, true
+		}
+	}
+	return durationBucket{}, false
+}
+
+// DurationBucketCount is how many failures fell in one durationBuckets entry
+type DurationBucketCount struct {
+	Name  string
+	Label string
+	Count int
+}
+
+// countDurationBuckets counts queries per duration bucket, listing every bucket shortest first
+func countDurationBuckets(queries []FailedQuery) []DurationBucketCount {
+	counts := make([]DurationBucketCount, len(durationBuckets))
+	for i, b := range durationBuckets {
+		counts[i] = DurationBucketCount{Name: b.Name, Label: b.Label}
+	}
+	for _, q := range queries {
+		for i := range counts {
+			if counts[i].Name == q.DurationBucket {
+				counts[i].Count++
+				break
+			}
+		}
+	}
+	return counts
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+// categoryClass turns a category name into a CSS class, e.g. "category-permission-access"
+func categoryClass(category string) string {
+	return "category-" + nonAlphanumeric.ReplaceAllString(strings.ToLower(category), "-")
+}
+
+// parseCategoryParam reads the optional ?category= filter
+func parseCategoryParam(r *http.Request) (string, error) {
+	category := r.URL.Query().Get("category")
+	if category == "" {
+		return "", nil
+	}
+	for _, name := range errorCategoryNames() {
+		if category == name {
+			return category, nil
+		}
+	}
+	return "", fmt.Errorf("invalid category (must be one of: %s)", strings.Join(errorCategoryNames(), ", "))
+}
+
+// withCategory keeps only the queries classified as category
+func withCategory(queries []FailedQuery, category string) []FailedQuery {
+	kept := []FailedQuery{}
+	for _, q := range queries {
+		if q.Category == category {
+			kept = append(kept, q)
+		}
+	}
+	return kept
 }
 
 type AuthType string
@@ -40,9 +343,21 @@ type AuthType string
 const (
 	AuthTypePassword AuthType = "password"
 	AuthTypeKeyPair  AuthType = "keypair"
+	// AuthTypeExternalBrowser uses SSO via the driver's external browser flow.
+	// It opens a browser window on the host running the dashboard, so it is
+	// only suitable for local, interactive use.
+	AuthTypeExternalBrowser AuthType = "externalbrowser"
+	AuthTypeOAuth           AuthType = "oauth"
+	// AuthTypePasswordMFA is password authentication for users enrolled in MFA. The
+	// driver caches the MFA token, so only the first login of a user waits for a push.
+	AuthTypePasswordMFA AuthType = "username_password_mfa"
 )
 
-type Config struct {
+// AccountConfig holds the connection settings for one Snowflake account
+type AccountConfig struct {
+	// Name identifies the account in the dashboard and the ?account= parameter
+	Name string
+
 	// Common fields
 	Account   string
 	User      string
@@ -51,6 +366,14 @@ type Config struct {
 	Warehouse string
 	Role      string
 
+	// Optional endpoint overrides for PrivateLink or proxies; the host is derived from Account when unset
+	Host   string // Replaces <account>.snowflakecomputing.com entirely
+	Port   int    // Port on Host (0 means 443)
+	Region string // Region used to derive the host; only when Host is unset
+
+	// Snowsight address used for "Open in Snowflake" links (derived from Account unless overridden)
+	UIBaseURL string
+
 	// Authentication type
 	AuthType AuthType
 
@@ -59,1030 +382,11034 @@ type Config struct {
 
 	// Key-pair auth fields
 	PrivateKeyPath       string
+	PrivateKeyDir        string // Directory of rotated .p8 keys, tried newest first
 	PrivateKeyContent    string // Base64-encoded PEM content
 	PrivateKeyPassphrase string
+
+	// OAuth auth fields
+	OAuthToken string
+
+	// QUERY_TAG set on every session so the dashboard's own queries are identifiable
+	QueryTag string
+
+	// Role switched to with USE ROLE on every session after login, for reading ACCOUNT_USAGE
+	// with more privilege than Role (empty keeps the login role)
+	QueryRole string
+
+	// Warehouse switched to with USE WAREHOUSE on every session, so the dashboard's polling
+	// runs on its own (typically XSMALL) warehouse instead of Warehouse (empty keeps Warehouse)
+	DashboardWarehouse string
+
+	// Assignments (e.g. "TIMEZONE = 'UTC'") applied with ALTER SESSION SET on every session
+	SessionParams []string
 }
 
-// getSecretOrEnv reads a value from Docker secrets (/run/secrets/) or falls back to environment variable
-// This provides backward compatibility with environment variables while supporting Docker secrets
-func getSecretOrEnv(secretName, envName string) string {
-	// Try Docker secret first
-	secretPath := filepath.Join("/run/secrets", secretName)
-	if data, err := os.ReadFile(secretPath); err == nil {
-		// Trim whitespace/newlines from secret files
-		return strings.TrimSpace(string(data))
-	}
+// SMTPSettings is the mail server the failure digests are sent through
+type SMTPSettings struct {
+	Host     string // Empty disables email
+	Port     int    // 465 uses implicit TLS; other ports upgrade with STARTTLS when offered
+	Username string // Empty skips authentication
+	Password string
+	From     *mail.Address
+}
 
-	// Fall back to environment variable
-	return os.Getenv(envName)
+// PoolSettings tunes the connection pool opened for each account
+type PoolSettings struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
 }
 
-func loadConfig() (*Config, error) {
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using environment variables")
-	}
+type Config struct {
+	// Snowflake accounts to query; the first one is shown by default
+	Accounts []AccountConfig
 
-	authType := AuthType(os.Getenv("SNOWFLAKE_AUTH_TYPE"))
-	if authType == "" {
-		authType = AuthTypePassword // Default to password auth
-	}
+	// Connection pool settings, applied per account
+	Pool PoolSettings
 
-	config := &Config{
-		Account:   os.Getenv("SNOWFLAKE_ACCOUNT"),
-		User:      os.Getenv("SNOWFLAKE_USER"),
-		Database:  os.Getenv("SNOWFLAKE_DATABASE"),
-		Schema:    os.Getenv("SNOWFLAKE_SCHEMA"),
-		Warehouse: os.Getenv("SNOWFLAKE_WAREHOUSE"),
-		Role:      os.Getenv("SNOWFLAKE_ROLE"),
-		AuthType:  authType,
-	}
+	// Query settings
+	LookbackHours int // How far back to look for failed queries
+	RowLimit      int // Maximum number of rows returned per query
 
-	// Validate common fields
-	if config.Account == "" || config.User == "" {
-		return nil, fmt.Errorf("SNOWFLAKE_ACCOUNT and SNOWFLAKE_USER are required")
-	}
+	// Characters of query text shown on dashboard cards and in ?full_text=false responses
+	QueryTextPreviewChars int
 
-	// Validate based on auth type
-	switch authType {
-	case AuthTypePassword:
-		// Read password from Docker secret or environment variable
-		config.Password = getSecretOrEnv("snowflake_password", "SNOWFLAKE_PASSWORD")
-		if config.Password == "" {
-			return nil, fmt.Errorf("SNOWFLAKE_PASSWORD is required for password authentication (provide via /run/secrets/snowflake_password or SNOWFLAKE_PASSWORD env var)")
-		}
-	case AuthTypeKeyPair:
-		config.PrivateKeyPath = os.Getenv("SNOWFLAKE_PRIVATE_KEY_PATH")
-		config.PrivateKeyContent = os.Getenv("SNOWFLAKE_PRIVATE_KEY_CONTENT")
-		// Read passphrase from Docker secret or environment variable
-		config.PrivateKeyPassphrase = getSecretOrEnv("snowflake_private_key_passphrase", "SNOWFLAKE_PRIVATE_KEY_PASSPHRASE")
+	// Hard cap on the bytes of query text kept per row, full text included
+	MaxQueryTextBytes int
 
-		if config.PrivateKeyPath == "" && config.PrivateKeyContent == "" {
-			return nil, fmt.Errorf("either SNOWFLAKE_PRIVATE_KEY_PATH or SNOWFLAKE_PRIVATE_KEY_CONTENT is required for key-pair authentication")
-		}
-	default:
-		return nil, fmt.Errorf("invalid SNOWFLAKE_AUTH_TYPE: %s (must be 'password' or 'keypair')", authType)
-	}
+	// Widest absolute ?start=/?end= range a client may request
+	MaxTimeRange time.Duration
 
-	return config, nil
-}
+	// Dashboard auto-refresh interval in seconds
+	RefreshIntervalSeconds int
 
-// parsePrivateKey loads and parses the RSA private key from file or base64 content
-func parsePrivateKey(config *Config) (*rsa.PrivateKey, error) {
-	var pemBytes []byte
-	var err error
+	// ILIKE patterns for known-noisy queries to hide (from QUERY_EXCLUDE_PATTERNS)
+	ExcludePatterns []string
 
-	// Get PEM bytes from file or env var
-	if config.PrivateKeyPath != "" {
-		pemBytes, err = os.ReadFile(config.PrivateKeyPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read private key file: %w", err)
-		}
-	} else if config.PrivateKeyContent != "" {
-		// Decode base64-encoded key content
-		pemBytes, err = base64.StdEncoding.DecodeString(config.PrivateKeyContent)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode base64 private key: %w", err)
-		}
-	}
+	// Six-digit error codes whose failures are hidden (from EXCLUDE_ERROR_CODES)
+	ExcludeErrorCodes []string
 
-	// Security: Clear PEM bytes from memory after parsing
-	defer func() {
-		for i := range pemBytes {
-			pemBytes[i] = 0
-		}
-	}()
+	// Optional query card fields shown on the dashboard (from VISIBLE_COLUMNS)
+	VisibleColumns map[string]bool
 
-	// Decode PEM block
-	block, _ := pem.Decode(pemBytes)
-	if block == nil {
-		return nil, errors.New("failed to parse PEM block containing the private key")
-	}
+	// USER_NAME ILIKE pattern behind the dashboard's service account toggle (SERVICE_ACCOUNT_PATTERN)
+	ServiceAccountPattern string
 
-	// Security: Clear PEM block bytes from memory after use
-	defer func() {
-		if block != nil && block.Bytes != nil {
-			for i := range block.Bytes {
-				block.Bytes[i] = 0
-			}
-		}
-	}()
+	// Page title and logo of the HTML pages (DASHBOARD_TITLE, DASHBOARD_LOGO_URL)
+	Branding Branding
 
-	// Handle encrypted vs unencrypted keys
-	var privateKeyBytes []byte
+	// Windows counted by /api/overview, shortest first (from OVERVIEW_WINDOWS)
+	OverviewWindows []overviewWindow
 
-	if x509.IsEncryptedPEMBlock(block) {
-		// Legacy PEM encryption (PKCS#1 with DEK-Info)
-		if config.PrivateKeyPassphrase == "" {
-			return nil, errors.New("private key is encrypted but no passphrase provided (set SNOWFLAKE_PRIVATE_KEY_PASSPHRASE)")
-		}
-		privateKeyBytes, err = x509.DecryptPEMBlock(block, []byte(config.PrivateKeyPassphrase))
-		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt PEM block: %w", err)
-		}
-		// Security: Clear decrypted key bytes after parsing
-		defer func() {
-			for i := range privateKeyBytes {
-				privateKeyBytes[i] = 0
-			}
-		}()
-	} else if block.Type == "ENCRYPTED PRIVATE KEY" {
-		// Modern PKCS#8 encryption
-		if config.PrivateKeyPassphrase == "" {
-			return nil, errors.New("private key is encrypted but no passphrase provided (set SNOWFLAKE_PRIVATE_KEY_PASSPHRASE)")
-		}
-		// Use github.com/youmark/pkcs8 for PKCS#8 decryption
-		privateKey, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(config.PrivateKeyPassphrase))
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse encrypted PKCS8 private key: %w", err)
-		}
-		rsaKey, ok := privateKey.(*rsa.PrivateKey)
-		if !ok {
-			return nil, fmt.Errorf("private key is not RSA type, got %T", privateKey)
-		}
-		return rsaKey, nil
-	} else {
-		// Unencrypted key
-		privateKeyBytes = block.Bytes
-	}
+	// Databases given their own dashboard tab, upper-cased (from MONITORED_DATABASES)
+	MonitoredDatabases []string
 
-	// Security: Clear private key bytes after parsing
-	defer func() {
-		for i := range privateKeyBytes {
-			privateKeyBytes[i] = 0
-		}
-	}()
+	// ACCOUNT_USAGE lag beyond which the dashboard warns that data may be delayed
+	StaleDataThreshold time.Duration
 
-	// Parse unencrypted PKCS#8 or PKCS#1
-	privateKey, err := x509.ParsePKCS8PrivateKey(privateKeyBytes)
-	if err != nil {
-		// Try PKCS#1 format as fallback
-		return x509.ParsePKCS1PrivateKey(privateKeyBytes)
-	}
+	// Regexes whose matches in query text and error messages are replaced with
+	// [REDACTED] before anything leaves the server (from REDACT_PATTERNS)
+	RedactPatterns []*regexp.Regexp
 
-	rsaKey, ok := privateKey.(*rsa.PrivateKey)
-	if !ok {
-		return nil, fmt.Errorf("private key is not RSA type, got %T", privateKey)
-	}
+	// Caching (0 disables the cache)
+	CacheTTL time.Duration
 
-	return rsaKey, nil
-}
+	// Fixed zone for displayed timestamps (nil keeps Snowflake's zone and the browser's locale)
+	DisplayLocation *time.Location
 
-func getSnowflakeConnection(config *Config) (*sql.DB, *rsa.PrivateKey, error) {
-	var dsn string
-	var err error
-	var privateKey *rsa.PrivateKey
+	// Local SQLite history of failures (disabled when the path is unset)
+	HistoryDBPath       string
+	HistoryPollInterval time.Duration
 
-	switch config.AuthType {
-	case AuthTypePassword:
-		// Security Fix #2: URL encode password to prevent it from appearing in logs
-		// and to handle special characters properly
-		dsn = fmt.Sprintf("%s:%s@%s/%s/%s?warehouse=%s&role=%s",
-			url.QueryEscape(config.User),
-			url.QueryEscape(config.Password),
-			config.Account,
-			config.Database,
-			config.Schema,
-			url.QueryEscape(config.Warehouse),
-			url.QueryEscape(config.Role),
-		)
+	// SQLite file for triage acknowledgments (disabled when unset)
+	AckDBPath string
 
-	case AuthTypeKeyPair:
-		// Load and parse private key
-		privateKey, err = parsePrivateKey(config)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to load private key: %w", err)
-		}
+	// Fetch execution metrics for the query detail page (QUERY_METRICS_ENABLED, off by default)
+	QueryMetrics bool
 
-		// Build config using gosnowflake.Config
-		sfConfig := &gosnowflake.Config{
-			Account:       config.Account,
-			User:          config.User,
-			Authenticator: gosnowflake.AuthTypeJwt,
-			PrivateKey:    privateKey,
-			Database:      config.Database,
-			Schema:        config.Schema,
-			Warehouse:     config.Warehouse,
-			Role:          config.Role,
-		}
+	// QUERY_HISTORY view to read (DATA_SOURCE): account_usage, or organization_usage for every account in the organization
+	DataSource queryHistorySource
 
-		dsn, err = gosnowflake.DSN(sfConfig)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to build DSN for key-pair auth: %w", err)
-		}
+	// Background SELECT 1 on each account's pool (KEEPALIVE_ENABLED, off by default)
+	KeepAlive         bool
+	KeepAliveInterval time.Duration
 
-	default:
-		return nil, nil, fmt.Errorf("unsupported auth type: %s", config.AuthType)
-	}
+	// Per-user email digest of each user's own failures (disabled unless SMTP_HOST and
+	// DIGEST_EMAILS or DIGEST_EMAIL_DOMAIN are set)
+	SMTP              SMTPSettings
+	DigestSchedule    digestSchedule
+	DigestEmails      map[string]string // Upper-cased USER_NAME to email address
+	DigestEmailDomain string            // Appended to user names without a DIGEST_EMAILS entry
 
-	db, err := sql.Open("snowflake", dsn)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open snowflake connection: %w", err)
-	}
+	// Slack alerting for new failures (disabled when the webhook URL is unset)
+	SlackWebhookURL string
+	AlertInterval   time.Duration
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// Microsoft Teams alerting for new failures (disabled when the webhook URL is unset)
+	TeamsWebhookURL string
 
-	if err := db.PingContext(ctx); err != nil {
-		return nil, nil, fmt.Errorf("failed to ping snowflake: %w", err)
-	}
+	// Generic JSON webhook for new failures (disabled when the URL is unset)
+	AlertWebhookURL string
 
-	// Configure connection pool to prevent resource exhaustion and enable credential rotation
-	db.SetMaxOpenConns(10)                     // Limit concurrent connections to prevent database overload
-	db.SetMaxIdleConns(5)                      // Keep some connections ready for reuse
-	db.SetConnMaxLifetime(5 * time.Minute)     // Rotate connections (enables credential rotation)
-	db.SetConnMaxIdleTime(1 * time.Minute)     // Close idle connections after 1 minute
+	// Alert sinks to enable by name (NOTIFIERS); every configured sink when empty
+	Notifiers []string
 
-	return db, privateKey, nil
-}
+	// Externally reachable base URL of the dashboard, used for links in alerts (no links when unset)
+	DashboardURL string
 
-// Security Fix #3: Clear sensitive data from memory
-func clearSensitiveData(config *Config) {
-	// Clear password
-	if config.Password != "" {
-		passwordBytes := []byte(config.Password)
-		for i := range passwordBytes {
-			passwordBytes[i] = 0
-		}
-		config.Password = ""
-	}
+	// PagerDuty paging when new failures per poll exceed AlertThreshold (disabled when the routing key is unset)
+	PagerDutyRoutingKey string
+	AlertThreshold      int
 
-	// Clear passphrase
-	if config.PrivateKeyPassphrase != "" {
-		passphraseBytes := []byte(config.PrivateKeyPassphrase)
-		for i := range passphraseBytes {
-			passphraseBytes[i] = 0
-		}
-		config.PrivateKeyPassphrase = ""
-	}
-}
+	// Retries for transient Snowflake errors
+	QueryRetries   int           // Extra attempts after the first failure (0 disables retries)
+	RetryBaseDelay time.Duration // Delay before the first retry; doubled on each subsequent one
 
-// clearPrivateKey zeroes out RSA private key material from memory
-// This prevents the private key from being extracted via memory dumps after it's no longer needed
-func clearPrivateKey(key *rsa.PrivateKey) {
-	if key == nil {
-		return
-	}
+	// Startup connection attempts per account, so a brief Snowflake outage doesn't crash-loop the container
+	ConnectAttempts   int           // Total attempts before giving up (1 disables retries)
+	ConnectRetryDelay time.Duration // Delay after the first failed attempt; doubled on each subsequent one
 
-	// Zero out the private exponent (D) - the most sensitive part of the private key
-	if key.D != nil {
-		key.D.SetInt64(0)
+	// Retry-After sent with 503s while the warehouse resumes or Snowflake times out
+	WarehouseRetryAfter time.Duration
+
+	// Deadlines for Snowflake calls
+	QueryTimeout time.Duration // One query made for a request or background poller
+	PingTimeout  time.Duration // Verifying a new connection at startup (externalbrowser always gets longer)
+
+	// TLS (served over plain HTTP when unset)
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Origins allowed to call the JSON API cross-origin ("*" for any; no CORS headers when empty)
+	CORSAllowedOrigins []string
+
+	// Keys accepted for /api/ requests (the API is open when empty)
+	APIKeys []string
+
+	// Per-client-IP rate limiting (disabled when RateLimitPerMinute is 0)
+	RateLimitPerMinute int
+	RateLimitBurst     int
+	TrustedProxies     []netip.Prefix // Reverse proxies whose X-Forwarded-For is believed
+
+	// Log one line per HTTP request (ACCESS_LOG, on by default)
+	AccessLog bool
+}
+
+const (
+	defaultLookbackHours = 24
+	maxLookbackHours     = 720 // 30 days
+
+	defaultRowLimit = 1000
+	maxRowLimit     = 10000
+
+	maxOverviewWindows = 6
+
+	maxMonitoredDatabases = 20
+
+	defaultQueryTextPreviewChars = 2000
+	minQueryTextPreviewChars     = 100
+	maxQueryTextPreviewChars     = 1000000
+
+	defaultMaxQueryTextBytes = 1 << 20 // 1 MiB
+	minMaxQueryTextBytes     = 1024
+	maxMaxQueryTextBytes     = 16 << 20
+
+	maxCacheTTLSeconds = 3600
+
+	defaultMaxRangeHours = 168  // 7 days
+	maxMaxRangeHours     = 8760 // QUERY_HISTORY retains one year
+
+	defaultRefreshIntervalSeconds = 30
+	minRefreshIntervalSeconds     = 5 // Keeps dashboards from hammering Snowflake
+	maxRefreshIntervalSeconds     = 3600
+
+	defaultQueryRetries     = 3
+	maxQueryRetries         = 10
+	defaultRetryBaseDelayMs = 500
+	maxRetryBaseDelayMs     = 30000
+	maxRetryDelay           = 30 * time.Second // Cap on a single backoff interval
+
+	defaultConnectAttempts          = 5
+	maxConnectAttempts              = 100
+	defaultConnectRetryDelaySeconds = 2
+	maxConnectRetryDelaySeconds     = 300
+	maxConnectRetryDelay            = 5 * time.Minute // Cap on a single startup backoff interval
+
+	defaultQueryTimeoutSeconds = 30
+	maxQueryTimeoutSeconds     = 3600
+	defaultPingTimeoutSeconds  = 10
+	maxPingTimeoutSeconds      = 300
+
+	defaultWarehouseRetryAfterSeconds = 15
+	maxWarehouseRetryAfterSeconds     = 300
+
+	maxRateLimitPerMinute = 100000
+	defaultRateLimitBurst = 20
+	maxRateLimitBurst     = 10000
+
+	defaultMaxOpenConns    = 10
+	defaultMaxIdleConns    = 5
+	maxPoolConns           = 100
+	defaultConnMaxLifetime = 5 * time.Minute
+	defaultConnMaxIdleTime = 1 * time.Minute
+
+	defaultHistoryIntervalSeconds = 300
+	minHistoryIntervalSeconds     = 10
+	maxHistoryIntervalSeconds     = 3600
+	historyMaxRange               = 366 * 24 * time.Hour // Widest ?start=/?end= range for /api/history
+
+	defaultAlertIntervalSeconds = 60
+	minAlertIntervalSeconds     = 10
+	maxAlertIntervalSeconds     = 3600
+
+	defaultAlertThreshold = 10
+	maxAlertThreshold     = 100000
+)
+
+// defaultExcludePatterns hide Snowflake's own internal metadata queries
+var defaultExcludePatterns = []string{
+	"%SHOW GRANTS OF DATABASE ROLE%",
+	"%IDENTIFIER(%SNOWFLAKE%",
+}
+
+// cancelledErrorCode is Snowflake's "SQL execution canceled", reported when a user or
+// client cancels a running statement; the dashboard's "Include cancellations" toggle controls it
+const cancelledErrorCode = "000604"
+
+// validErrorCode matches Snowflake error codes with or without their leading zeros
+var validErrorCode = regexp.MustCompile(`^[0-9]{1,6}$`)
+
+// parseErrorCodes validates EXCLUDE_ERROR_CODES entries and zero-pads them to the
+// six digits QUERY_HISTORY.ERROR_CODE uses, so 604 and 000604 are the same code
+func parseErrorCodes(entries []string) ([]string, error) {
+	codes := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !validErrorCode.MatchString(entry) {
+			return nil, fmt.Errorf("EXCLUDE_ERROR_CODES: %q is not a numeric Snowflake error code", entry)
+		}
+		codes = append(codes, fmt.Sprintf("%06s", entry))
 	}
+	return codes, nil
+}
 
-	// Clear the prime factors - these can be used to reconstruct the private key
-	if key.Primes != nil {
-		for i := range key.Primes {
-			if key.Primes[i] != nil {
-				key.Primes[i].SetInt64(0)
-			}
+// cardColumns are the optional fields of a dashboard query card, named like their JSON
+// fields; VISIBLE_COLUMNS picks among them. The user, query ID, category, and error
+// message are always shown.
+var cardColumns = []string{
+	"start_time",
+	"warehouse_name",
+	"role_name",
+	"database_name", // With the schema, as DATABASE.SCHEMA
+	"bytes_scanned",
+	"credits_used_cloud_services",
+	"execution_time_seconds",
+	"error_code",
+	"query_text",
+}
+
+// parseVisibleColumns validates VISIBLE_COLUMNS entries against cardColumns
+func parseVisibleColumns(entries []string) (map[string]bool, error) {
+	visible := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		name := strings.ToLower(entry)
+		if !slices.Contains(cardColumns, name) {
+			return nil, fmt.Errorf("VISIBLE_COLUMNS: unknown column %q (must be one of %s)", entry, strings.Join(cardColumns, ", "))
 		}
-		key.Primes = nil
+		visible[name] = true
 	}
+	return visible, nil
+}
 
-	// Clear precomputed values used for CRT optimization
-	if key.Precomputed.Dp != nil {
-		key.Precomputed.Dp.SetInt64(0)
+// defaultOverviewWindows are the windows /api/overview counts when OVERVIEW_WINDOWS is unset
+var defaultOverviewWindows = []string{"1h", "24h", "7d"}
+
+var validOverviewWindow = regexp.MustCompile(`^([1-9][0-9]{0,3})([hd])$`)
+
+// overviewWindow is one trailing window counted by /api/overview
+type overviewWindow struct {
+	Label string // As configured, e.g. "24h" or "7d"
+	Hours int
+}
+
+// parseOverviewWindows validates OVERVIEW_WINDOWS entries (hours like 24h or days like 7d)
+// and returns them shortest first, without duplicates
+func parseOverviewWindows(entries []string) ([]overviewWindow, error) {
+	if len(entries) > maxOverviewWindows {
+		return nil, fmt.Errorf("OVERVIEW_WINDOWS: at most %d windows are allowed", maxOverviewWindows)
 	}
-	if key.Precomputed.Dq != nil {
-		key.Precomputed.Dq.SetInt64(0)
+	windows := make([]overviewWindow, 0, len(entries))
+	seen := make(map[int]bool)
+	for _, entry := range entries {
+		m := validOverviewWindow.FindStringSubmatch(strings.ToLower(entry))
+		if m == nil {
+			return nil, fmt.Errorf("OVERVIEW_WINDOWS: %q is not a window like 24h or 7d", entry)
+		}
+		hours, _ := strconv.Atoi(m[1])
+		if m[2] == "d" {
+			hours *= 24
+		}
+		if hours > maxLookbackHours {
+			return nil, fmt.Errorf("OVERVIEW_WINDOWS: %q is longer than %d hours", entry, maxLookbackHours)
+		}
+		if seen[hours] {
+			continue
+		}
+		seen[hours] = true
+		windows = append(windows, overviewWindow{Label: m[0], Hours: hours})
 	}
-	if key.Precomputed.Qinv != nil {
-		key.Precomputed.Qinv.SetInt64(0)
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Hours < windows[j].Hours })
+	return windows, nil
+}
+
+// Branding is the title and logo shown on every HTML page. The templates escape both,
+// and LogoURL is only trusted as a URL after loadBranding has checked its scheme.
+type Branding struct {
+	Title   string
+	LogoURL template.URL // Header logo and favicon; "" keeps the ❄️ and default favicon
+}
+
+// defaultDashboardTitle is used when DASHBOARD_TITLE is unset
+const defaultDashboardTitle = "Failed Snowflake Queries"
+
+// maxDashboardTitleLength caps DASHBOARD_TITLE in characters
+const maxDashboardTitleLength = 100
+
+// defaultFavicon is a ❄️ in Snowflake blue, inline so it needs no extra request
+const defaultFavicon template.URL = `data:image/svg+xml,<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100"><rect width="100" height="100" rx="20" fill="%2329B5E8"/><text x="50" y="72" font-size="60" text-anchor="middle">❄️</text></svg>`
+
+// FaviconURL is the configured logo, or the default ❄️ icon
+func (b Branding) FaviconURL() template.URL {
+	if b.LogoURL != "" {
+		return b.LogoURL
 	}
-	if key.Precomputed.CRTValues != nil {
-		for i := range key.Precomputed.CRTValues {
-			if key.Precomputed.CRTValues[i].Exp != nil {
-				key.Precomputed.CRTValues[i].Exp.SetInt64(0)
-			}
-			if key.Precomputed.CRTValues[i].Coeff != nil {
-				key.Precomputed.CRTValues[i].Coeff.SetInt64(0)
-			}
-			if key.Precomputed.CRTValues[i].R != nil {
-				key.Precomputed.CRTValues[i].R.SetInt64(0)
-			}
+	return defaultFavicon
+}
+
+// logoOrigin returns the scheme and host the logo is loaded from, for the
+// Content-Security-Policy; "" when there's no logo or it's a data: URL
+func (b Branding) logoOrigin() string {
+	u, err := url.Parse(string(b.LogoURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// loadBranding reads DASHBOARD_TITLE and DASHBOARD_LOGO_URL. The logo must be an
+// absolute http(s) URL or a data:image/ URL, so it can't smuggle in javascript:.
+func loadBranding() (Branding, error) {
+	branding := Branding{Title: defaultDashboardTitle}
+	if title := strings.TrimSpace(os.Getenv("DASHBOARD_TITLE")); title != "" {
+		if len([]rune(title)) > maxDashboardTitleLength {
+			return branding, fmt.Errorf("DASHBOARD_TITLE must be at most %d characters", maxDashboardTitleLength)
+		}
+		branding.Title = title
+	}
+
+	logo := strings.TrimSpace(os.Getenv("DASHBOARD_LOGO_URL"))
+	if logo == "" {
+		return branding, nil
+	}
+	if !strings.HasPrefix(logo, "data:image/") {
+		u, err := url.Parse(logo)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return branding, errors.New("DASHBOARD_LOGO_URL must be an absolute http(s) URL or a data:image/ URL")
 		}
-		key.Precomputed.CRTValues = nil
 	}
+	branding.LogoURL = template.URL(logo)
+	return branding, nil
 }
 
-// Security Fix #5: Add security headers middleware
-func securityHeaders(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Content Security Policy - only allow inline scripts from same origin
-		// This prevents XSS attacks by restricting script sources
-		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline' 'self'; style-src 'unsafe-inline' 'self'; img-src 'self' data:; font-src 'self'; connect-src 'self'; frame-ancestors 'none'")
+// parseMonitoredDatabases validates MONITORED_DATABASES, keeping the configured
+// order (the order of the tabs) and dropping duplicates
+func parseMonitoredDatabases(entries []string) ([]string, error) {
+	if len(entries) > maxMonitoredDatabases {
+		return nil, fmt.Errorf("MONITORED_DATABASES: at most %d databases are allowed", maxMonitoredDatabases)
+	}
+	var databases []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if !validDatabaseName.MatchString(entry) {
+			return nil, fmt.Errorf("MONITORED_DATABASES: %q is not an unquoted database name", entry)
+		}
+		name := strings.ToUpper(entry)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		databases = append(databases, name)
+	}
+	return databases, nil
+}
 
-		// Prevent MIME type sniffing
-		w.Header().Set("X-Content-Type-Options", "nosniff")
+// getSecretOrEnv reads a secret from the file named by <envName>_FILE, then AWS Secrets
+// Manager (<envName>_SECRET_ARN), then Vault (<envName>_VAULT_PATH), then Docker secrets
+// (/run/secrets/), then the environment variable itself. The _FILE variant covers secrets
+// mounted elsewhere, such as Kubernetes projected volumes or CSI secret drivers. A value of
+// the form aws-secrets://<name> or vault://<path> from any source is replaced with that
+// secret (see resolveSecretRef).
+func getSecretOrEnv(secretName, envName string) (string, error) {
+	value, err := readSecretOrEnv(secretName, envName)
+	if err != nil {
+		return "", err
+	}
+	return resolveSecretRef(value, envName)
+}
 
-		// Prevent clickjacking attacks
-		w.Header().Set("X-Frame-Options", "DENY")
+// resolveSecretRef fetches the secret a value refers to when it starts with aws-secrets://
+// or vault://, and returns any other value unchanged. setting names its source, for errors.
+func resolveSecretRef(value, setting string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, awsSecretPrefix):
+		return getAWSSecret(strings.TrimPrefix(value, awsSecretPrefix), setting)
+	case strings.HasPrefix(value, vaultSecretPrefix):
+		return getVaultSecret(strings.TrimPrefix(value, vaultSecretPrefix), setting)
+	default:
+		return value, nil
+	}
+}
 
-		// Enable XSS protection in older browsers
-		w.Header().Set("X-XSS-Protection", "1; mode=block")
+func readSecretOrEnv(secretName, envName string) (string, error) {
+	// An explicit file path wins, and must be readable: silently falling back would hide a broken mount
+	if path := os.Getenv(envName + "_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading %s_FILE: %w", envName, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
 
-		// Control referrer information
-		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
+	// Then AWS Secrets Manager, which must also succeed once configured
+	if arn := os.Getenv(envName + "_SECRET_ARN"); arn != "" {
+		return getAWSSecret(arn, envName+"_SECRET_ARN")
+	}
+	if path := os.Getenv(envName + "_VAULT_PATH"); path != "" {
+		return getVaultSecret(path, envName+"_VAULT_PATH")
+	}
 
-		// Permissions policy - disable unnecessary features
-		w.Header().Set("Permissions-Policy", "geolocation=(), microphone=(), camera=()")
+	// Then Docker secrets
+	secretPath := filepath.Join("/run/secrets", secretName)
+	if data, err := os.ReadFile(secretPath); err == nil {
+		// Trim whitespace/newlines from secret files
+		return strings.TrimSpace(string(data)), nil
+	}
 
-		next(w, r)
+	// Fall back to environment variable
+	return os.Getenv(envName), nil
+}
+
+// awsSecretPrefix marks a secret value that names an AWS Secrets Manager secret instead
+const awsSecretPrefix = "aws-secrets://"
+
+// secretFetchTimeout bounds logging in and fetching one secret from AWS Secrets Manager or Vault at startup
+const secretFetchTimeout = 30 * time.Second
+
+// awsSecrets is the Secrets Manager client, created on first use from the standard AWS
+// configuration (AWS_REGION, AWS_PROFILE, instance or task roles, IRSA, ...)
+var awsSecrets struct {
+	once   sync.Once
+	client *secretsmanager.Client
+	err    error
+}
+
+// getAWSSecret fetches a secret's current value from AWS Secrets Manager. ref is a secret
+// name or ARN, optionally followed by #key to pick one field of a JSON key/value secret
+// (e.g. prod/snowflake#password). setting names the variable it came from, for errors.
+func getAWSSecret(ref, setting string) (string, error) {
+	secretID, key, _ := strings.Cut(ref, "#")
+	if secretID == "" {
+		return "", fmt.Errorf("%s: empty AWS Secrets Manager secret name", setting)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretFetchTimeout)
+	defer cancel()
+
+	awsSecrets.once.Do(func() {
+		cfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			awsSecrets.err = fmt.Errorf("loading AWS configuration: %w", err)
+			return
+		}
+		awsSecrets.client = secretsmanager.NewFromConfig(cfg)
+	})
+	if awsSecrets.err != nil {
+		return "", fmt.Errorf("%s: %w", setting, awsSecrets.err)
+	}
+
+	out, err := awsSecrets.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(secretID)})
+	if err != nil {
+		return "", fmt.Errorf("%s: fetching %s from AWS Secrets Manager: %w", setting, secretID, err)
+	}
+	value := string(out.SecretBinary)
+	if out.SecretString != nil {
+		value = *out.SecretString
+	}
+
+	if key != "" {
+		var fields map[string]string
+		if err := json.Unmarshal([]byte(value), &fields); err != nil {
+			return "", fmt.Errorf("%s: secret %s is not a JSON object of strings", setting, secretID)
+		}
+		field, ok := fields[key]
+		if !ok {
+			return "", fmt.Errorf("%s: secret %s has no key %q", setting, secretID, key)
+		}
+		value = field
 	}
+	return strings.TrimSpace(value), nil
 }
 
-// limitRequestSize middleware limits the size of incoming request bodies
-// to prevent memory exhaustion attacks from large payloads
-func limitRequestSize(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Limit request body to 1 MB
-		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
-		next(w, r)
+// vaultSecretPrefix marks a secret value that names a HashiCorp Vault secret instead
+const vaultSecretPrefix = "vault://"
+
+// defaultVaultK8sMount is where Vault's Kubernetes auth method is usually enabled
+const defaultVaultK8sMount = "kubernetes"
+
+// vaultK8sTokenPath is the pod's service account token, presented to Vault's Kubernetes auth
+const vaultK8sTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vault holds the Vault client state, set up on first use from VAULT_ADDR and either
+// VAULT_TOKEN or a Kubernetes auth login (VAULT_K8S_ROLE). Responses are kept per path,
+// so fields read from one dynamic credential (e.g. username and password from the
+// database secrets engine) come from the same lease. loadConfig drops all of it with
+// resetVault once every secret is resolved.
+var vault struct {
+	once   sync.Once
+	client *http.Client
+	addr   string
+	token  string
+	err    error
+
+	mu        sync.Mutex
+	responses map[string]map[string]interface{}
+}
+
+// vaultLogin resolves the Vault address and token, logging in with the pod's service
+// account when VAULT_TOKEN is unset
+func vaultLogin(ctx context.Context) error {
+	vault.addr = strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if vault.addr == "" {
+		return errors.New("VAULT_ADDR is required to read secrets from Vault")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile := os.Getenv("VAULT_CACERT"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("reading VAULT_CACERT: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return errors.New("VAULT_CACERT contains no PEM certificates")
+		}
+	}
+	vault.client = &http.Client{
+		Timeout:   secretFetchTimeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+
+	if vault.token = os.Getenv("VAULT_TOKEN"); vault.token != "" {
+		return nil
+	}
+	role := os.Getenv("VAULT_K8S_ROLE")
+	if role == "" {
+		return errors.New("set VAULT_TOKEN or VAULT_K8S_ROLE to authenticate to Vault")
+	}
+	mount := os.Getenv("VAULT_K8S_MOUNT")
+	if mount == "" {
+		mount = defaultVaultK8sMount
+	}
+	jwt, err := os.ReadFile(vaultK8sTokenPath)
+	if err != nil {
+		return fmt.Errorf("reading the Kubernetes service account token: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]string{"role": role, "jwt": strings.TrimSpace(string(jwt))})
+	if err != nil {
+		return err
 	}
+	var login struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := vaultRequest(ctx, http.MethodPost, "auth/"+strings.Trim(mount, "/")+"/login", body, &login); err != nil {
+		return fmt.Errorf("kubernetes login to Vault: %w", err)
+	}
+	if login.Auth.ClientToken == "" {
+		return errors.New("kubernetes login to Vault returned no token")
+	}
+	vault.token = login.Auth.ClientToken
+	return nil
 }
 
-func getFailedQueries(db *sql.DB) ([]FailedQuery, error) {
-	query := `
-		SELECT
-			QUERY_ID,
-			QUERY_TEXT,
-			USER_NAME,
-			ERROR_MESSAGE,
-			START_TIME,
-			END_TIME,
-			TOTAL_ELAPSED_TIME / 1000.0 as EXECUTION_TIME_SECONDS
-		FROM SNOWFLAKE.ACCOUNT_USAGE.QUERY_HISTORY
-		WHERE EXECUTION_STATUS = 'FAIL'
-			AND START_TIME >= DATEADD(hour, -24, CURRENT_TIMESTAMP())
-			AND QUERY_TEXT NOT ILIKE '%SHOW GRANTS OF DATABASE ROLE%'
-			AND QUERY_TEXT NOT ILIKE '%IDENTIFIER(%SNOWFLAKE%'
-		ORDER BY START_TIME DESC
-		LIMIT 1000
-	`
+// vaultRequest calls Vault's HTTP API at /v1/<path> and decodes the JSON response into out
+func vaultRequest(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, vault.addr+"/v1/"+strings.TrimPrefix(path, "/"), reader)
+	if err != nil {
+		return fmt.Errorf("invalid Vault request: %w", err)
+	}
+	if vault.token != "" {
+		req.Header.Set("X-Vault-Token", vault.token)
+	}
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	resp, err := vault.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&failure)
+		if len(failure.Errors) > 0 {
+			return fmt.Errorf("vault returned %s: %s", resp.Status, strings.Join(failure.Errors, "; "))
+		}
+		return fmt.Errorf("vault returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// resetVault forgets the Vault token and cached secret responses, so neither stays in
+// memory after loadConfig; a later getVaultSecret call logs in again
+func resetVault() {
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+	vault.once = sync.Once{}
+	vault.client = nil
+	vault.token = ""
+	vault.err = nil
+	clear(vault.responses)
+	vault.responses = nil
+}
+
+// getVaultSecret reads one field of a Vault secret. ref is the API path, e.g.
+// secret/data/snowflake#password for KV version 2, secret/snowflake#password for
+// version 1, or database/creds/dashboard#password for the database secrets engine.
+// #key may be omitted when the secret has a single field.
+func getVaultSecret(ref, setting string) (string, error) {
+	path, key, _ := strings.Cut(ref, "#")
+	if path = strings.Trim(path, "/"); path == "" {
+		return "", fmt.Errorf("%s: empty Vault secret path", setting)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretFetchTimeout)
 	defer cancel()
 
-	rows, err := db.QueryContext(ctx, query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query failed queries: %w", err)
+	vault.once.Do(func() { vault.err = vaultLogin(ctx) })
+	if vault.err != nil {
+		return "", fmt.Errorf("%s: %w", setting, vault.err)
 	}
-	defer rows.Close()
 
-	var queries []FailedQuery
-	for rows.Next() {
-		var q FailedQuery
-		if err := rows.Scan(
-			&q.QueryID,
-			&q.QueryText,
-			&q.UserName,
-			&q.ErrorMessage,
-			&q.StartTime,
-			&q.EndTime,
-			&q.ExecutionTime,
-		); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+	data, ok := vault.responses[path]
+	if !ok {
+		var secret struct {
+			Data map[string]interface{} `json:"data"`
 		}
-		queries = append(queries, q)
+		if err := vaultRequest(ctx, http.MethodGet, path, nil, &secret); err != nil {
+			return "", fmt.Errorf("%s: reading %s from Vault: %w", setting, path, err)
+		}
+		data = secret.Data
+		// KV version 2 nests the fields one level down, next to the version metadata
+		if inner, ok := data["data"].(map[string]interface{}); ok {
+			if _, ok := data["metadata"]; ok {
+				data = inner
+			}
+		}
+		if vault.responses == nil {
+			vault.responses = make(map[string]map[string]interface{})
+		}
+		vault.responses[path] = data
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating rows: %w", err)
+	if key == "" {
+		if len(data) != 1 {
+			return "", fmt.Errorf("%s: Vault secret %s has %d fields; pick one with #key", setting, path, len(data))
+		}
+		for k := range data {
+			key = k
+		}
 	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("%s: Vault secret %s has no string field %q", setting, path, key)
+	}
+	return strings.TrimSpace(value), nil
+}
 
-	return queries, nil
+// getListEnv reads a comma-separated environment variable, returning def when unset.
+// Entries are trimmed and empty entries are dropped.
+func getListEnv(envName string, def []string) []string {
+	v := os.Getenv(envName)
+	if v == "" {
+		return def
+	}
+
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
 }
 
-var htmlTemplate = `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Failed Snowflake Queries - Last 24 Hours</title>
-    <style>
-        * {
-            margin: 0;
-            padding: 0;
-            box-sizing: border-box;
-        }
-        body {
-            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
-            background: #f5f5f5;
-            color: #333;
-            line-height: 1.6;
-        }
-        .container {
-            max-width: 1400px;
-            margin: 0 auto;
-            padding: 20px;
-        }
-        header {
-            background: #29B5E8;
-            color: white;
-            padding: 30px 0;
-            margin-bottom: 30px;
-            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
-        }
-        header h1 {
-            text-align: center;
-            font-size: 2em;
-        }
-        .stats {
+// getBoolEnv reads a boolean environment variable (true/false, 1/0), returning def when unset
+func getBoolEnv(envName string, def bool) (bool, error) {
+	v := os.Getenv(envName)
+	if v == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %q (must be true or false)", envName, v)
+	}
+	return b, nil
+}
+
+// getIntEnv reads an integer environment variable, returning def when unset
+// and an error when the value is non-numeric or outside [minVal, maxVal]
+func getIntEnv(envName string, def, minVal, maxVal int) (int, error) {
+	v := os.Getenv(envName)
+	if v == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q (must be a whole number)", envName, v)
+	}
+	if n < minVal || n > maxVal {
+		return 0, fmt.Errorf("invalid %s: %d (must be between %d and %d)", envName, n, minVal, maxVal)
+	}
+
+	return n, nil
+}
+
+// getDurationEnv reads a Go duration (e.g. "90s", "5m") from an environment variable
+func getDurationEnv(envName string, def, minVal, maxVal time.Duration) (time.Duration, error) {
+	v := os.Getenv(envName)
+	if v == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q (must be a duration like 90s or 5m)", envName, v)
+	}
+	if d < minVal || d > maxVal {
+		return 0, fmt.Errorf("invalid %s: %s (must be between %s and %s)", envName, d, minVal, maxVal)
+	}
+
+	return d, nil
+}
+
+// secretSettings must come from the environment or Docker secrets, never from a config file
+var secretSettings = map[string]bool{
+	"SNOWFLAKE_PASSWORD":               true,
+	"SNOWFLAKE_PRIVATE_KEY_CONTENT":    true,
+	"SNOWFLAKE_PRIVATE_KEY_PASSPHRASE": true,
+	"SNOWFLAKE_OAUTH_TOKEN":            true,
+	"SLACK_WEBHOOK_URL":                true,
+	"TEAMS_WEBHOOK_URL":                true,
+	"ALERT_WEBHOOK_URL":                true,
+	"PAGERDUTY_ROUTING_KEY":            true,
+	"API_KEYS":                         true,
+	"VAULT_TOKEN":                      true,
+	"SMTP_PASSWORD":                    true,
+}
+
+var validSettingName = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// loadConfigFile reads a YAML file of settings keyed by environment variable name
+// (case-insensitive, e.g. snowflake_account: myorg-myaccount) and exports each one
+// that isn't already set, so environment variables always take precedence and
+// loadConfig validates every setting the same way regardless of its source
+func loadConfigFile(path string) ([]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	var accounts []map[string]string
+	for key, value := range settings {
+		if strings.EqualFold(key, "accounts") {
+			if accounts, err = parseAccountEntries(value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		name := strings.ToUpper(key)
+		if err := checkFileSetting(key); err != nil {
+			return nil, err
+		}
+
+		str, ok, err := settingString(name, value)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		if _, set := os.LookupEnv(name); !set {
+			if err := os.Setenv(name, str); err != nil {
+				return nil, fmt.Errorf("failed to apply setting %s: %w", name, err)
+			}
+		}
+	}
+
+	return accounts, nil
+}
+
+// checkFileSetting rejects malformed or secret setting names in the config file
+func checkFileSetting(key string) error {
+	name := strings.ToUpper(key)
+	if !validSettingName.MatchString(name) {
+		return fmt.Errorf("invalid setting name %q in config file", key)
+	}
+	if secretSettings[name] {
+		return fmt.Errorf("%s must not be stored in the config file (use an environment variable or Docker secret)", name)
+	}
+	return nil
+}
+
+// settingString converts a YAML value to its environment-variable form.
+// It reports false for null values, which leave the setting unset.
+func settingString(name string, value interface{}) (string, bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", false, nil
+	case string:
+		return v, true, nil
+	case bool, int, float64:
+		return fmt.Sprint(v), true, nil
+	case []interface{}:
+		// Lists map onto the comma-separated settings
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ","), true, nil
+	default:
+		return "", false, fmt.Errorf("setting %s in config file must be a scalar or list", name)
+	}
+}
+
+// accountSettings are the settings allowed in an entry of the config file's accounts list
+var accountSettings = map[string]bool{
+	"NAME":                       true,
+	"SNOWFLAKE_ACCOUNT":          true,
+	"SNOWFLAKE_USER":             true,
+	"SNOWFLAKE_DATABASE":         true,
+	"SNOWFLAKE_SCHEMA":           true,
+	"SNOWFLAKE_WAREHOUSE":        true,
+	"SNOWFLAKE_ROLE":             true,
+	"SNOWFLAKE_AUTH_TYPE":        true,
+	"SNOWFLAKE_PRIVATE_KEY_PATH": true,
+	"SNOWFLAKE_PRIVATE_KEY_DIR":  true,
+	"SNOWFLAKE_QUERY_TAG":        true,
+	"SNOWFLAKE_QUERY_ROLE":       true,
+	"DASHBOARD_WAREHOUSE":        true,
+	"SNOWFLAKE_SESSION_PARAMS":   true,
+	"SNOWFLAKE_HOST":             true,
+	"SNOWFLAKE_PORT":             true,
+	"SNOWFLAKE_REGION":           true,
+	"SNOWFLAKE_UI_BASE_URL":      true,
+}
+
+var (
+	// validSnowflakeHost is a bare hostname: no scheme, port, or path
+	validSnowflakeHost = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]{0,251}[A-Za-z0-9])?$`)
+
+	// validSnowflakeRegion matches region identifiers such as us-east-1 or east-us-2.azure
+	validSnowflakeRegion = regexp.MustCompile(`^[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*$`)
+)
+
+// defaultQueryTag labels the dashboard's own queries in QUERY_HISTORY
+const defaultQueryTag = "failed-queries-dashboard"
+
+// sessionParamKind says how a session parameter's value is validated and written into ALTER SESSION
+type sessionParamKind int
+
+const (
+	sessionParamInteger sessionParamKind = iota
+	sessionParamBoolean
+	sessionParamString
+)
+
+// sessionParams are the parameters SNOWFLAKE_SESSION_PARAMS may set. Anything that changes
+// how results come back (formats, case sensitivity, client settings) would break the driver's
+// parsing, so only these are allowed.
+var sessionParams = map[string]sessionParamKind{
+	"STATEMENT_TIMEOUT_IN_SECONDS":        sessionParamInteger,
+	"STATEMENT_QUEUED_TIMEOUT_IN_SECONDS": sessionParamInteger,
+	"LOCK_TIMEOUT":                        sessionParamInteger,
+	"WEEK_START":                          sessionParamInteger,
+	"WEEK_OF_YEAR_POLICY":                 sessionParamInteger,
+	"USE_CACHED_RESULT":                   sessionParamBoolean,
+	"TIMEZONE":                            sessionParamString,
+}
+
+// validSessionParamString restricts string values to characters that need no escaping
+// inside single quotes (time zone names such as America/New_York or Etc/GMT+5)
+var validSessionParamString = regexp.MustCompile(`^[A-Za-z0-9_./+: -]{1,255}$`)
+
+// parseSessionParams turns a comma-separated KEY=value list into ALTER SESSION SET
+// assignments, in the order given
+func parseSessionParams(value string) ([]string, error) {
+	var params []string
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(item, "=")
+		key, val = strings.ToUpper(strings.TrimSpace(key)), strings.TrimSpace(val)
+		if !ok || key == "" || val == "" {
+			return nil, fmt.Errorf("invalid SNOWFLAKE_SESSION_PARAMS entry %q (expected KEY=value)", item)
+		}
+		if key == "QUERY_TAG" {
+			return nil, fmt.Errorf("invalid SNOWFLAKE_SESSION_PARAMS: set QUERY_TAG with SNOWFLAKE_QUERY_TAG")
+		}
+		kind, allowed := sessionParams[key]
+		if !allowed {
+			return nil, fmt.Errorf("invalid SNOWFLAKE_SESSION_PARAMS: %s is not a supported parameter", key)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("invalid SNOWFLAKE_SESSION_PARAMS: %s is set more than once", key)
+		}
+		seen[key] = true
+
+		switch kind {
+		case sessionParamInteger:
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid SNOWFLAKE_SESSION_PARAMS: %s must be a non-negative integer, got %q", key, val)
+			}
+			val = strconv.Itoa(n)
+		case sessionParamBoolean:
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SNOWFLAKE_SESSION_PARAMS: %s must be TRUE or FALSE, got %q", key, val)
+			}
+			val = strings.ToUpper(strconv.FormatBool(b))
+		case sessionParamString:
+			if !validSessionParamString.MatchString(val) {
+				return nil, fmt.Errorf("invalid SNOWFLAKE_SESSION_PARAMS: %s value %q contains unsupported characters", key, val)
+			}
+			val = "'" + val + "'"
+		}
+		params = append(params, key+" = "+val)
+	}
+	return params, nil
+}
+
+// validAccountName restricts account names to characters usable in environment variable names
+var validAccountName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,31}$`)
+
+// parseAccountEntries validates the config file's accounts list, returning each
+// entry keyed by upper-cased setting name
+func parseAccountEntries(value interface{}) ([]map[string]string, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("accounts in config file must be a list")
+	}
+
+	entries := make([]map[string]string, 0, len(list))
+	for i, item := range list {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("accounts[%d] in config file must be a mapping", i)
+		}
+
+		entry := make(map[string]string, len(fields))
+		for key, value := range fields {
+			if err := checkFileSetting(key); err != nil {
+				return nil, fmt.Errorf("accounts[%d]: %w", i, err)
+			}
+			name := strings.ToUpper(key)
+			if !accountSettings[name] {
+				return nil, fmt.Errorf("accounts[%d]: %s is not a per-account setting", i, name)
+			}
+
+			str, ok, err := settingString(name, value)
+			if err != nil {
+				return nil, fmt.Errorf("accounts[%d]: %w", i, err)
+			}
+			if ok {
+				entry[name] = str
+			}
+		}
+
+		if !validAccountName.MatchString(entry["NAME"]) {
+			return nil, fmt.Errorf("accounts[%d] needs a name of letters, digits, and underscores (starting with a letter, max 32)", i)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// loadConfig loads configuration from the environment, a .env file, and an optional YAML config file
+func loadConfig(configFile string) (*Config, error) {
+	// Each secret is read once here; the token and responses aren't needed after that
+	defer resetVault()
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using environment variables")
+	}
+
+	if configFile == "" {
+		configFile = os.Getenv("CONFIG_FILE")
+	}
+	var accountEntries []map[string]string
+	if configFile != "" {
+		var err error
+		if accountEntries, err = loadConfigFile(configFile); err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded settings from config file %s", configFile)
+	}
+
+	config := &Config{}
+
+	if len(accountEntries) == 0 {
+		// Single account configured directly through the environment
+		account, err := loadAccountConfig(os.Getenv, "")
+		if err != nil {
+			return nil, err
+		}
+		account.Name = account.Account
+		config.Accounts = []AccountConfig{account}
+	} else {
+		seen := make(map[string]bool, len(accountEntries))
+		for _, entry := range accountEntries {
+			name := entry["NAME"]
+			if seen[name] {
+				return nil, fmt.Errorf("duplicate account name %q in config file", name)
+			}
+			seen[name] = true
+
+			account, err := loadAccountConfig(func(key string) string { return entry[key] }, name)
+			if err != nil {
+				return nil, fmt.Errorf("account %s: %w", name, err)
+			}
+			account.Name = name
+			config.Accounts = append(config.Accounts, account)
+		}
+	}
+
+	// Parse query settings
+	var err error
+	if config.LookbackHours, err = getIntEnv("QUERY_LOOKBACK_HOURS", defaultLookbackHours, 1, maxLookbackHours); err != nil {
+		return nil, err
+	}
+	if config.RowLimit, err = getIntEnv("QUERY_ROW_LIMIT", defaultRowLimit, 1, maxRowLimit); err != nil {
+		return nil, err
+	}
+	config.ExcludePatterns = getListEnv("QUERY_EXCLUDE_PATTERNS", defaultExcludePatterns)
+	if config.ExcludeErrorCodes, err = parseErrorCodes(getListEnv("EXCLUDE_ERROR_CODES", nil)); err != nil {
+		return nil, err
+	}
+	if config.VisibleColumns, err = parseVisibleColumns(getListEnv("VISIBLE_COLUMNS", cardColumns)); err != nil {
+		return nil, err
+	}
+	config.ServiceAccountPattern = defaultServiceAccountPattern
+	if pattern := os.Getenv("SERVICE_ACCOUNT_PATTERN"); pattern != "" {
+		config.ServiceAccountPattern = pattern
+	}
+	if err := validateUserPattern(config.ServiceAccountPattern); err != nil {
+		return nil, fmt.Errorf("invalid SERVICE_ACCOUNT_PATTERN: %w", err)
+	}
+	if config.OverviewWindows, err = parseOverviewWindows(getListEnv("OVERVIEW_WINDOWS", defaultOverviewWindows)); err != nil {
+		return nil, err
+	}
+	if config.MonitoredDatabases, err = parseMonitoredDatabases(getListEnv("MONITORED_DATABASES", nil)); err != nil {
+		return nil, err
+	}
+	if config.Branding, err = loadBranding(); err != nil {
+		return nil, err
+	}
+	if config.StaleDataThreshold, err = getDurationEnv("STALE_DATA_THRESHOLD", defaultStaleDataThreshold, time.Minute, 24*time.Hour); err != nil {
+		return nil, err
+	}
+	for _, pattern := range getListEnv("REDACT_PATTERNS", nil) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDACT_PATTERNS entry %q: %w", pattern, err)
+		}
+		config.RedactPatterns = append(config.RedactPatterns, re)
+	}
+	if config.QueryTextPreviewChars, err = getIntEnv("QUERY_TEXT_PREVIEW_CHARS", defaultQueryTextPreviewChars, minQueryTextPreviewChars, maxQueryTextPreviewChars); err != nil {
+		return nil, err
+	}
+	if config.MaxQueryTextBytes, err = getIntEnv("MAX_QUERY_TEXT_BYTES", defaultMaxQueryTextBytes, minMaxQueryTextBytes, maxMaxQueryTextBytes); err != nil {
+		return nil, err
+	}
+	maxRangeHours, err := getIntEnv("QUERY_MAX_RANGE_HOURS", defaultMaxRangeHours, 1, maxMaxRangeHours)
+	if err != nil {
+		return nil, err
+	}
+	config.MaxTimeRange = time.Duration(maxRangeHours) * time.Hour
+	if config.RefreshIntervalSeconds, err = getIntEnv("REFRESH_INTERVAL_SECONDS", defaultRefreshIntervalSeconds, minRefreshIntervalSeconds, maxRefreshIntervalSeconds); err != nil {
+		return nil, err
+	}
+	cacheTTLSeconds, err := getIntEnv("CACHE_TTL_SECONDS", 0, 0, maxCacheTTLSeconds)
+	if err != nil {
+		return nil, err
+	}
+	config.CacheTTL = time.Duration(cacheTTLSeconds) * time.Second
+	config.QueryRetries, err = getIntEnv("QUERY_RETRIES", defaultQueryRetries, 0, maxQueryRetries)
+	if err != nil {
+		return nil, err
+	}
+	retryBaseDelayMs, err := getIntEnv("QUERY_RETRY_BASE_DELAY_MS", defaultRetryBaseDelayMs, 0, maxRetryBaseDelayMs)
+	if err != nil {
+		return nil, err
+	}
+	config.RetryBaseDelay = time.Duration(retryBaseDelayMs) * time.Millisecond
+	if config.ConnectAttempts, err = getIntEnv("STARTUP_CONNECT_ATTEMPTS", defaultConnectAttempts, 1, maxConnectAttempts); err != nil {
+		return nil, err
+	}
+	connectRetryDelaySeconds, err := getIntEnv("STARTUP_CONNECT_RETRY_DELAY_SECONDS", defaultConnectRetryDelaySeconds, 1, maxConnectRetryDelaySeconds)
+	if err != nil {
+		return nil, err
+	}
+	config.ConnectRetryDelay = time.Duration(connectRetryDelaySeconds) * time.Second
+	warehouseRetryAfterSeconds, err := getIntEnv("WAREHOUSE_RETRY_AFTER_SECONDS", defaultWarehouseRetryAfterSeconds, 1, maxWarehouseRetryAfterSeconds)
+	if err != nil {
+		return nil, err
+	}
+	config.WarehouseRetryAfter = time.Duration(warehouseRetryAfterSeconds) * time.Second
+	queryTimeoutSeconds, err := getIntEnv("QUERY_TIMEOUT_SECONDS", defaultQueryTimeoutSeconds, 1, maxQueryTimeoutSeconds)
+	if err != nil {
+		return nil, err
+	}
+	config.QueryTimeout = time.Duration(queryTimeoutSeconds) * time.Second
+	pingTimeoutSeconds, err := getIntEnv("PING_TIMEOUT_SECONDS", defaultPingTimeoutSeconds, 1, maxPingTimeoutSeconds)
+	if err != nil {
+		return nil, err
+	}
+	config.PingTimeout = time.Duration(pingTimeoutSeconds) * time.Second
+
+	if config.Pool, err = loadPoolSettings(); err != nil {
+		return nil, err
+	}
+
+	config.HistoryDBPath = os.Getenv("HISTORY_DB_PATH")
+	config.AckDBPath = os.Getenv("ACK_DB_PATH")
+	historyIntervalSeconds, err := getIntEnv("HISTORY_POLL_INTERVAL_SECONDS", defaultHistoryIntervalSeconds, minHistoryIntervalSeconds, maxHistoryIntervalSeconds)
+	if err != nil {
+		return nil, err
+	}
+	config.HistoryPollInterval = time.Duration(historyIntervalSeconds) * time.Second
+
+	if config.QueryMetrics, err = getBoolEnv("QUERY_METRICS_ENABLED", false); err != nil {
+		return nil, err
+	}
+	if config.DataSource, err = parseDataSource(os.Getenv("DATA_SOURCE")); err != nil {
+		return nil, err
+	}
+
+	if config.KeepAlive, err = getBoolEnv("KEEPALIVE_ENABLED", false); err != nil {
+		return nil, err
+	}
+	if config.KeepAliveInterval, err = getDurationEnv("KEEPALIVE_INTERVAL", defaultKeepAliveInterval, 10*time.Second, time.Hour); err != nil {
+		return nil, err
+	}
+
+	if config.SMTP, err = loadSMTPSettings(); err != nil {
+		return nil, err
+	}
+	if config.DigestSchedule, err = parseDigestSchedule(os.Getenv("DIGEST_TIME"), getListEnv("DIGEST_DAYS", nil)); err != nil {
+		return nil, err
+	}
+	if config.DigestEmails, err = parseDigestEmails(getListEnv("DIGEST_EMAILS", nil)); err != nil {
+		return nil, err
+	}
+	if config.DigestEmailDomain = strings.TrimPrefix(os.Getenv("DIGEST_EMAIL_DOMAIN"), "@"); config.DigestEmailDomain != "" {
+		if _, err := mail.ParseAddress("user@" + config.DigestEmailDomain); err != nil {
+			return nil, fmt.Errorf("invalid DIGEST_EMAIL_DOMAIN %q (must be a domain like example.com)", config.DigestEmailDomain)
+		}
+	}
+
+	// Slack webhook URLs embed a token, so they're treated as a secret
+	if config.SlackWebhookURL, err = getSecretOrEnv("slack_webhook_url", "SLACK_WEBHOOK_URL"); err != nil {
+		return nil, err
+	}
+	if config.SlackWebhookURL != "" && !strings.HasPrefix(config.SlackWebhookURL, "https://") {
+		return nil, fmt.Errorf("SLACK_WEBHOOK_URL must be an https:// URL")
+	}
+	if config.TeamsWebhookURL, err = getSecretOrEnv("teams_webhook_url", "TEAMS_WEBHOOK_URL"); err != nil {
+		return nil, err
+	}
+	if config.TeamsWebhookURL != "" && !strings.HasPrefix(config.TeamsWebhookURL, "https://") {
+		return nil, fmt.Errorf("TEAMS_WEBHOOK_URL must be an https:// URL")
+	}
+	// Receivers often authenticate by a token in the URL, so it's a secret as well
+	if config.AlertWebhookURL, err = getSecretOrEnv("alert_webhook_url", "ALERT_WEBHOOK_URL"); err != nil {
+		return nil, err
+	}
+	if config.AlertWebhookURL != "" {
+		if u, err := url.Parse(config.AlertWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("ALERT_WEBHOOK_URL must be an http:// or https:// URL")
+		}
+	}
+	if config.DashboardURL = strings.TrimSuffix(os.Getenv("DASHBOARD_URL"), "/"); config.DashboardURL != "" {
+		if u, err := url.Parse(config.DashboardURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("DASHBOARD_URL must be an http:// or https:// URL")
+		}
+	}
+	alertIntervalSeconds, err := getIntEnv("ALERT_POLL_INTERVAL_SECONDS", defaultAlertIntervalSeconds, minAlertIntervalSeconds, maxAlertIntervalSeconds)
+	if err != nil {
+		return nil, err
+	}
+	config.AlertInterval = time.Duration(alertIntervalSeconds) * time.Second
+
+	// PagerDuty routing keys can open incidents, so they're treated as a secret too
+	if config.PagerDutyRoutingKey, err = getSecretOrEnv("pagerduty_routing_key", "PAGERDUTY_ROUTING_KEY"); err != nil {
+		return nil, err
+	}
+	if config.AlertThreshold, err = getIntEnv("ALERT_THRESHOLD", defaultAlertThreshold, 1, maxAlertThreshold); err != nil {
+		return nil, err
+	}
+	config.Notifiers = getListEnv("NOTIFIERS", nil)
+	if err := checkNotifierNames(config); err != nil {
+		return nil, err
+	}
+
+	if tz := os.Getenv("DISPLAY_TIMEZONE"); tz != "" {
+		if config.DisplayLocation, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("invalid DISPLAY_TIMEZONE %q (must be an IANA zone name like America/New_York): %w", tz, err)
+		}
+	}
+
+	// TLS needs both the certificate and the key
+	config.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	config.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together to enable TLS")
+	}
+
+	if config.CORSAllowedOrigins, err = parseCORSOrigins(getListEnv("CORS_ALLOWED_ORIGINS", nil)); err != nil {
+		return nil, err
+	}
+
+	apiKeys, err := getSecretOrEnv("api_keys", "API_KEYS")
+	if err != nil {
+		return nil, err
+	}
+	if config.APIKeys, err = parseAPIKeys(apiKeys); err != nil {
+		return nil, err
+	}
+
+	if config.RateLimitPerMinute, err = getIntEnv("RATE_LIMIT_PER_MINUTE", 0, 0, maxRateLimitPerMinute); err != nil {
+		return nil, err
+	}
+	if config.RateLimitBurst, err = getIntEnv("RATE_LIMIT_BURST", defaultRateLimitBurst, 1, maxRateLimitBurst); err != nil {
+		return nil, err
+	}
+	if config.TrustedProxies, err = parseTrustedProxies(getListEnv("TRUSTED_PROXIES", nil)); err != nil {
+		return nil, err
+	}
+	if config.AccessLog, err = getBoolEnv("ACCESS_LOG", true); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// loadAccountConfig reads one account's connection settings through setting.
+// Secrets always come from Docker secrets or the environment; for named accounts
+// in the config file their names carry the account name as a suffix
+// (e.g. SNOWFLAKE_PASSWORD_PROD or /run/secrets/snowflake_password_prod).
+func loadAccountConfig(setting func(string) string, secretSuffix string) (AccountConfig, error) {
+	secret := func(name string) (string, string, error) {
+		secretName, envName := strings.ToLower(name), name
+		if secretSuffix != "" {
+			secretName += "_" + strings.ToLower(secretSuffix)
+			envName += "_" + strings.ToUpper(secretSuffix)
+		}
+		value, err := getSecretOrEnv(secretName, envName)
+		return value, envName, err
+	}
+
+	authType := AuthType(setting("SNOWFLAKE_AUTH_TYPE"))
+	if authType == "" {
+		authType = AuthTypePassword // Default to password auth
+	}
+
+	config := AccountConfig{
+		Account:   setting("SNOWFLAKE_ACCOUNT"),
+		User:      setting("SNOWFLAKE_USER"),
+		Database:  setting("SNOWFLAKE_DATABASE"),
+		Schema:    setting("SNOWFLAKE_SCHEMA"),
+		Warehouse: setting("SNOWFLAKE_WAREHOUSE"),
+		Role:      setting("SNOWFLAKE_ROLE"),
+		AuthType:  authType,
+		QueryTag:  setting("SNOWFLAKE_QUERY_TAG"),
+	}
+	if config.QueryTag == "" {
+		// Named accounts inherit the global tag
+		config.QueryTag = os.Getenv("SNOWFLAKE_QUERY_TAG")
+	}
+	if config.QueryTag == "" {
+		config.QueryTag = defaultQueryTag
+	}
+
+	// Validate common fields
+	if config.Account == "" || config.User == "" {
+		return config, fmt.Errorf("SNOWFLAKE_ACCOUNT and SNOWFLAKE_USER are required")
+	}
+	// Dynamic credentials (e.g. Vault's database secrets engine) generate the user with its password
+	user, err := resolveSecretRef(config.User, "SNOWFLAKE_USER")
+	if err != nil {
+		return config, err
+	}
+	config.User = user
+
+	if err := loadEndpointOverrides(&config, setting); err != nil {
+		return config, err
+	}
+
+	// Inlined into USE ROLE, so only plain identifiers are accepted
+	if config.QueryRole = setting("SNOWFLAKE_QUERY_ROLE"); config.QueryRole != "" && !validRoleName.MatchString(config.QueryRole) {
+		return config, fmt.Errorf("invalid SNOWFLAKE_QUERY_ROLE: %q (must be an unquoted role name)", config.QueryRole)
+	}
+	if config.DashboardWarehouse = setting("DASHBOARD_WAREHOUSE"); config.DashboardWarehouse != "" && !validWarehouseName.MatchString(config.DashboardWarehouse) {
+		return config, fmt.Errorf("invalid DASHBOARD_WAREHOUSE: %q (must be an unquoted warehouse name)", config.DashboardWarehouse)
+	}
+	sessionParams := setting("SNOWFLAKE_SESSION_PARAMS")
+	if sessionParams == "" {
+		// Named accounts inherit the global parameters
+		sessionParams = os.Getenv("SNOWFLAKE_SESSION_PARAMS")
+	}
+	if config.SessionParams, err = parseSessionParams(sessionParams); err != nil {
+		return config, err
+	}
+
+	// Validate based on auth type
+	switch authType {
+	case AuthTypePassword, AuthTypePasswordMFA:
+		// Read password from a secret file, Docker secret, or environment variable
+		var envName string
+		var err error
+		if config.Password, envName, err = secret("SNOWFLAKE_PASSWORD"); err != nil {
+			return config, err
+		}
+		if config.Password == "" {
+			return config, fmt.Errorf("%s is required for password authentication (provide via %s_FILE, /run/secrets/%s, or %s env var)", envName, envName, strings.ToLower(envName), envName)
+		}
+	case AuthTypeKeyPair:
+		config.PrivateKeyPath = setting("SNOWFLAKE_PRIVATE_KEY_PATH")
+		config.PrivateKeyDir = setting("SNOWFLAKE_PRIVATE_KEY_DIR")
+		var contentEnv string
+		var err error
+		if config.PrivateKeyContent, contentEnv, err = secret("SNOWFLAKE_PRIVATE_KEY_CONTENT"); err != nil {
+			return config, err
+		}
+		// Read passphrase from a secret file, Docker secret, or environment variable
+		if config.PrivateKeyPassphrase, _, err = secret("SNOWFLAKE_PRIVATE_KEY_PASSPHRASE"); err != nil {
+			return config, err
+		}
+
+		if config.PrivateKeyPath == "" && config.PrivateKeyDir == "" && config.PrivateKeyContent == "" {
+			return config, fmt.Errorf("one of SNOWFLAKE_PRIVATE_KEY_PATH, SNOWFLAKE_PRIVATE_KEY_DIR, or %s is required for key-pair authentication", contentEnv)
+		}
+		if config.PrivateKeyDir != "" && config.PrivateKeyPath != "" {
+			return config, errors.New("set only one of SNOWFLAKE_PRIVATE_KEY_PATH and SNOWFLAKE_PRIVATE_KEY_DIR")
+		}
+	case AuthTypeOAuth:
+		// Read access token from a secret file, Docker secret, or environment variable
+		var envName string
+		var err error
+		if config.OAuthToken, envName, err = secret("SNOWFLAKE_OAUTH_TOKEN"); err != nil {
+			return config, err
+		}
+		if config.OAuthToken == "" {
+			return config, fmt.Errorf("%s is required for OAuth authentication (provide via %s_FILE, /run/secrets/%s, or %s env var)", envName, envName, strings.ToLower(envName), envName)
+		}
+	case AuthTypeExternalBrowser:
+		// The SSO flow needs a user at the keyboard to complete the browser login
+		if !isInteractive() {
+			return config, fmt.Errorf("SNOWFLAKE_AUTH_TYPE=externalbrowser requires an interactive terminal (it opens a browser for SSO login); use password or keypair for servers and containers")
+		}
+	default:
+		return config, fmt.Errorf("invalid SNOWFLAKE_AUTH_TYPE: %s (must be 'password', 'username_password_mfa', 'keypair', 'oauth', or 'externalbrowser')", authType)
+	}
+
+	return config, nil
+}
+
+// loadEndpointOverrides reads SNOWFLAKE_HOST, SNOWFLAKE_PORT, and SNOWFLAKE_REGION,
+// rejecting combinations the driver would silently misinterpret, and SNOWFLAKE_UI_BASE_URL
+func loadEndpointOverrides(config *AccountConfig, setting func(string) string) error {
+	config.Host = setting("SNOWFLAKE_HOST")
+	config.Region = setting("SNOWFLAKE_REGION")
+
+	if config.Host != "" && !validSnowflakeHost.MatchString(config.Host) {
+		return fmt.Errorf("SNOWFLAKE_HOST must be a bare hostname (e.g. xy12345.us-east-1.privatelink.snowflakecomputing.com), without scheme, port, or path")
+	}
+	if config.Region != "" {
+		if !validSnowflakeRegion.MatchString(config.Region) {
+			return fmt.Errorf("invalid SNOWFLAKE_REGION: %q", config.Region)
+		}
+		// The driver would otherwise splice the region into the custom host name
+		if config.Host != "" {
+			return fmt.Errorf("SNOWFLAKE_REGION cannot be combined with SNOWFLAKE_HOST; include the region in the host name instead")
+		}
+		if strings.Contains(config.Account, ".") {
+			return fmt.Errorf("SNOWFLAKE_REGION cannot be combined with a SNOWFLAKE_ACCOUNT that already includes a region (%s)", config.Account)
+		}
+	}
+
+	if port := setting("SNOWFLAKE_PORT"); port != "" {
+		if config.Host == "" {
+			return fmt.Errorf("SNOWFLAKE_PORT requires SNOWFLAKE_HOST")
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("SNOWFLAKE_PORT must be a port number between 1 and 65535")
+		}
+		config.Port = p
+	}
+
+	config.UIBaseURL = strings.TrimSuffix(setting("SNOWFLAKE_UI_BASE_URL"), "/")
+	if config.UIBaseURL == "" {
+		config.UIBaseURL = snowsightBaseURL(config.Account, config.Region)
+	} else if u, err := url.Parse(config.UIBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("SNOWFLAKE_UI_BASE_URL must be an absolute http(s) URL, e.g. https://app.snowflake.com/myorg/myaccount")
+	}
+	return nil
+}
+
+// snowsightURL is the public Snowsight address that account-specific paths hang off
+const snowsightURL = "https://app.snowflake.com"
+
+// snowsightBaseURL derives an account's Snowsight address from its identifier.
+// Organization identifiers (myorg-myaccount) become /myorg/myaccount; legacy
+// locators become /<region>/<locator>, e.g. xy12345.east-us-2.azure becomes
+// /east-us-2.azure/xy12345. A locator without a region lives in us-west-2.
+func snowsightBaseURL(account, region string) string {
+	account = strings.TrimSuffix(strings.ToLower(account), ".privatelink")
+	locator, accountRegion, hasRegion := strings.Cut(account, ".")
+	if hasRegion {
+		region = accountRegion
+	} else if region == "" {
+		// Locators never contain a hyphen; organization names can't either, so the first one splits org from account
+		if org, name, ok := strings.Cut(locator, "-"); ok {
+			return snowsightURL + "/" + org + "/" + name
+		}
+		region = "us-west-2"
+	}
+	return snowsightURL + "/" + strings.ToLower(region) + "/" + locator
+}
+
+// loadPoolSettings reads the DB_* connection pool settings, defaulting to the original hardcoded values
+func loadPoolSettings() (PoolSettings, error) {
+	var pool PoolSettings
+	var err error
+	if pool.MaxOpenConns, err = getIntEnv("DB_MAX_OPEN_CONNS", defaultMaxOpenConns, 1, maxPoolConns); err != nil {
+		return pool, err
+	}
+	if pool.MaxIdleConns, err = getIntEnv("DB_MAX_IDLE_CONNS", defaultMaxIdleConns, 1, maxPoolConns); err != nil {
+		return pool, err
+	}
+	if pool.MaxIdleConns > pool.MaxOpenConns {
+		return pool, fmt.Errorf("DB_MAX_IDLE_CONNS (%d) must not exceed DB_MAX_OPEN_CONNS (%d)", pool.MaxIdleConns, pool.MaxOpenConns)
+	}
+	if pool.ConnMaxLifetime, err = getDurationEnv("DB_CONN_MAX_LIFETIME", defaultConnMaxLifetime, time.Second, 24*time.Hour); err != nil {
+		return pool, err
+	}
+	if pool.ConnMaxIdleTime, err = getDurationEnv("DB_CONN_MAX_IDLE_TIME", defaultConnMaxIdleTime, time.Second, 24*time.Hour); err != nil {
+		return pool, err
+	}
+	return pool, nil
+}
+
+// defaultSMTPPort is the mail submission port, which upgrades to TLS with STARTTLS
+const defaultSMTPPort = 587
+
+// loadSMTPSettings reads the SMTP_* settings; SMTP_FROM is required once SMTP_HOST is set
+func loadSMTPSettings() (SMTPSettings, error) {
+	var smtpSettings SMTPSettings
+	var err error
+	smtpSettings.Host = os.Getenv("SMTP_HOST")
+	if smtpSettings.Port, err = getIntEnv("SMTP_PORT", defaultSMTPPort, 1, 65535); err != nil {
+		return smtpSettings, err
+	}
+	smtpSettings.Username = os.Getenv("SMTP_USERNAME")
+	if smtpSettings.Password, err = getSecretOrEnv("smtp_password", "SMTP_PASSWORD"); err != nil {
+		return smtpSettings, err
+	}
+	if smtpSettings.Host == "" {
+		return smtpSettings, nil
+	}
+
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		return smtpSettings, fmt.Errorf("SMTP_FROM must be set when SMTP_HOST is set")
+	}
+	if smtpSettings.From, err = mail.ParseAddress(from); err != nil {
+		return smtpSettings, fmt.Errorf("invalid SMTP_FROM %q (must be an address like dashboard@example.com): %w", from, err)
+	}
+	return smtpSettings, nil
+}
+
+// isInteractive reports whether the process is attached to a terminal
+func isInteractive() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// asSigner converts a parsed private key into a crypto.Signer, rejecting unknown key types
+func asSigner(key interface{}) (crypto.Signer, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	case *ed25519.PrivateKey:
+		return *k, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// parsePrivateKey loads and parses the private key (RSA, EC, or Ed25519) from file or base64 content
+func parsePrivateKey(config *AccountConfig) (crypto.Signer, error) {
+	var pemBytes []byte
+	var err error
+
+	// Get PEM bytes from file or env var
+	if config.PrivateKeyPath != "" {
+		pemBytes, err = os.ReadFile(config.PrivateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key file: %w", err)
+		}
+	} else if config.PrivateKeyContent != "" {
+		// Decode base64-encoded key content
+		pemBytes, err = base64.StdEncoding.DecodeString(config.PrivateKeyContent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode base64 private key: %w", err)
+		}
+	}
+
+	// Security: Clear PEM bytes from memory after parsing
+	defer func() {
+		for i := range pemBytes {
+			pemBytes[i] = 0
+		}
+	}()
+
+	// Decode PEM block
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the private key")
+	}
+
+	// Security: Clear PEM block bytes from memory after use
+	defer func() {
+		if block != nil && block.Bytes != nil {
+			for i := range block.Bytes {
+				block.Bytes[i] = 0
+			}
+		}
+	}()
+
+	// Handle encrypted vs unencrypted keys
+	var privateKeyBytes []byte
+
+	if x509.IsEncryptedPEMBlock(block) {
+		// Legacy PEM encryption (PKCS#1 with DEK-Info)
+		if config.PrivateKeyPassphrase == "" {
+			return nil, errors.New("private key is encrypted but no passphrase provided (set SNOWFLAKE_PRIVATE_KEY_PASSPHRASE)")
+		}
+		privateKeyBytes, err = x509.DecryptPEMBlock(block, []byte(config.PrivateKeyPassphrase))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt PEM block: %w", err)
+		}
+		// Security: Clear decrypted key bytes after parsing
+		defer func() {
+			for i := range privateKeyBytes {
+				privateKeyBytes[i] = 0
+			}
+		}()
+	} else if block.Type == "ENCRYPTED PRIVATE KEY" {
+		// Modern PKCS#8 encryption
+		if config.PrivateKeyPassphrase == "" {
+			return nil, errors.New("private key is encrypted but no passphrase provided (set SNOWFLAKE_PRIVATE_KEY_PASSPHRASE)")
+		}
+		// Use github.com/youmark/pkcs8 for PKCS#8 decryption
+		privateKey, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(config.PrivateKeyPassphrase))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse encrypted PKCS8 private key: %w", err)
+		}
+		return asSigner(privateKey)
+	} else {
+		// Unencrypted key
+		privateKeyBytes = block.Bytes
+	}
+
+	// Security: Clear private key bytes after parsing
+	defer func() {
+		for i := range privateKeyBytes {
+			privateKeyBytes[i] = 0
+		}
+	}()
+
+	// Parse unencrypted PKCS#8, falling back to the legacy PKCS#1 (RSA) and SEC 1 (EC) formats
+	privateKey, err := x509.ParsePKCS8PrivateKey(privateKeyBytes)
+	if err != nil {
+		if block.Type == "EC PRIVATE KEY" {
+			privateKey, err = x509.ParseECPrivateKey(privateKeyBytes)
+		} else {
+			privateKey, err = x509.ParsePKCS1PrivateKey(privateKeyBytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+	}
+
+	return asSigner(privateKey)
+}
+
+// privateKeyFiles lists the .p8 files in dir, most recently modified first
+func privateKeyFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key directory: %w", err)
+	}
+
+	type keyFile struct {
+		path    string
+		modTime time.Time
+	}
+	var files []keyFile
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".p8" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat private key %s: %w", entry.Name(), err)
+		}
+		files = append(files, keyFile{path: filepath.Join(dir, entry.Name()), modTime: info.ModTime()})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .p8 private keys in %s", dir)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths, nil
+}
+
+// connectWithKeyDir tries each key in config.PrivateKeyDir, newest first, until one
+// authenticates. During a rotation the user has both the old and new public keys
+// registered, so whichever keys are staged, one of them connects. Keys that fail
+// are cleared by getSnowflakeConnection before the next one is loaded.
+func connectWithKeyDir(config *AccountConfig, pool PoolSettings, pingTimeout time.Duration) (*sql.DB, crypto.Signer, error) {
+	paths, err := privateKeyFiles(config.PrivateKeyDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var errs []error
+	for _, path := range paths {
+		candidate := *config
+		candidate.PrivateKeyDir = ""
+		candidate.PrivateKeyPath = path
+
+		db, privateKey, err := getSnowflakeConnection(&candidate, pool, pingTimeout)
+		if err == nil {
+			log.Printf("Account %s authenticated with private key %s", config.Name, filepath.Base(path))
+			return db, privateKey, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(path), err))
+	}
+	return nil, nil, fmt.Errorf("no private key in %s authenticated: %w", config.PrivateKeyDir, errors.Join(errs...))
+}
+
+// getSnowflakeConnection opens a connection pool for config and verifies it with a
+// ping bounded by pingTimeout (browser SSO gets longer, for the user to log in)
+func getSnowflakeConnection(config *AccountConfig, pool PoolSettings, pingTimeout time.Duration) (*sql.DB, crypto.Signer, error) {
+	if config.AuthType == AuthTypeKeyPair && config.PrivateKeyDir != "" {
+		return connectWithKeyDir(config, pool, pingTimeout)
+	}
+
+	var dsn string
+	var err error
+	var privateKey crypto.Signer
+
+	switch config.AuthType {
+	case AuthTypePassword:
+		// Security Fix #2: let the driver build the DSN so every field is URL encoded.
+		// A hand-written DSN left database and schema unescaped, and an '@' or '/' in them
+		// moved where the driver split off the password, sending it to the wrong host.
+		sfConfig := &gosnowflake.Config{
+			Account:       config.Account,
+			Host:          config.Host,
+			Port:          config.Port,
+			Region:        config.Region,
+			User:          config.User,
+			Password:      config.Password,
+			Authenticator: gosnowflake.AuthTypeSnowflake,
+			Database:      config.Database,
+			Schema:        config.Schema,
+			Warehouse:     config.Warehouse,
+			Role:          config.Role,
+			Params:        map[string]*string{"query_tag": &config.QueryTag},
+		}
+
+		dsn, err = gosnowflake.DSN(sfConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to build DSN for password auth: %w", scrubSecrets(err, config.Password))
+		}
+
+	case AuthTypeKeyPair:
+		// Load and parse private key
+		privateKey, err = parsePrivateKey(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load private key: %w", err)
+		}
+
+		// The driver signs its JWT with RS256, so only RSA keys can authenticate
+		rsaKey, ok := privateKey.(*rsa.PrivateKey)
+		if !ok {
+			clearPrivateKey(privateKey)
+			return nil, nil, fmt.Errorf("private key type %T is not supported by the Snowflake driver (key-pair authentication requires an RSA key)", privateKey)
+		}
+
+		// Build config using gosnowflake.Config
+		sfConfig := &gosnowflake.Config{
+			Account:       config.Account,
+			Host:          config.Host,
+			Port:          config.Port,
+			Region:        config.Region,
+			User:          config.User,
+			Authenticator: gosnowflake.AuthTypeJwt,
+			PrivateKey:    rsaKey,
+			Database:      config.Database,
+			Schema:        config.Schema,
+			Warehouse:     config.Warehouse,
+			Role:          config.Role,
+			Params:        map[string]*string{"query_tag": &config.QueryTag},
+		}
+
+		dsn, err = gosnowflake.DSN(sfConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to build DSN for key-pair auth: %w", err)
+		}
+
+	case AuthTypeOAuth:
+		sfConfig := &gosnowflake.Config{
+			Account:       config.Account,
+			Host:          config.Host,
+			Port:          config.Port,
+			Region:        config.Region,
+			User:          config.User,
+			Authenticator: gosnowflake.AuthTypeOAuth,
+			Token:         config.OAuthToken,
+			Database:      config.Database,
+			Schema:        config.Schema,
+			Warehouse:     config.Warehouse,
+			Role:          config.Role,
+			Params:        map[string]*string{"query_tag": &config.QueryTag},
+		}
+
+		dsn, err = gosnowflake.DSN(sfConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to build DSN for OAuth auth: %w", scrubSecrets(err, config.OAuthToken))
+		}
+
+	case AuthTypeExternalBrowser:
+		sfConfig := &gosnowflake.Config{
+			Account:       config.Account,
+			Host:          config.Host,
+			Port:          config.Port,
+			Region:        config.Region,
+			User:          config.User,
+			Authenticator: gosnowflake.AuthTypeExternalBrowser,
+			Database:      config.Database,
+			Schema:        config.Schema,
+			Warehouse:     config.Warehouse,
+			Role:          config.Role,
+			Params:        map[string]*string{"query_tag": &config.QueryTag},
+		}
+
+		dsn, err = gosnowflake.DSN(sfConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to build DSN for external browser auth: %w", err)
+		}
+
+		log.Println("Opening a browser window for Snowflake SSO login...")
+		pingTimeout = max(pingTimeout, 3*time.Minute)
+
+	case AuthTypePasswordMFA:
+		// Without ClientRequestMfaToken the driver only caches the token on macOS and Windows;
+		// on Linux it is kept in a file under SF_TEMPORARY_CREDENTIAL_CACHE_DIR or ~/.cache/snowflake
+		sfConfig := &gosnowflake.Config{
+			Account:               config.Account,
+			Host:                  config.Host,
+			Port:                  config.Port,
+			Region:                config.Region,
+			User:                  config.User,
+			Password:              config.Password,
+			Authenticator:         gosnowflake.AuthTypeUsernamePasswordMFA,
+			ClientRequestMfaToken: gosnowflake.ConfigBoolTrue,
+			Database:              config.Database,
+			Schema:                config.Schema,
+			Warehouse:             config.Warehouse,
+			Role:                  config.Role,
+			Params:                map[string]*string{"query_tag": &config.QueryTag},
+		}
+
+		dsn, err = gosnowflake.DSN(sfConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to build DSN for MFA password auth: %w", scrubSecrets(err, config.Password))
+		}
+
+		// Leave time to approve the push when there's no cached token yet
+		log.Println("Logging in with MFA; approve the push notification if prompted...")
+		pingTimeout = max(pingTimeout, 2*time.Minute)
+
+	default:
+		return nil, nil, fmt.Errorf("unsupported auth type: %s", config.AuthType)
+	}
+
+	// The DSN carries the password or token, so neither it nor they may reach an error
+	connector, err := gosnowflake.SnowflakeDriver{}.OpenConnector(dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open snowflake connection: %w", scrubSecrets(err, dsn, config.Password, config.OAuthToken))
+	}
+	var setup []string
+	if config.QueryRole != "" {
+		setup = append(setup, "USE ROLE "+config.QueryRole)
+	}
+	// After the role switch, since the query role may be the one granted USAGE on the warehouse
+	if config.DashboardWarehouse != "" {
+		setup = append(setup, "USE WAREHOUSE "+config.DashboardWarehouse)
+	}
+	for _, param := range config.SessionParams {
+		setup = append(setup, "ALTER SESSION SET "+param)
+	}
+	db := sql.OpenDB(sessionConnector{Connector: connector, setup: setup})
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		if privateKey != nil {
+			clearPrivateKey(privateKey)
+		}
+		return nil, nil, fmt.Errorf("failed to ping snowflake: %w", scrubSecrets(err, dsn, config.Password, config.OAuthToken))
+	}
+
+	// Configure connection pool to prevent resource exhaustion and enable credential rotation
+	db.SetMaxOpenConns(pool.MaxOpenConns)       // Limit concurrent connections to prevent database overload
+	db.SetMaxIdleConns(pool.MaxIdleConns)       // Keep some connections ready for reuse
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime) // Rotate connections (enables credential rotation)
+	db.SetConnMaxIdleTime(pool.ConnMaxIdleTime) // Close idle connections after a quiet period
+
+	return db, privateKey, nil
+}
+
+// scrubbedError hides secrets in a wrapped error's message while keeping it
+// reachable for errors.As (e.g. to tell login failures from transient ones)
+type scrubbedError struct {
+	msg string
+	err error
+}
+
+func (e *scrubbedError) Error() string { return e.msg }
+func (e *scrubbedError) Unwrap() error { return e.err }
+
+// scrubSecrets replaces each secret, raw or URL encoded as it appears in a DSN, with
+// [REDACTED] in err's message. err is returned unchanged when none of them occur.
+func scrubSecrets(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		for _, form := range []string{secret, url.QueryEscape(secret), url.PathEscape(secret)} {
+			msg = strings.ReplaceAll(msg, form, redactedText)
+		}
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return &scrubbedError{msg: msg, err: err}
+}
+
+// sessionConnector runs setup statements (USE ROLE, ...) on every new connection.
+// database/sql has no per-connection hook, and a pooled connection can be replaced at any time.
+type sessionConnector struct {
+	driver.Connector
+	setup []string
+}
+
+func (c sessionConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil || len(c.setup) == 0 {
+		return conn, err
+	}
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("snowflake connection does not support session setup statements")
+	}
+	for _, stmt := range c.setup {
+		if _, err := execer.ExecContext(ctx, stmt, nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to run %q: %w", stmt, err)
+		}
+	}
+	return conn, nil
+}
+
+// connectWithRetry calls getSnowflakeConnection until it succeeds, backing off
+// between attempts. Snowflake rejecting the login itself (bad credentials, unknown
+// user or role) is returned at once: repeating it would only risk locking the user.
+func connectWithRetry(config *AccountConfig, pool PoolSettings, pingTimeout time.Duration, attempts int, delay time.Duration) (*sql.DB, crypto.Signer, error) {
+	for attempt := 1; ; attempt++ {
+		db, privateKey, err := getSnowflakeConnection(config, pool, pingTimeout)
+		if err == nil {
+			return db, privateKey, nil
+		}
+
+		var sfErr *gosnowflake.SnowflakeError
+		if errors.As(err, &sfErr) && !isRetryableError(err) {
+			return nil, nil, err
+		}
+		if attempt >= attempts {
+			return nil, nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		log.Printf("Connection attempt %d/%d to Snowflake account %s failed: %v (retrying in %s)",
+			attempt, attempts, config.Name, err, delay)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxConnectRetryDelay {
+			delay = maxConnectRetryDelay
+		}
+	}
+}
+
+// Security Fix #3: Clear sensitive data from memory, wherever it was loaded from
+// (environment, files, Docker secrets, or AWS Secrets Manager)
+func clearSensitiveData(config *AccountConfig) {
+	// Clear password
+	if config.Password != "" {
+		passwordBytes := []byte(config.Password)
+		for i := range passwordBytes {
+			passwordBytes[i] = 0
+		}
+		config.Password = ""
+	}
+
+	// Clear passphrase
+	if config.PrivateKeyPassphrase != "" {
+		passphraseBytes := []byte(config.PrivateKeyPassphrase)
+		for i := range passphraseBytes {
+			passphraseBytes[i] = 0
+		}
+		config.PrivateKeyPassphrase = ""
+	}
+
+	// Clear OAuth token
+	if config.OAuthToken != "" {
+		tokenBytes := []byte(config.OAuthToken)
+		for i := range tokenBytes {
+			tokenBytes[i] = 0
+		}
+		config.OAuthToken = ""
+	}
+}
+
+// clearPrivateKey zeroes out private key material from memory
+// This prevents the private key from being extracted via memory dumps after it's no longer needed
+func clearPrivateKey(key crypto.Signer) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		clearRSAPrivateKey(k)
+	case *ecdsa.PrivateKey:
+		// Zero out the private scalar (D)
+		if k != nil && k.D != nil {
+			k.D.SetInt64(0)
+		}
+	case ed25519.PrivateKey:
+		// The seed and public key share one byte slice
+		for i := range k {
+			k[i] = 0
+		}
+	}
+}
+
+// clearRSAPrivateKey zeroes out RSA private exponent, primes, and CRT values
+func clearRSAPrivateKey(key *rsa.PrivateKey) {
+	if key == nil {
+		return
+	}
+
+	// Zero out the private exponent (D) - the most sensitive part of the private key
+	if key.D != nil {
+		key.D.SetInt64(0)
+	}
+
+	// Clear the prime factors - these can be used to reconstruct the private key
+	if key.Primes != nil {
+		for i := range key.Primes {
+			if key.Primes[i] != nil {
+				key.Primes[i].SetInt64(0)
+			}
+		}
+		key.Primes = nil
+	}
+
+	// Clear precomputed values used for CRT optimization
+	if key.Precomputed.Dp != nil {
+		key.Precomputed.Dp.SetInt64(0)
+	}
+	if key.Precomputed.Dq != nil {
+		key.Precomputed.Dq.SetInt64(0)
+	}
+	if key.Precomputed.Qinv != nil {
+		key.Precomputed.Qinv.SetInt64(0)
+	}
+	if key.Precomputed.CRTValues != nil {
+		for i := range key.Precomputed.CRTValues {
+			if key.Precomputed.CRTValues[i].Exp != nil {
+				key.Precomputed.CRTValues[i].Exp.SetInt64(0)
+			}
+			if key.Precomputed.CRTValues[i].Coeff != nil {
+				key.Precomputed.CRTValues[i].Coeff.SetInt64(0)
+			}
+			if key.Precomputed.CRTValues[i].R != nil {
+				key.Precomputed.CRTValues[i].R.SetInt64(0)
+			}
+		}
+		key.Precomputed.CRTValues = nil
+	}
+}
+
+// contentSecurityPolicy builds the CSP header value; imgOrigin is an extra origin images
+// may load from (the DASHBOARD_LOGO_URL host), or "" for none
+func contentSecurityPolicy(imgOrigin string) string {
+	imgSrc := "'self' data:"
+	if imgOrigin != "" {
+		imgSrc += " " + imgOrigin
+	}
+	return "default-src 'self'; script-src 'unsafe-inline' 'self'; style-src 'unsafe-inline' 'self'; img-src " + imgSrc + "; font-src 'self'; connect-src 'self'; frame-ancestors 'none'"
+}
+
+// cspHeader is sent by securityHeaders. main widens it for a remote DASHBOARD_LOGO_URL.
+var cspHeader = contentSecurityPolicy("")
+
+// Security Fix #5: Add security headers middleware
+func securityHeaders(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Content Security Policy - only allow inline scripts from same origin
+		// This prevents XSS attacks by restricting script sources
+		w.Header().Set("Content-Security-Policy", cspHeader)
+
+		// Prevent MIME type sniffing
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		// Prevent clickjacking attacks
+		w.Header().Set("X-Frame-Options", "DENY")
+
+		// Enable XSS protection in older browsers
+		w.Header().Set("X-XSS-Protection", "1; mode=block")
+
+		// Control referrer information
+		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
+
+		// Permissions policy - disable unnecessary features
+		w.Header().Set("Permissions-Policy", "geolocation=(), microphone=(), camera=()")
+
+		// Only advertise HSTS when the request actually arrived over TLS
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		}
+
+		next(w, r)
+	}
+}
+
+// requestIDHeader carries the per-request correlation ID in both directions
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID restricts client-supplied IDs so they can't inject into log lines
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// requestID middleware tags each request with a correlation ID, reusing the
+// caller's X-Request-ID when it is well-formed, and echoes it in the response
+func requestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestLogger returns a logger that includes the request ID carried by ctx, if any
+func requestLogger(ctx context.Context) *slog.Logger {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// parseCORSOrigins validates CORS_ALLOWED_ORIGINS entries: either "*" on its own
+// or origins of the form scheme://host[:port]
+func parseCORSOrigins(origins []string) ([]string, error) {
+	for _, origin := range origins {
+		if origin == "*" {
+			if len(origins) > 1 {
+				return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS: \"*\" cannot be combined with other origins")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS: invalid origin %q (expected e.g. https://app.example.com)", origin)
+		}
+	}
+	return origins, nil
+}
+
+// corsHeaders middleware lets the listed origins call the /api/ endpoints from a browser.
+// It answers preflight requests itself, so they never reach the handlers, and adds
+// nothing when no origins are configured.
+func corsHeaders(allowedOrigins []string, next http.HandlerFunc) http.HandlerFunc {
+	if len(allowedOrigins) == 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next(w, r)
+			return
+		}
+
+		allowed := ""
+		for _, o := range allowedOrigins {
+			if o == "*" {
+				allowed = "*"
+				break
+			}
+			if o == origin {
+				allowed = origin
+				break
+			}
+		}
+		if allowed != "*" {
+			// The response depends on the request's Origin, so caches must key on it
+			w.Header().Add("Vary", "Origin")
+		}
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if allowed == "" {
+			if preflight {
+				writeJSONError(w, r, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Cache, ETag")
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Request-ID, Authorization, X-API-Key, If-None-Match")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// rateLimiterIdleTTL is how long a client's limiter is kept after its last request
+const rateLimiterIdleTTL = 10 * time.Minute
+
+// rateLimitExempt paths are probed by orchestrators and monitoring, which must never be throttled
+var rateLimitExempt = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// parseTrustedProxies reads TRUSTED_PROXIES entries, each an IP address or CIDR range
+func parseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q (must be an IP address or CIDR range)", entry)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// clientLimiter is one client's token bucket and when it was last used
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipRateLimiter gives every client IP its own token bucket, so one noisy
+// client can't use up the allowance of everyone else
+type ipRateLimiter struct {
+	limit          rate.Limit
+	burst          int
+	trustedProxies []netip.Prefix
+
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+}
+
+func newIPRateLimiter(perMinute, burst int, trustedProxies []netip.Prefix) *ipRateLimiter {
+	return &ipRateLimiter{
+		limit:          rate.Limit(float64(perMinute) / 60),
+		burst:          burst,
+		trustedProxies: trustedProxies,
+		clients:        make(map[string]*clientLimiter),
+	}
+}
+
+// isTrustedProxy reports whether addr belongs to a configured reverse proxy
+func isTrustedProxy(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address requests are limited and logged by. X-Forwarded-For is
+// only believed when the connection comes from a trusted proxy, and is read from the
+// right so a client can't pick its own identity by sending the header itself.
+func clientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap()
+
+	if isTrustedProxy(addr, trustedProxies) {
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			addr = hop.Unmap()
+			if !isTrustedProxy(addr, trustedProxies) {
+				break
+			}
+		}
+	}
+	return addr.String()
+}
+
+// reserve takes a token for ip, returning how long the client must wait when none is left
+func (l *ipRateLimiter) reserve(ip string) time.Duration {
+	l.mu.Lock()
+	client, ok := l.clients[ip]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = time.Now()
+	l.mu.Unlock()
+
+	reservation := client.limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		// Rejected requests don't consume tokens
+		reservation.Cancel()
+		return delay
+	}
+	return 0
+}
+
+// evictIdle drops limiters for clients not seen within rateLimiterIdleTTL, bounding memory
+func (l *ipRateLimiter) evictIdle(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().Add(-rateLimiterIdleTTL)
+		l.mu.Lock()
+		for ip, client := range l.clients {
+			if client.lastSeen.Before(cutoff) {
+				delete(l.clients, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// rateLimit middleware answers 429 with Retry-After once a client IP exceeds
+// its allowance. Health checks are exempt; does nothing when limiter is nil.
+func rateLimit(limiter *ipRateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rateLimitExempt[r.URL.Path] {
+			next(w, r)
+			return
+		}
+
+		ip := clientIP(r, limiter.trustedProxies)
+		if delay := limiter.reserve(ip); delay > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeJSONError(w, r, "Too many requests", http.StatusTooManyRequests)
+			} else {
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			}
+			requestLogger(r.Context()).Warn("Rate limit exceeded", "client_ip", ip)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// minAPIKeyLength keeps guessable keys out of API_KEYS
+const minAPIKeyLength = 16
+
+// dashboardSessionCookie lets the dashboard's own JavaScript read the API when its page was loaded with a key
+const dashboardSessionCookie = "dashboard_session"
+
+// apiLockedKey marks a page request that got no dashboard session while API_KEYS is set,
+// so the page leaves out the controls whose API calls would be refused
+type apiLockedKey struct{}
+
+// apiLocked reports whether the page being rendered for ctx can't call the API
+func apiLocked(ctx context.Context) bool {
+	locked, _ := ctx.Value(apiLockedKey{}).(bool)
+	return locked
+}
+
+// sessionAllowed reports whether the dashboard session may authorize r: reads, plus the
+// dashboard's own Acknowledge and Refresh Now buttons. The cookie is SameSite=Strict and the
+// ack POST requires a JSON body, so other sites can't send either on a visitor's behalf.
+// Test notifications reach outside the dashboard and always need a key.
+func sessionAllowed(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case http.MethodPost:
+		if r.URL.Path == "/api/refresh" {
+			return true
+		}
+		rest, ok := strings.CutPrefix(r.URL.Path, "/api/queries/")
+		id, isAck := strings.CutSuffix(rest, "/ack")
+		return ok && isAck && id != "" && !strings.Contains(id, "/")
+	}
+	return false
+}
+
+// requestAPIKey returns the key sent as X-API-Key or Authorization: Bearer, or "" when there is none
+func requestAPIKey(r *http.Request) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return bearer
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// parseAPIKeys splits the comma-separated API_KEYS value, rejecting short keys
+func parseAPIKeys(value string) ([]string, error) {
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		if len(key) < minAPIKeyLength {
+			return nil, fmt.Errorf("API_KEYS entries must be at least %d characters long", minAPIKeyLength)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// apiKeyAuth middleware requires one of keys on /api/ requests, sent as
+// "Authorization: Bearer <key>" or "X-API-Key: <key>". The HTML pages stay
+// open; when a page request carries a key itself, the browser also gets a
+// session cookie that the dashboard's own API calls are accepted with (see
+// sessionAllowed). Does nothing when keys is empty.
+func apiKeyAuth(keys []string, next http.HandlerFunc) http.HandlerFunc {
+	if len(keys) == 0 {
+		return next
+	}
+
+	// Compare fixed-length digests so neither a key's length nor its position leaks through timing
+	digests := make([][]byte, len(keys))
+	for i, key := range keys {
+		sum := sha256.Sum256([]byte(key))
+		digests[i] = sum[:]
+	}
+	valid := func(key string) bool {
+		sum := sha256.Sum256([]byte(key))
+		match := 0
+		for _, d := range digests {
+			match |= subtle.ConstantTimeCompare(sum[:], d)
+		}
+		return match == 1
+	}
+
+	// Derived from the keys, so sessions survive restarts and end when the keys are rotated
+	mac := hmac.New(sha256.New, []byte(strings.Join(keys, ",")))
+	mac.Write([]byte(dashboardSessionCookie))
+	session := hex.EncodeToString(mac.Sum(nil))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := requestAPIKey(r)
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			// Only a page request that itself carried a key (e.g. injected by an authenticating proxy) gets a session;
+			// handing one to every visitor would open the API to anyone who can load the dashboard
+			if r.URL.Path == "/" || strings.HasPrefix(r.URL.Path, "/query/") {
+				if key != "" && valid(key) {
+					http.SetCookie(w, &http.Cookie{
+						Name:     dashboardSessionCookie,
+						Value:    session,
+						Path:     "/api/",
+						HttpOnly: true,
+						Secure:   r.TLS != nil,
+						SameSite: http.SameSiteStrictMode, // Also keeps other sites from posting acknowledgements
+					})
+				} else {
+					r = r.WithContext(context.WithValue(r.Context(), apiLockedKey{}, true))
+				}
+			}
+			next(w, r)
+			return
+		}
+
+		if key != "" && valid(key) {
+			next(w, r)
+			return
+		}
+		if cookie, err := r.Cookie(dashboardSessionCookie); err == nil && sessionAllowed(r) &&
+			subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(session)) == 1 {
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		writeJSONError(w, r, "Unauthorized", http.StatusUnauthorized)
+	}
+}
+
+// tracer creates the dashboard's spans. Until initTracing installs an SDK
+// provider it hands out no-op spans, so instrumentation costs nothing when
+// tracing is off.
+var tracer = otel.Tracer("github.com/rhousand/snowflake-failed-queries-dashboard")
+
+// defaultServiceName is reported to the collector unless OTEL_SERVICE_NAME is set
+const defaultServiceName = "snowflake-failed-queries-dashboard"
+
+// initTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT is set.
+// The exporter reads the standard OTEL_EXPORTER_OTLP_* variables itself. The
+// returned function flushes pending spans; it is a no-op when tracing is off.
+func initTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	// Continue traces started by upstream services (W3C traceparent)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// endSpan records err (if any) on span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// statusRecorder remembers the response status and size for traceRequest and accessLog
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (flushing, deadlines)
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// traceRequest middleware wraps each request in a server span named after the
+// matched route pattern, so span names stay low-cardinality
+func traceRequest(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			))
+		defer span.End()
+		if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+			span.SetAttributes(attribute.String("request_id", id))
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(ctx)
+		next(rec, r)
+
+		// The mux records the matched pattern on the request it was given
+		if r.Pattern != "" {
+			span.SetName(r.Pattern)
+			span.SetAttributes(attribute.String("http.route", r.Pattern))
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	}
+}
+
+// accessLogExempt paths are probed too often for their requests to be worth logging
+var accessLogExempt = map[string]bool{
+	"/healthz": true,
+}
+
+// accessLog middleware logs every request's method, path, status, response size,
+// client IP, and duration. The query string is left out so a parameter carrying a
+// secret could never reach the logs. Does nothing when disabled.
+func accessLog(enabled bool, trustedProxies []netip.Prefix, next http.HandlerFunc) http.HandlerFunc {
+	if !enabled {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if accessLogExempt[r.URL.Path] {
+			next(w, r)
+			return
+		}
+
+		started := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		requestLogger(r.Context()).Info("HTTP request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"client_ip", clientIP(r, trustedProxies),
+			"duration_ms", time.Since(started).Milliseconds(),
+		)
+	}
+}
+
+// limitRequestSize middleware limits the size of incoming request bodies
+// to prevent memory exhaustion attacks from large payloads
+func limitRequestSize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Limit request body to 1 MB
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+		next(w, r)
+	}
+}
+
+// serverAddr combines BIND_ADDR and PORT into a listen address and validates it
+func serverAddr(bindAddr, port string) (string, error) {
+	addr := net.JoinHostPort(bindAddr, port)
+
+	_, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid PORT %q (must be between 1 and 65535)", p)
+	}
+
+	return addr, nil
+}
+
+// newTLSConfig returns a TLS configuration limited to modern protocol versions and AEAD cipher suites
+func newTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// TLS 1.3 suites are not configurable; these apply to TLS 1.2 only
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	}
+}
+
+// gzipMinSize is the smallest response worth compressing
+const gzipMinSize = 1024
+
+// gzipResponseWriter buffers the start of a response and only switches to gzip
+// once it grows past gzipMinSize, so small responses are sent uncompressed
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz     *gzip.Writer
+	buf    []byte
+	status int
+}
+
+// WriteHeader is deferred until we know whether the response will be compressed
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.status == 0 {
+		g.status = status
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+
+	g.buf = append(g.buf, p...)
+	if len(g.buf) < gzipMinSize {
+		return len(p), nil
+	}
+
+	// Large enough to be worth compressing: send headers and flush the buffer through gzip
+	g.writeHeader()
+	g.ResponseWriter.Header().Set("Content-Encoding", "gzip")
+	g.ResponseWriter.Header().Del("Content-Length")
+	g.ResponseWriter.WriteHeader(g.statusCode())
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	if _, err := g.gz.Write(g.buf); err != nil {
+		return 0, err
+	}
+	g.buf = nil
+	return len(p), nil
+}
+
+// writeHeader sets Content-Type from the buffered bytes, since sniffing compressed output would be wrong
+func (g *gzipResponseWriter) writeHeader() {
+	if g.ResponseWriter.Header().Get("Content-Type") == "" {
+		g.ResponseWriter.Header().Set("Content-Type", http.DetectContentType(g.buf))
+	}
+}
+
+func (g *gzipResponseWriter) statusCode() int {
+	if g.status == 0 {
+		return http.StatusOK
+	}
+	return g.status
+}
+
+// finish closes the gzip stream, or writes the small buffered response as-is
+func (g *gzipResponseWriter) finish() error {
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+
+	if len(g.buf) > 0 {
+		g.writeHeader()
+	}
+	g.ResponseWriter.WriteHeader(g.statusCode())
+	_, err := g.ResponseWriter.Write(g.buf)
+	return err
+}
+
+// gzipResponse middleware compresses responses for clients that accept gzip
+// This cuts bandwidth for the dashboard's polling of large JSON payloads
+func gzipResponse(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		next(gw, r)
+		if err := gw.finish(); err != nil {
+			log.Printf("Error writing compressed response: %v", err)
+		}
+	}
+}
+
+// etagResponseWriter buffers a response so its ETag can be computed from the body
+type etagResponseWriter struct {
+	http.ResponseWriter
+	buf    bytes.Buffer
+	status int
+}
+
+func (e *etagResponseWriter) WriteHeader(status int) {
+	if e.status == 0 {
+		e.status = status
+	}
+}
+
+func (e *etagResponseWriter) Write(p []byte) (int, error) {
+	return e.buf.Write(p)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag (weak comparison, as RFC 9110 requires)
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// etagResponse middleware tags successful GET responses with a hash of their body and
+// answers a matching If-None-Match with 304 Not Modified, so polling clients only
+// download data that changed. The tag is weak because gzipResponse may re-encode the body.
+func etagResponse(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		ew := &etagResponseWriter{ResponseWriter: w}
+		next(ew, r)
+		if ew.status == 0 {
+			ew.status = http.StatusOK
+		}
+
+		if ew.status == http.StatusOK {
+			sum := sha256.Sum256(ew.buf.Bytes())
+			etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+			w.Header().Set("ETag", etag)
+			// Let browsers keep the body, but revalidate before every reuse
+			if w.Header().Get("Cache-Control") == "" {
+				w.Header().Set("Cache-Control", "no-cache")
+			}
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.Header().Del("Content-Type")
+				w.Header().Del("Content-Length")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		w.WriteHeader(ew.status)
+		if _, err := w.Write(ew.buf.Bytes()); err != nil {
+			log.Printf("Error writing response: %v", err)
+		}
+	}
+}
+
+// QueryOptions controls which failed queries getFailedQueries returns
+type QueryOptions struct {
+	LookbackHours int    // How far back to look for failed queries
+	RowLimit      int    // Maximum number of rows to return
+	Offset        int    // Number of rows to skip (for pagination)
+	UserName      string // Optional exact-match filter on USER_NAME
+	UserPattern   string // Optional ILIKE filter on USER_NAME, e.g. SVC_%
+	ExcludeUsers  bool   // Inverts UserPattern to NOT ILIKE
+	QueryType     string // Optional exact-match filter on QUERY_TYPE
+	QueryID       string // Optional exact-match filter on QUERY_ID
+	WarehouseName string // Optional exact-match filter on WAREHOUSE_NAME (noWarehouse matches NULL)
+	RoleName      string // Optional exact-match filter on ROLE_NAME
+	DatabaseName  string // Optional exact-match filter on DATABASE_NAME
+	AccountName   string // Optional exact-match filter on ACCOUNT_NAME (organization_usage only)
+
+	// Optional execution time bounds in seconds (0 means unbounded)
+	MinDurationSeconds float64
+	MaxDurationSeconds float64
+	DurationBucket     string // Optional durationBuckets name, applied on top of the bounds
+
+	// Optional absolute window (UTC); when set it replaces LookbackHours
+	StartTime time.Time
+	EndTime   time.Time
+
+	// Result ordering; SortBy is a sortColumns key ("" means newest first)
+	SortBy        string
+	SortAscending bool
+
+	ExcludePatterns   []string // QUERY_TEXT ILIKE patterns to exclude
+	ExcludeErrorCodes []string // ERROR_CODE values to exclude
+
+	Predicates []QueryPredicate // Validated ?filter= conditions, all of which must hold
+}
+
+// defaultQueryOptions returns the query options derived from the loaded configuration
+func defaultQueryOptions(config *Config) QueryOptions {
+	return QueryOptions{
+		LookbackHours:     config.LookbackHours,
+		RowLimit:          config.RowLimit,
+		ExcludePatterns:   config.ExcludePatterns,
+		ExcludeErrorCodes: config.ExcludeErrorCodes,
+	}
+}
+
+// maxUserNameLength matches Snowflake's maximum identifier length
+const maxUserNameLength = 255
+
+// validUserName allows the characters Snowflake permits in user names (including email-style names)
+var validUserName = regexp.MustCompile(`^[A-Za-z0-9_.@$-]+$`)
+
+// validQueryType matches Snowflake QUERY_TYPE values such as SELECT or CREATE_TABLE_AS_SELECT
+var validQueryType = regexp.MustCompile(`^[A-Z_]{1,64}$`)
+
+// validWarehouseName matches unquoted Snowflake warehouse identifiers
+var validWarehouseName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]{0,254}$`)
+
+// validRoleName matches unquoted Snowflake role identifiers
+var validRoleName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]{0,254}$`)
+
+// validDatabaseName matches unquoted Snowflake database identifiers
+var validDatabaseName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]{0,254}$`)
+
+// validOrgAccountName matches Snowflake account names within an organization
+var validOrgAccountName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,254}$`)
+
+// parseDatabaseParam reads the optional ?database= filter, upper-cased like
+// the unquoted identifiers in QUERY_HISTORY
+func parseDatabaseParam(r *http.Request) (string, error) {
+	database := r.URL.Query().Get("database")
+	if database == "" {
+		return "", nil
+	}
+	if !validDatabaseName.MatchString(database) {
+		return "", errors.New("invalid database parameter")
+	}
+	return strings.ToUpper(database), nil
+}
+
+// noWarehouse selects queries that ran without a warehouse (?warehouse=(none));
+// it can't collide with a real name because parentheses aren't valid in one
+const noWarehouse = "(none)"
+
+// validQueryID matches Snowflake query IDs (UUID-style hex with dashes)
+var validQueryID = regexp.MustCompile(`^[0-9a-fA-F-]{1,64}$`)
+
+// validateUserName rejects user filter values that are too long or contain unexpected characters
+func validateUserName(user string) error {
+	if len(user) > maxUserNameLength {
+		return fmt.Errorf("user name exceeds %d characters", maxUserNameLength)
+	}
+	if !validUserName.MatchString(user) {
+		return errors.New("user name contains invalid characters")
+	}
+	return nil
+}
+
+// defaultServiceAccountPattern matches service accounts named like SVC_LOADER
+const defaultServiceAccountPattern = "SVC_%"
+
+// validUserPattern is validUserName plus the % wildcard (_ is already allowed)
+var validUserPattern = regexp.MustCompile(`^[A-Za-z0-9_.@$%-]+$`)
+
+// validateUserPattern checks a USER_NAME ILIKE pattern. Patterns made only of
+// wildcards (%, _) would match nearly everyone, so at least one literal is required.
+func validateUserPattern(pattern string) error {
+	if len(pattern) > maxUserNameLength {
+		return fmt.Errorf("pattern exceeds %d characters", maxUserNameLength)
+	}
+	if !validUserPattern.MatchString(pattern) {
+		return errors.New("pattern contains invalid characters")
+	}
+	if strings.Trim(pattern, "%_") == "" {
+		return errors.New("pattern is too broad (needs at least one character other than % and _)")
+	}
+	return nil
+}
+
+// maxPageOffset bounds how deep clients can page into the result set
+const maxPageOffset = 100000
+
+// PaginatedQueries is the response envelope for /api/v2/queries
+type PaginatedQueries struct {
+	Queries    []FailedQuery `json:"queries"`
+	Limit      int           `json:"limit"`
+	Offset     int           `json:"offset"`
+	Total      *int          `json:"total"`       // Only known once the last page has been reached
+	NextOffset *int          `json:"next_offset"` // Null when there are no more results
+
+	Freshness *DataFreshness `json:"freshness"` // ACCOUNT_USAGE lag; null when it couldn't be determined
+}
+
+// parseIntParam reads an integer query parameter, returning def when absent
+// and an error when the value is non-numeric or outside [minVal, maxVal]
+func parseIntParam(r *http.Request, name string, def, minVal, maxVal int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < minVal || n > maxVal {
+		return 0, fmt.Errorf("invalid %s parameter (must be between %d and %d)", name, minVal, maxVal)
+	}
+
+	return n, nil
+}
+
+// maxDurationSeconds bounds the duration filters (Snowflake's maximum statement timeout is 7 days)
+const maxDurationSeconds = 7 * 24 * 60 * 60
+
+// parseFloatParam reads a non-negative float query parameter, returning 0 when absent
+func parseFloatParam(r *http.Request, name string, maxVal float64) (float64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || f < 0 || f > maxVal {
+		return 0, fmt.Errorf("invalid %s parameter (must be a number of seconds between 0 and %g)", name, maxVal)
+	}
+
+	return f, nil
+}
+
+// parseTimeRange reads the optional ?start= and ?end= RFC3339 parameters.
+// Both must be given together, start must precede end, and the range may not exceed maxRange.
+func parseTimeRange(r *http.Request, maxRange time.Duration) (time.Time, time.Time, error) {
+	startParam := r.URL.Query().Get("start")
+	endParam := r.URL.Query().Get("end")
+	if startParam == "" && endParam == "" {
+		return time.Time{}, time.Time{}, nil
+	}
+	if startParam == "" || endParam == "" {
+		return time.Time{}, time.Time{}, errors.New("start and end parameters must be given together")
+	}
+
+	start, err := time.Parse(time.RFC3339, startParam)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("invalid start parameter (must be an RFC3339 timestamp, e.g. 2024-01-02T14:00:00Z)")
+	}
+	end, err := time.Parse(time.RFC3339, endParam)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("invalid end parameter (must be an RFC3339 timestamp, e.g. 2024-01-02T16:00:00Z)")
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, errors.New("invalid time range (start must be before end)")
+	}
+	if end.Sub(start) > maxRange {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid time range (must not exceed %d hours)", int(maxRange.Hours()))
+	}
+
+	return start.UTC(), end.UTC(), nil
+}
+
+// applyQueryFilters validates the optional API filter parameters and applies them to opts
+func applyQueryFilters(r *http.Request, opts *QueryOptions, maxRange time.Duration) error {
+	// Optional server-side user filter, validated before it is bound into the query
+	if user := r.URL.Query().Get("user"); user != "" {
+		if err := validateUserName(user); err != nil {
+			return errors.New("invalid user parameter")
+		}
+		opts.UserName = user
+	}
+
+	// Optional user name pattern; a leading ! keeps only the users that don't match
+	if pattern := r.URL.Query().Get("user_pattern"); pattern != "" {
+		exclude := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		if err := validateUserPattern(pattern); err != nil {
+			return fmt.Errorf("invalid user_pattern parameter: %w", err)
+		}
+		opts.UserPattern, opts.ExcludeUsers = pattern, exclude
+	}
+
+	// Optional query type filter (case-insensitive input, stored upper-case like QUERY_HISTORY)
+	if queryType := r.URL.Query().Get("query_type"); queryType != "" {
+		queryType = strings.ToUpper(queryType)
+		if !validQueryType.MatchString(queryType) {
+			return errors.New("invalid query_type parameter")
+		}
+		opts.QueryType = queryType
+	}
+
+	// Optional warehouse filter; unquoted identifiers are stored upper-case
+	if warehouse := r.URL.Query().Get("warehouse"); warehouse != "" {
+		if warehouse != noWarehouse {
+			if !validWarehouseName.MatchString(warehouse) {
+				return errors.New("invalid warehouse parameter")
+			}
+			warehouse = strings.ToUpper(warehouse)
+		}
+		opts.WarehouseName = warehouse
+	}
+
+	// Optional role filter, e.g. to triage a service role's failures separately
+	if role := r.URL.Query().Get("role"); role != "" {
+		if !validRoleName.MatchString(role) {
+			return errors.New("invalid role parameter")
+		}
+		opts.RoleName = strings.ToUpper(role)
+	}
+
+	// Optional database filter behind the dashboard's MONITORED_DATABASES tabs
+	var err error
+	if opts.DatabaseName, err = parseDatabaseParam(r); err != nil {
+		return err
+	}
+
+	// Optional organization account filter; ?account= picks the configured connection instead
+	if accountName := r.URL.Query().Get("account_name"); accountName != "" {
+		if !organizationUsage() {
+			return errors.New("the account_name parameter requires DATA_SOURCE=organization_usage")
+		}
+		if !validOrgAccountName.MatchString(accountName) {
+			return errors.New("invalid account_name parameter")
+		}
+		opts.AccountName = strings.ToUpper(accountName)
+	}
+
+	// Optional execution time bounds
+	if opts.MinDurationSeconds, err = parseFloatParam(r, "min_duration", maxDurationSeconds); err != nil {
+		return err
+	}
+	if opts.MaxDurationSeconds, err = parseFloatParam(r, "max_duration", maxDurationSeconds); err != nil {
+		return err
+	}
+	if opts.MaxDurationSeconds > 0 && opts.MaxDurationSeconds < opts.MinDurationSeconds {
+		return errors.New("invalid duration range (max_duration must not be less than min_duration)")
+	}
+	if bucket := r.URL.Query().Get("duration_bucket"); bucket != "" {
+		if _, ok := findDurationBucket(bucket); !ok {
+			return fmt.Errorf("invalid duration_bucket parameter (must be one of: %s)", strings.Join(durationBucketNames(), ", "))
+		}
+		opts.DurationBucket = bucket
+	}
+
+	// Optional absolute window overriding the lookback
+	if opts.StartTime, opts.EndTime, err = parseTimeRange(r, maxRange); err != nil {
+		return err
+	}
+
+	// Optional structured predicates beyond the built-in filters
+	if opts.Predicates, err = parseFilterParam(r); err != nil {
+		return err
+	}
+
+	// Optional override of whether cancellations are hidden, whatever EXCLUDE_ERROR_CODES says
+	switch r.URL.Query().Get("include_cancellations") {
+	case "":
+	case "true":
+		opts.ExcludeErrorCodes = withoutErrorCode(opts.ExcludeErrorCodes, cancelledErrorCode)
+	case "false":
+		opts.ExcludeErrorCodes = append(withoutErrorCode(opts.ExcludeErrorCodes, cancelledErrorCode), cancelledErrorCode)
+	default:
+		return errors.New("invalid include_cancellations parameter (must be true or false)")
+	}
+
+	return nil
+}
+
+// withoutErrorCode returns a copy of codes without code, leaving the configured list untouched
+func withoutErrorCode(codes []string, code string) []string {
+	kept := make([]string, 0, len(codes))
+	for _, c := range codes {
+		if c != code {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// excludesCancellations reports whether cancellations are hidden by default
+func excludesCancellations(codes []string) bool {
+	for _, c := range codes {
+		if c == cancelledErrorCode {
+			return true
+		}
+	}
+	return false
+}
+
+// queryColumn describes one QUERY_HISTORY column selected into FailedQuery.
+// Adding a column means adding a FailedQuery field and one failedQueryColumns entry.
+type queryColumn struct {
+	Field string // JSON name; must match the FailedQuery json tag
+	SQL   string // SELECT expression
+
+	// scan returns a scan destination and a func that copies the scanned value into q
+	scan func(q *FailedQuery) (interface{}, func())
+	// value returns the field's current value from q
+	value func(q *FailedQuery) interface{}
+}
+
+// column builds a queryColumn for a FailedQuery field; NULLs become the zero value
+func column[T any](field, sqlExpr string, get func(q *FailedQuery) *T) queryColumn {
+	return queryColumn{
+		Field: field,
+		SQL:   sqlExpr,
+		scan: func(q *FailedQuery) (interface{}, func()) {
+			var v sql.Null[T]
+			return &v, func() { *get(q) = v.V }
+		},
+		value: func(q *FailedQuery) interface{} { return *get(q) },
+	}
+}
+
+// source returns the QUERY_HISTORY column the SELECT expression reads
+func (c queryColumn) source() string {
+	return strings.Fields(c.SQL)[0]
+}
+
+// alias returns the name the SELECT expression is returned under
+func (c queryColumn) alias() string {
+	fields := strings.Fields(c.SQL)
+	return strings.ToUpper(fields[len(fields)-1])
+}
+
+// unavailableHistoryColumns are failedQueryColumns sources and queryMetricsColumns the
+// DATA_SOURCE view lacks; they are selected as NULL, leaving the field empty. ACCOUNT_USAGE has no ACCOUNT_NAME;
+// for ORGANIZATION_USAGE the set is replaced at startup from missingHistoryColumns.
+var unavailableHistoryColumns = map[string]bool{"ACCOUNT_NAME": true}
+
+// failedQueryColumns lists the selected columns in SELECT order
+var failedQueryColumns = []queryColumn{
+	column("query_id", "QUERY_ID", func(q *FailedQuery) *string { return &q.QueryID }),
+	column("query_text", "QUERY_TEXT", func(q *FailedQuery) *string { return &q.QueryText }),
+	column("user_name", "USER_NAME", func(q *FailedQuery) *string { return &q.UserName }),
+	column("warehouse_name", "WAREHOUSE_NAME", func(q *FailedQuery) *string { return &q.WarehouseName }),
+	column("database_name", "DATABASE_NAME", func(q *FailedQuery) *string { return &q.DatabaseName }),
+	column("schema_name", "SCHEMA_NAME", func(q *FailedQuery) *string { return &q.SchemaName }),
+	column("role_name", "ROLE_NAME", func(q *FailedQuery) *string { return &q.RoleName }),
+	column("query_type", "QUERY_TYPE", func(q *FailedQuery) *string { return &q.QueryType }),
+	column("error_code", "ERROR_CODE", func(q *FailedQuery) *string { return &q.ErrorCode }),
+	column("error_message", "ERROR_MESSAGE", func(q *FailedQuery) *string { return &q.ErrorMessage }),
+	column("start_time", "START_TIME", func(q *FailedQuery) *time.Time { return &q.StartTime }),
+	column("end_time", "END_TIME", func(q *FailedQuery) *time.Time { return &q.EndTime }),
+	column("execution_time_seconds", "TOTAL_ELAPSED_TIME / 1000.0 as EXECUTION_TIME_SECONDS", func(q *FailedQuery) *float64 { return &q.ExecutionTime }),
+	column("bytes_scanned", "BYTES_SCANNED", func(q *FailedQuery) *int64 { return &q.BytesScanned }),
+	column("credits_used_cloud_services", "CREDITS_USED_CLOUD_SERVICES", func(q *FailedQuery) *float64 { return &q.CreditsUsedCloudServices }),
+	column("account_name", "ACCOUNT_NAME", func(q *FailedQuery) *string { return &q.AccountName }),
+}
+
+// columnFields lists the field names clients can request via ?fields=
+func columnFields() []string {
+	fields := make([]string, 0, len(failedQueryColumns))
+	for _, c := range failedQueryColumns {
+		fields = append(fields, c.Field)
+	}
+	return fields
+}
+
+// parseFieldsParam reads the optional comma-separated ?fields= list.
+// It returns nil when the parameter is absent (all fields).
+func parseFieldsParam(r *http.Request) ([]queryColumn, error) {
+	v := r.URL.Query().Get("fields")
+	if v == "" {
+		return nil, nil
+	}
+
+	var selected []queryColumn
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, c := range failedQueryColumns {
+			if c.Field == name {
+				selected = append(selected, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("invalid fields parameter (see /api/columns for available fields)")
+		}
+	}
+
+	return selected, nil
+}
+
+// projectFields returns each query as an object holding only the given fields
+func projectFields(queries []FailedQuery, fields []queryColumn) []map[string]interface{} {
+	projected := make([]map[string]interface{}, 0, len(queries))
+	for i := range queries {
+		m := make(map[string]interface{}, len(fields))
+		for _, c := range fields {
+			m[c.Field] = c.value(&queries[i])
+		}
+		projected = append(projected, m)
+	}
+	return projected
+}
+
+// sortColumns maps ?sort= values to the QUERY_HISTORY column they order by.
+// Only these fixed column names are ever placed in ORDER BY.
+var sortColumns = map[string]string{
+	"start_time":     "START_TIME",
+	"execution_time": "TOTAL_ELAPSED_TIME",
+	"user_name":      "USER_NAME",
+}
+
+// applySortParams reads ?sort= and ?order= (asc or desc, default desc)
+func applySortParams(r *http.Request, opts *QueryOptions) error {
+	if sortBy := r.URL.Query().Get("sort"); sortBy != "" {
+		if _, ok := sortColumns[sortBy]; !ok {
+			return errors.New("invalid sort parameter (must be start_time, execution_time, or user_name)")
+		}
+		opts.SortBy = sortBy
+	}
+
+	switch r.URL.Query().Get("order") {
+	case "", "desc":
+		opts.SortAscending = false
+	case "asc":
+		opts.SortAscending = true
+	default:
+		return errors.New("invalid order parameter (must be asc or desc)")
+	}
+	return nil
+}
+
+// filterField is a QUERY_HISTORY column that ?filter= may compare against
+type filterField struct {
+	column  string
+	numeric bool    // Compared as a number with eq/ne/gt/gte/lt/lte instead of as text
+	scale   float64 // Converts the API unit to the column's (numeric fields only)
+}
+
+// filterFields maps ?filter= field names to columns; nothing else can be named
+var filterFields = map[string]filterField{
+	"error_code":     {column: "ERROR_CODE"},
+	"error_message":  {column: "ERROR_MESSAGE"},
+	"user_name":      {column: "USER_NAME"},
+	"role_name":      {column: "ROLE_NAME"},
+	"warehouse_name": {column: "WAREHOUSE_NAME"},
+	"database_name":  {column: "DATABASE_NAME"},
+	"schema_name":    {column: "SCHEMA_NAME"},
+	"query_type":     {column: "QUERY_TYPE"},
+	"query_tag":      {column: "QUERY_TAG"},
+	"execution_time": {column: "TOTAL_ELAPSED_TIME", numeric: true, scale: 1000}, // Seconds; recorded in milliseconds
+	"bytes_scanned":  {column: "BYTES_SCANNED", numeric: true, scale: 1},
+}
+
+// filterOperators maps ?filter= operators to SQL templates for the column. The negated
+// ones keep NULLs, so error_code:ne:604 still returns failures without a code.
+var filterOperators = map[string]string{
+	"eq":        "%s = ?",
+	"ne":        "(%[1]s IS NULL OR %[1]s <> ?)",
+	"ilike":     "%s ILIKE ?",
+	"not_ilike": "(%[1]s IS NULL OR %[1]s NOT ILIKE ?)",
+	"gt":        "%s > ?",
+	"gte":       "%s >= ?",
+	"lt":        "%s < ?",
+	"lte":       "%s <= ?",
+}
+
+// textOperators and numericOperators are the operators valid for each kind of field
+var (
+	textOperators    = []string{"eq", "ne", "ilike", "not_ilike"}
+	numericOperators = []string{"eq", "ne", "gt", "gte", "lt", "lte"}
+)
+
+// Bounds on ?filter=, so a request can't build an arbitrarily large WHERE clause
+const (
+	maxFilterPredicates  = 10
+	maxFilterValueLength = 255
+)
+
+// QueryPredicate is one ?filter= condition; Field and Operator are filterFields and
+// filterOperators keys, and Value is bound as a parameter, never inlined
+type QueryPredicate struct {
+	Field    string
+	Operator string
+	Value    interface{} // string, or float64 in the column's unit for numeric fields
+}
+
+// parseFilterParam reads ?filter=field:operator:value, comma-separated or repeated.
+// The value may contain colons but not commas.
+func parseFilterParam(r *http.Request) ([]QueryPredicate, error) {
+	var predicates []QueryPredicate
+	for _, param := range r.URL.Query()["filter"] {
+		for _, entry := range strings.Split(param, ",") {
+			if entry == "" {
+				continue
+			}
+			field, rest, ok1 := strings.Cut(entry, ":")
+			operator, value, ok2 := strings.Cut(rest, ":")
+			if !ok1 || !ok2 || value == "" {
+				return nil, fmt.Errorf("invalid filter %q (must be field:operator:value)", entry)
+			}
+			if len(value) > maxFilterValueLength {
+				return nil, fmt.Errorf("invalid filter %q (value exceeds %d characters)", entry, maxFilterValueLength)
+			}
+
+			spec, ok := filterFields[field]
+			if !ok {
+				fields := make([]string, 0, len(filterFields))
+				for name := range filterFields {
+					fields = append(fields, name)
+				}
+				sort.Strings(fields)
+				return nil, fmt.Errorf("unknown filter field %q (must be one of %s)", field, strings.Join(fields, ", "))
+			}
+			allowed := textOperators
+			if spec.numeric {
+				allowed = numericOperators
+			}
+			if !slices.Contains(allowed, operator) {
+				return nil, fmt.Errorf("filter operator %q is not allowed for %s (must be one of %s)", operator, field, strings.Join(allowed, ", "))
+			}
+
+			predicate := QueryPredicate{Field: field, Operator: operator, Value: value}
+			switch {
+			case spec.numeric:
+				n, err := strconv.ParseFloat(value, 64)
+				if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+					return nil, fmt.Errorf("invalid filter %q (%s takes a number)", entry, field)
+				}
+				predicate.Value = n * spec.scale
+			case field == "error_code" && validErrorCode.MatchString(value):
+				// Codes are stored zero-padded, as with EXCLUDE_ERROR_CODES
+				predicate.Value = fmt.Sprintf("%06s", value)
+			}
+			predicates = append(predicates, predicate)
+		}
+	}
+	if len(predicates) > maxFilterPredicates {
+		return nil, fmt.Errorf("too many filters (at most %d)", maxFilterPredicates)
+	}
+	return predicates, nil
+}
+
+// orderByClause translates the sort options into ORDER BY, newest first on ties
+func orderByClause(opts QueryOptions) string {
+	column, ok := sortColumns[opts.SortBy]
+	if !ok {
+		column = "START_TIME"
+	}
+	direction := "DESC"
+	if opts.SortAscending {
+		direction = "ASC"
+	}
+
+	clause := column + " " + direction
+	if column != "START_TIME" {
+		clause += ", START_TIME DESC"
+	}
+	return clause
+}
+
+// buildFailedQueriesSQL builds the QUERY_HISTORY query and its bound arguments for the given options
+func buildFailedQueriesSQL(opts QueryOptions) (string, []interface{}) {
+	where, args := buildFailedQueriesWhere(opts)
+	selects := make([]string, 0, len(failedQueryColumns))
+	for _, c := range failedQueryColumns {
+		if unavailableHistoryColumns[c.source()] {
+			selects = append(selects, "NULL AS "+c.alias())
+			continue
+		}
+		if c.Field == "query_text" {
+			// LEFT counts characters, and a character is at least one byte, so one more
+			// than the byte cap is enough for capQueryText to tell the text was cut
+			selects = append(selects, "LEFT(QUERY_TEXT, "+strconv.Itoa(maxQueryTextBytes+1)+") AS QUERY_TEXT")
+			continue
+		}
+		selects = append(selects, c.SQL)
+	}
+
+	query := `
+		SELECT
+			` + strings.Join(selects, ",\n\t\t\t") + `
+		FROM ` + historySource.View + where
+
+	// The ORDER BY columns come from sortColumns, and RowLimit and Offset are
+	// validated integers, so appending them directly is safe
+	query += `
+		ORDER BY ` + orderByClause(opts) + `
+		LIMIT ` + strconv.Itoa(opts.RowLimit)
+	if opts.Offset > 0 {
+		query += " OFFSET " + strconv.Itoa(opts.Offset)
+	}
+
+	return query, args
+}
+
+// Layouts for binding absolute timestamps; the two must describe the same format
+const (
+	timestampBindLayout      = "2006-01-02 15:04:05.000000000 -07:00"
+	snowflakeTimestampFormat = "YYYY-MM-DD HH24:MI:SS.FF9 TZH:TZM"
+)
+
+// buildFailedQueriesWhere builds the WHERE clause shared by the list and aggregate queries
+func buildFailedQueriesWhere(opts QueryOptions) (string, []interface{}) {
+	var where string
+	var args []interface{}
+	if !opts.StartTime.IsZero() {
+		// Bound as explicit-offset strings so the session time zone can't shift the range
+		where = `
+		WHERE EXECUTION_STATUS = 'FAIL'
+			AND START_TIME BETWEEN TO_TIMESTAMP_TZ(?, '` + snowflakeTimestampFormat + `') AND TO_TIMESTAMP_TZ(?, '` + snowflakeTimestampFormat + `')`
+		args = []interface{}{
+			opts.StartTime.UTC().Format(timestampBindLayout),
+			opts.EndTime.UTC().Format(timestampBindLayout),
+		}
+	} else {
+		where = `
+		WHERE EXECUTION_STATUS = 'FAIL'
+			AND START_TIME >= DATEADD(hour, ?, CURRENT_TIMESTAMP())`
+		args = []interface{}{-opts.LookbackHours}
+	}
+
+	// Noise filters come from configuration, so bind them rather than inlining
+	for _, pattern := range opts.ExcludePatterns {
+		where += `
+			AND QUERY_TEXT NOT ILIKE ?`
+		args = append(args, pattern)
+	}
+
+	// NOT IN alone would also drop failures with no ERROR_CODE, since NULL NOT IN (...) isn't true
+	if len(opts.ExcludeErrorCodes) > 0 {
+		where += `
+			AND (ERROR_CODE IS NULL OR ERROR_CODE NOT IN (?` + strings.Repeat(", ?", len(opts.ExcludeErrorCodes)-1) + `))`
+		for _, code := range opts.ExcludeErrorCodes {
+			args = append(args, code)
+		}
+	}
+
+	// Optional filters are always bound as parameters, never concatenated
+	if opts.UserName != "" {
+		where += `
+			AND USER_NAME = ?`
+		args = append(args, opts.UserName)
+	}
+	if opts.UserPattern != "" {
+		if opts.ExcludeUsers {
+			where += `
+			AND USER_NAME NOT ILIKE ?`
+		} else {
+			where += `
+			AND USER_NAME ILIKE ?`
+		}
+		args = append(args, opts.UserPattern)
+	}
+	if opts.QueryType != "" {
+		where += `
+			AND QUERY_TYPE = ?`
+		args = append(args, opts.QueryType)
+	}
+	if opts.QueryID != "" {
+		where += `
+			AND QUERY_ID = ?`
+		args = append(args, opts.QueryID)
+	}
+	switch opts.WarehouseName {
+	case "":
+	case noWarehouse:
+		where += `
+			AND WAREHOUSE_NAME IS NULL`
+	default:
+		where += `
+			AND WAREHOUSE_NAME = ?`
+		args = append(args, opts.WarehouseName)
+	}
+	if opts.RoleName != "" {
+		where += `
+			AND ROLE_NAME = ?`
+		args = append(args, opts.RoleName)
+	}
+	if opts.DatabaseName != "" {
+		where += `
+			AND DATABASE_NAME = ?`
+		args = append(args, opts.DatabaseName)
+	}
+	if opts.AccountName != "" {
+		where += `
+			AND ACCOUNT_NAME = ?`
+		args = append(args, opts.AccountName)
+	}
+
+	// TOTAL_ELAPSED_TIME is recorded in milliseconds
+	if opts.MinDurationSeconds > 0 {
+		where += `
+			AND TOTAL_ELAPSED_TIME >= ?`
+		args = append(args, opts.MinDurationSeconds*1000)
+	}
+	if opts.MaxDurationSeconds > 0 {
+		where += `
+			AND TOTAL_ELAPSED_TIME <= ?`
+		args = append(args, opts.MaxDurationSeconds*1000)
+	}
+	if bucket, ok := findDurationBucket(opts.DurationBucket); ok {
+		if bucket.Min > 0 {
+			where += `
+			AND TOTAL_ELAPSED_TIME >= ?`
+			args = append(args, bucket.Min*1000)
+		}
+		if bucket.Max > 0 {
+			where += `
+			AND TOTAL_ELAPSED_TIME < ?`
+			args = append(args, bucket.Max*1000)
+		}
+	}
+
+	// Columns and operators come from the allowlists, never from the request
+	for _, p := range opts.Predicates {
+		where += `
+			AND ` + fmt.Sprintf(filterOperators[p.Operator], filterFields[p.Field].column)
+		args = append(args, p.Value)
+	}
+
+	return where, args
+}
+
+// RetryPolicy controls how transient Snowflake errors are retried
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+}
+
+// queryRetryPolicy is set from the config at startup
+var queryRetryPolicy = RetryPolicy{
+	MaxRetries: defaultQueryRetries,
+	BaseDelay:  defaultRetryBaseDelayMs * time.Millisecond,
+}
+
+// isRetryableError reports whether err looks transient (network blips, timeouts,
+// Snowflake service hiccups). SQL errors such as syntax or permission failures
+// are returned to the caller immediately.
+func isRetryableError(err error) bool {
+	// Our own deadline or a client disconnect: retrying can't help
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var sfErr *gosnowflake.SnowflakeError
+	if errors.As(err, &sfErr) {
+		switch sfErr.Number {
+		case gosnowflake.ErrCodeServiceUnavailable,
+			gosnowflake.ErrFailedToPostQuery,
+			gosnowflake.ErrFailedToRenewSession,
+			gosnowflake.ErrFailedToGetChunk:
+			return true
+		}
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// queryWithRetry runs db.QueryContext, retrying transient errors with exponential
+// backoff. Retries stop as soon as ctx is done.
+func queryWithRetry(ctx context.Context, db *sql.DB, query string, args ...interface{}) (*sql.Rows, error) {
+	delay := queryRetryPolicy.BaseDelay
+	for attempt := 0; ; attempt++ {
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err == nil {
+			return rows, nil
+		}
+		if attempt >= queryRetryPolicy.MaxRetries || !isRetryableError(err) {
+			return nil, err
+		}
+
+		requestLogger(ctx).Warn("Transient Snowflake error, retrying",
+			"attempt", attempt+1, "max_attempts", queryRetryPolicy.MaxRetries+1, "delay", delay, "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// queryTimeout bounds a single Snowflake query made on behalf of a request or poller.
+// It is set from QUERY_TIMEOUT_SECONDS at startup.
+var queryTimeout = defaultQueryTimeoutSeconds * time.Second
+
+// getFailedQueries runs the failed-query search. The query is cancelled when ctx
+// is, so an abandoned HTTP request stops its Snowflake query too. Callers set the
+// deadline, normally queryTimeout on top of r.Context().
+func getFailedQueries(ctx context.Context, db *sql.DB, opts QueryOptions) ([]FailedQuery, error) {
+	var queries []FailedQuery
+	err := streamFailedQueries(ctx, db, opts, func(q FailedQuery) error {
+		queries = append(queries, q)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return queries, nil
+}
+
+// streamFailedQueries runs the failed-query search like getFailedQueries, but hands
+// each row to fn as it is scanned instead of collecting them. An error from fn stops
+// the scan and is returned.
+func streamFailedQueries(ctx context.Context, db *sql.DB, opts QueryOptions, fn func(FailedQuery) error) (err error) {
+	ctx, span := tracer.Start(ctx, "getFailedQueries")
+	started := time.Now()
+	var count int
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("rows", count),
+			attribute.Int64("duration_ms", time.Since(started).Milliseconds()),
+		)
+		endSpan(span, err)
+	}()
+
+	query, args := buildFailedQueriesSQL(opts)
+
+	queryCtx, querySpan := tracer.Start(ctx, "snowflake.query", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "snowflake"),
+			attribute.Int("db.row_limit", opts.RowLimit),
+		))
+	rows, err := queryWithRetry(queryCtx, db, query, args...)
+	querySpan.SetAttributes(attribute.Int64("duration_ms", time.Since(started).Milliseconds()))
+	endSpan(querySpan, err)
+	if err != nil {
+		return markWarehouseUnavailable(fmt.Errorf("failed to query failed queries: %w", err))
+	}
+	defer rows.Close()
+
+	count, err = scanFailedQueries(ctx, rows, fn)
+	return markWarehouseUnavailable(err)
+}
+
+// errWarehouseUnavailable marks errors caused by the session's warehouse being
+// suspended or still resuming; with auto-resume they clear up on their own
+var errWarehouseUnavailable = errors.New("warehouse unavailable")
+
+// warehouseUnavailablePattern matches Snowflake's messages for a suspended or resuming warehouse
+var warehouseUnavailablePattern = regexp.MustCompile(`(?i)warehouse\b.*\b(is|was|being) (suspended|resuming|resumed)`)
+
+// markWarehouseUnavailable wraps err with errWarehouseUnavailable when Snowflake blamed the warehouse
+func markWarehouseUnavailable(err error) error {
+	var sfErr *gosnowflake.SnowflakeError
+	if errors.As(err, &sfErr) && warehouseUnavailablePattern.MatchString(sfErr.Message) {
+		return fmt.Errorf("%w: %w", errWarehouseUnavailable, err)
+	}
+	return err
+}
+
+// transientErrorMessage returns what to tell the client when err should clear up
+// within moments: the warehouse is resuming, or the query ran out of time, which is
+// usually the first query waiting on an auto-suspended warehouse to resume
+func transientErrorMessage(err error) (string, bool) {
+	switch {
+	case errors.Is(err, errWarehouseUnavailable):
+		return "Snowflake warehouse is resuming - retry shortly", true
+	case errors.Is(err, context.DeadlineExceeded):
+		return "Snowflake did not respond in time (the warehouse may be resuming) - retry shortly", true
+	default:
+		return "", false
+	}
+}
+
+// writeTransientError answers 503 with Retry-After when err is transient, reporting whether it did
+func writeTransientError(w http.ResponseWriter, r *http.Request, err error, retryAfter time.Duration) bool {
+	msg, ok := transientErrorMessage(err)
+	if !ok {
+		return false
+	}
+	requestLogger(r.Context()).Warn("Snowflake temporarily unavailable", "error", err)
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeJSONError(w, r, msg, http.StatusServiceUnavailable)
+	} else {
+		http.Error(w, msg, http.StatusServiceUnavailable)
+	}
+	return true
+}
+
+// accountUsageGrant is the statement that gives a role read access to ACCOUNT_USAGE
+const accountUsageGrant = "GRANT IMPORTED PRIVILEGES ON DATABASE SNOWFLAKE TO ROLE <role>;"
+
+// queryHistorySource is a QUERY_HISTORY view the failures can be read from (DATA_SOURCE)
+type queryHistorySource struct {
+	Name      string // DATA_SOURCE value
+	View      string // Fully qualified view name
+	Grant     string // Statement that gives the dashboard access
+	GrantedBy string // Who can run Grant
+}
+
+// The connected account's own history, or that of every account in the organization.
+// ORGANIZATION_USAGE is only readable with ORGADMIN.
+var (
+	accountUsageSource = queryHistorySource{
+		Name:      "account_usage",
+		View:      "SNOWFLAKE.ACCOUNT_USAGE.QUERY_HISTORY",
+		Grant:     accountUsageGrant,
+		GrantedBy: "An ACCOUNTADMIN",
+	}
+	organizationUsageSource = queryHistorySource{
+		Name:      "organization_usage",
+		View:      "SNOWFLAKE.ORGANIZATION_USAGE.QUERY_HISTORY",
+		Grant:     "GRANT ROLE ORGADMIN TO USER <user>;",
+		GrantedBy: "An organization administrator (ORGADMIN)",
+	}
+)
+
+// historySource is the view every QUERY_HISTORY query reads. It is set from DATA_SOURCE at startup.
+var historySource = accountUsageSource
+
+// organizationUsage reports whether failures come from every account in the organization
+func organizationUsage() bool {
+	return historySource.Name == organizationUsageSource.Name
+}
+
+// parseDataSource reads DATA_SOURCE, defaulting to account_usage
+func parseDataSource(value string) (queryHistorySource, error) {
+	switch strings.ToLower(value) {
+	case "", accountUsageSource.Name:
+		return accountUsageSource, nil
+	case organizationUsageSource.Name:
+		return organizationUsageSource, nil
+	}
+	return queryHistorySource{}, fmt.Errorf("invalid DATA_SOURCE: %q (must be %s or %s)", value, accountUsageSource.Name, organizationUsageSource.Name)
+}
+
+// isAccountUsageAccessError reports whether Snowflake refused the connecting role access
+// to the DATA_SOURCE view: the SNOWFLAKE database, schema, or view "does not exist or not
+// authorized" (002003), or "insufficient privileges" (003001). This is the most common
+// setup problem, so it gets an explanation rather than a generic error.
+func isAccountUsageAccessError(err error) bool {
+	var sfErr *gosnowflake.SnowflakeError
+	if !errors.As(err, &sfErr) {
+		return false
+	}
+	switch sfErr.Number {
+	case 2003:
+		return strings.Contains(strings.ToUpper(sfErr.Message), "SNOWFLAKE")
+	case 3001:
+		return true
+	}
+	return false
+}
+
+// writeAccessDeniedError answers 503 with the required grant when err is a
+// DATA_SOURCE access error, reporting whether it did
+func writeAccessDeniedError(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !isAccountUsageAccessError(err) {
+		return false
+	}
+	requestLogger(r.Context()).Error("No access to "+historySource.View+"; grant it with "+historySource.Grant, "error", err)
+	msg := "The dashboard's Snowflake role cannot read " + historySource.View + ". " + historySource.GrantedBy + " can grant it with: " + historySource.Grant
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeJSONError(w, r, msg, http.StatusServiceUnavailable)
+	} else {
+		http.Error(w, msg, http.StatusServiceUnavailable)
+	}
+	return true
+}
+
+// APIError is the body of every JSON API error response
+type APIError struct {
+	Error     string `json:"error"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeJSONError is http.Error for the JSON API: msg must already be safe to show
+// clients, and the request ID lets them quote the failure for the server-side log
+func writeJSONError(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	requestID, _ := r.Context().Value(requestIDKey{}).(string)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(APIError{Error: msg, RequestID: requestID}); err != nil {
+		requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+	}
+}
+
+// noErrorMessage stands in for a NULL ERROR_MESSAGE, e.g. on queries cancelled by an administrator
+const noErrorMessage = "(no error message)"
+
+// redactedText replaces each match of a redaction pattern
+const redactedText = "[REDACTED]"
+
+// redactPatterns hide sensitive literals in query text and error messages.
+// It is set from REDACT_PATTERNS at startup.
+var redactPatterns []*regexp.Regexp
+
+// redact replaces every match of redactPatterns in s
+func redact(s string) string {
+	for _, re := range redactPatterns {
+		s = re.ReplaceAllLiteralString(s, redactedText)
+	}
+	return s
+}
+
+// queryTextTruncatedMarker is appended to query text cut by capQueryText
+const queryTextTruncatedMarker = "… (truncated)"
+
+// maxQueryTextBytes bounds the query text kept per row, so one generated
+// multi-megabyte query can't bloat the cache or the browser.
+// It is set from MAX_QUERY_TEXT_BYTES at startup.
+var maxQueryTextBytes = defaultMaxQueryTextBytes
+
+// capQueryText cuts s to at most maxQueryTextBytes bytes, without splitting a
+// character, and marks it as truncated
+func capQueryText(s string) string {
+	if len(s) <= maxQueryTextBytes {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxQueryTextBytes], "") + queryTextTruncatedMarker
+}
+
+// redactQuery applies redact to the free-text fields of q. Call it after classifyError,
+// so categories still see the original message.
+func redactQuery(q *FailedQuery) {
+	q.QueryText = redact(q.QueryText)
+	q.ErrorMessage = redact(q.ErrorMessage)
+}
+
+// scanFailedQueries reads every row of a failedQueryColumns result set, passing each
+// to fn, and returns how many rows it read
+func scanFailedQueries(ctx context.Context, rows *sql.Rows, fn func(FailedQuery) error) (count int, err error) {
+	_, span := tracer.Start(ctx, "scan rows")
+	scanStarted := time.Now()
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("rows", count),
+			attribute.Int64("duration_ms", time.Since(scanStarted).Milliseconds()),
+		)
+		endSpan(span, err)
+	}()
+
+	dests := make([]interface{}, len(failedQueryColumns))
+	assigns := make([]func(), len(failedQueryColumns))
+	for rows.Next() {
+		var q FailedQuery
+		for i, c := range failedQueryColumns {
+			dests[i], assigns[i] = c.scan(&q)
+		}
+		if err := rows.Scan(dests...); err != nil {
+			return count, fmt.Errorf("failed to scan row: %w", err)
+		}
+		for _, assign := range assigns {
+			assign()
+		}
+		if q.ErrorMessage == "" {
+			q.ErrorMessage = noErrorMessage
+		}
+		q.Category = classifyError(q.ErrorMessage, q.ErrorCode)
+		q.DurationBucket = classifyDuration(q.ExecutionTime)
+		redactQuery(&q)
+		q.QueryText = capQueryText(q.QueryText)
+		count++
+		if err := fn(q); err != nil {
+			return count, err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return count, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return count, nil
+}
+
+// queryCacheEntry holds a cached result set and when it expires
+type queryCacheEntry struct {
+	queries []FailedQuery
+	expires time.Time
+}
+
+// QueryCache caches getFailedQueries results per set of query options.
+// Concurrent misses for the same options share a single Snowflake query.
+type QueryCache struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]queryCacheEntry
+	group   singleflight.Group
+}
+
+func newQueryCache(db *sql.DB, ttl time.Duration) *QueryCache {
+	return &QueryCache{
+		db:      db,
+		ttl:     ttl,
+		entries: make(map[string]queryCacheEntry),
+	}
+}
+
+// Enabled reports whether results are cached at all
+func (c *QueryCache) Enabled() bool {
+	return c.ttl > 0
+}
+
+// Get returns failed queries for opts and whether they were served from cache.
+// The returned slice is shared between callers and must not be modified.
+func (c *QueryCache) Get(ctx context.Context, opts QueryOptions) ([]FailedQuery, bool, error) {
+	if !c.Enabled() {
+		queries, err := getFailedQueries(ctx, c.db, opts)
+		return queries, false, err
+	}
+
+	key := fmt.Sprintf("%#v", opts)
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.queries, true, nil
+	}
+
+	// Single-flight: only one request per key queries Snowflake on a miss.
+	// The shared query may have other waiters, so it isn't cancelled with ctx;
+	// a caller that goes away just stops waiting and the result still fills the cache.
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		sharedCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), queryTimeout)
+		defer cancel()
+
+		queries, err := getFailedQueries(sharedCtx, c.db, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		c.mu.Lock()
+		// Drop expired entries so the map doesn't grow with stale filter combinations
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.entries[key] = queryCacheEntry{queries: queries, expires: now.Add(c.ttl)}
+		c.mu.Unlock()
+
+		return queries, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, false, res.Err
+		}
+		return res.Val.([]FailedQuery), false, nil
+	}
+}
+
+// setCacheHeader reports cache usage to clients via X-Cache when caching is enabled
+func setCacheHeader(w http.ResponseWriter, cache *QueryCache, hit bool) {
+	if !cache.Enabled() {
+		return
+	}
+	if hit {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+	}
+}
+
+// querySnapshot is the latest default-window result for one account. One background
+// goroutine refreshes it every REFRESH_INTERVAL_SECONDS and handlers only read it, so page
+// loads, refreshes, and open tabs no longer add Snowflake queries.
+type querySnapshot struct {
+	opts  QueryOptions
+	ready chan struct{} // Closed once the first refresh has finished
+
+	mu      sync.RWMutex
+	queries []FailedQuery
+	err     error     // Set only while no refresh has succeeded yet
+	updated time.Time // When queries were fetched
+
+	group singleflight.Group // Background and forced refreshes share one query
+}
+
+func newQuerySnapshot(opts QueryOptions) *querySnapshot {
+	return &querySnapshot{opts: opts, ready: make(chan struct{})}
+}
+
+// Covers reports whether opts asks for exactly the snapshot's window and filters
+func (s *querySnapshot) Covers(opts QueryOptions) bool {
+	return reflect.DeepEqual(opts, s.opts)
+}
+
+// Get waits for the first refresh, then returns the latest result.
+// The returned slice is shared between callers and must not be modified.
+func (s *querySnapshot) Get(ctx context.Context) ([]FailedQuery, error) {
+	select {
+	case <-s.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.queries, s.err
+}
+
+// LastSuccess returns when the snapshot was last fetched, or the zero time before the first success
+func (s *querySnapshot) LastSuccess() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.updated
+}
+
+// store replaces the snapshot after a successful query
+func (s *querySnapshot) store(queries []FailedQuery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries, s.err, s.updated = queries, nil, time.Now()
+}
+
+// refresh queries Snowflake once, joining a refresh already in flight. A failure keeps
+// the previous result, which is better than an error page; it is only reported until
+// something has been fetched.
+func (s *querySnapshot) refresh(account string, db *sql.DB) error {
+	_, err, _ := s.group.Do("refresh", func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		defer cancel()
+
+		queries, err := getFailedQueries(ctx, db, s.opts)
+		if err == nil {
+			s.store(queries)
+			return nil, nil
+		}
+
+		log.Printf("Background refresh failed for account %s: %v", account, err)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.updated.IsZero() {
+			s.err = err
+		}
+		return nil, err
+	})
+	return err
+}
+
+// minForcedRefreshAge is how old the snapshot must be before POST /api/refresh queries
+// Snowflake again; younger snapshots are returned as they are
+const minForcedRefreshAge = 5 * time.Second
+
+// ForceRefresh refreshes the snapshot now for POST /api/refresh and returns the result.
+// Concurrent callers and the background refresher share one query, and a snapshot
+// fetched within minForcedRefreshAge is reused, so the endpoint can't hammer Snowflake.
+func (s *querySnapshot) ForceRefresh(ctx context.Context, account string, db *sql.DB) ([]FailedQuery, time.Time, error) {
+	if time.Since(s.LastSuccess()) >= minForcedRefreshAge {
+		// The shared query isn't tied to ctx; a caller that goes away just stops waiting
+		done := make(chan error, 1)
+		go func() { done <- s.refresh(account, db) }()
+		select {
+		case <-ctx.Done():
+			return nil, time.Time{}, ctx.Err()
+		case err := <-done:
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.queries, s.updated, nil
+}
+
+// RefreshResult is the POST /api/refresh response
+type RefreshResult struct {
+	Count     int       `json:"count"`      // Failed queries in the default window
+	UpdatedAt time.Time `json:"updated_at"` // When they were fetched from Snowflake
+}
+
+// run refreshes the snapshot immediately and then every interval
+func (s *querySnapshot) run(account string, db *sql.DB, interval time.Duration) {
+	s.refresh(account, db)
+	close(s.ready)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.refresh(account, db)
+	}
+}
+
+// accountConn is the connection pool and result cache for one configured account
+type accountConn struct {
+	name      string
+	db        *sql.DB
+	cache     *QueryCache
+	snapshot  *querySnapshot
+	facets    *facetCache
+	freshness *freshnessCache
+	stream    *queryStream
+	uiBaseURL string // Snowsight address for query profile links
+}
+
+// failedQueries serves opts from the background snapshot when it covers them and from
+// the cache otherwise. fresh (?fresh=true) queries Snowflake on demand instead, updating
+// the snapshot when it covers opts. hit reports whether no query was run for this call.
+func (a *accountConn) failedQueries(ctx context.Context, opts QueryOptions, fresh bool) ([]FailedQuery, bool, error) {
+	if !fresh && a.snapshot.Covers(opts) {
+		queries, err := a.snapshot.Get(ctx)
+		return queries, true, err
+	}
+	if !fresh {
+		return a.cache.Get(ctx, opts)
+	}
+
+	queries, err := getFailedQueries(ctx, a.db, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	if a.snapshot.Covers(opts) {
+		a.snapshot.store(queries)
+	}
+	return queries, false, nil
+}
+
+// accountSet holds the connected accounts in config order; the first is the default
+type accountSet []*accountConn
+
+// fromRequest returns the account selected by the ?account= parameter
+func (a accountSet) fromRequest(r *http.Request) (*accountConn, error) {
+	name := r.URL.Query().Get("account")
+	if name == "" {
+		return a[0], nil
+	}
+	for _, account := range a {
+		if account.name == name {
+			return account, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown account (must be one of the configured account names)")
+}
+
+// names lists the configured account names in order
+func (a accountSet) names() []string {
+	names := make([]string, 0, len(a))
+	for _, account := range a {
+		names = append(names, account.name)
+	}
+	return names
+}
+
+// inDisplayLocation returns a copy of queries with StartTime and EndTime converted to loc.
+// Cached slices are shared, so the input is never modified.
+func inDisplayLocation(queries []FailedQuery, loc *time.Location) []FailedQuery {
+	if loc == nil {
+		return queries
+	}
+	converted := make([]FailedQuery, len(queries))
+	for i, q := range queries {
+		q.StartTime = q.StartTime.In(loc)
+		q.EndTime = q.EndTime.In(loc)
+		converted[i] = q
+	}
+	return converted
+}
+
+// displayTimezone is the IANA name passed to the frontend ("" when unset)
+func displayTimezone(loc *time.Location) string {
+	if loc == nil {
+		return ""
+	}
+	return loc.String()
+}
+
+// getQueryByID looks up a single failed query within the configured window.
+// It returns nil (and no error) when the ID isn't found.
+func getQueryByID(ctx context.Context, db *sql.DB, opts QueryOptions, queryID string) (*FailedQuery, error) {
+	opts.QueryID = queryID
+	opts.RowLimit = 1
+	opts.Offset = 0
+
+	queries, err := getFailedQueries(ctx, db, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(queries) == 0 {
+		return nil, nil
+	}
+	return &queries[0], nil
+}
+
+// QueryMetrics is the execution detail QUERY_HISTORY records beyond the failure
+// itself. The detail page fetches it separately so the list stays one query.
+// Times are in milliseconds; zero means Snowflake recorded no value.
+type QueryMetrics struct {
+	WarehouseSize string `json:"warehouse_size"`
+
+	CompilationMs        int64 `json:"compilation_ms"`
+	ExecutionMs          int64 `json:"execution_ms"`
+	QueuedProvisioningMs int64 `json:"queued_provisioning_ms"`
+	QueuedRepairMs       int64 `json:"queued_repair_ms"`
+	QueuedOverloadMs     int64 `json:"queued_overload_ms"`
+
+	PartitionsScanned int64 `json:"partitions_scanned"`
+	PartitionsTotal   int64 `json:"partitions_total"`
+
+	BytesSpilledToLocalStorage  int64 `json:"bytes_spilled_to_local_storage"`
+	BytesSpilledToRemoteStorage int64 `json:"bytes_spilled_to_remote_storage"`
+	RowsProduced                int64 `json:"rows_produced"`
+}
+
+// queryMetricsColumns are the columns getQueryMetrics selects, in QueryMetrics order.
+// Ones in unavailableHistoryColumns are selected as NULL and reported as zero.
+var queryMetricsColumns = []string{
+	"WAREHOUSE_SIZE",
+	"COMPILATION_TIME",
+	"EXECUTION_TIME",
+	"QUEUED_PROVISIONING_TIME",
+	"QUEUED_REPAIR_TIME",
+	"QUEUED_OVERLOAD_TIME",
+	"PARTITIONS_SCANNED",
+	"PARTITIONS_TOTAL",
+	"BYTES_SPILLED_TO_LOCAL_STORAGE",
+	"BYTES_SPILLED_TO_REMOTE_STORAGE",
+	"ROWS_PRODUCED",
+}
+
+// getQueryMetrics looks up the execution metrics of a single failed query within the
+// window of opts. It returns nil (and no error) when the ID isn't found.
+func getQueryMetrics(ctx context.Context, db *sql.DB, opts QueryOptions, queryID string) (*QueryMetrics, error) {
+	opts.QueryID = queryID
+	where, args := buildFailedQueriesWhere(opts)
+	selects := make([]string, len(queryMetricsColumns))
+	for i, column := range queryMetricsColumns {
+		selects[i] = column
+		if unavailableHistoryColumns[column] {
+			selects[i] = "NULL AS " + column
+		}
+	}
+	query := `
+		SELECT
+			` + strings.Join(selects, ",\n\t\t\t") + `
+		FROM ` + historySource.View + where + `
+		LIMIT 1`
+
+	rows, err := queryWithRetry(ctx, db, query, args...)
+	if err != nil {
+		return nil, markWarehouseUnavailable(fmt.Errorf("failed to query metrics: %w", err))
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating metrics rows: %w", err)
+		}
+		return nil, nil
+	}
+	var (
+		size    sql.NullString
+		metrics [10]sql.NullInt64
+	)
+	dests := []interface{}{&size}
+	for i := range metrics {
+		dests = append(dests, &metrics[i])
+	}
+	if err := rows.Scan(dests...); err != nil {
+		return nil, fmt.Errorf("failed to scan metrics: %w", err)
+	}
+
+	return &QueryMetrics{
+		WarehouseSize:               size.String,
+		CompilationMs:               metrics[0].Int64,
+		ExecutionMs:                 metrics[1].Int64,
+		QueuedProvisioningMs:        metrics[2].Int64,
+		QueuedRepairMs:              metrics[3].Int64,
+		QueuedOverloadMs:            metrics[4].Int64,
+		PartitionsScanned:           metrics[5].Int64,
+		PartitionsTotal:             metrics[6].Int64,
+		BytesSpilledToLocalStorage:  metrics[7].Int64,
+		BytesSpilledToRemoteStorage: metrics[8].Int64,
+		RowsProduced:                metrics[9].Int64,
+	}, nil
+}
+
+// maxAlertQueries caps how many failures are listed in one Slack or Teams message
+const maxAlertQueries = 10
+
+// maxPendingAlerts caps how many undelivered failures are kept for retry per notifier
+const maxPendingAlerts = 1000
+
+// Notifier delivers batches of new failed queries to one alert sink.
+// pollForNewFailures does the diffing and retries, so implementations only format and send.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, account string, queries []FailedQuery) error
+}
+
+// notifierTimeout bounds one delivery attempt to a sink
+const notifierTimeout = 30 * time.Second
+
+// notifierSink is one entry in the registry: configured reports whether the sink's
+// settings are present, and build creates it from them
+type notifierSink struct {
+	name       string
+	configured func(config *Config) bool
+	build      func(config *Config) Notifier
+}
+
+// notifierSinks registers every alert integration; adding one means adding an entry here
+var notifierSinks = []notifierSink{
+	{
+		name:       "slack",
+		configured: func(config *Config) bool { return config.SlackWebhookURL != "" },
+		build:      func(config *Config) Notifier { return newSlackAlerter(config.SlackWebhookURL) },
+	},
+	{
+		name:       "teams",
+		configured: func(config *Config) bool { return config.TeamsWebhookURL != "" },
+		build:      func(config *Config) Notifier { return newTeamsNotifier(config.TeamsWebhookURL, config.DashboardURL) },
+	},
+	{
+		name:       "webhook",
+		configured: func(config *Config) bool { return config.AlertWebhookURL != "" },
+		build: func(config *Config) Notifier {
+			return newWebhookNotifier(config.AlertWebhookURL, config.QueryTextPreviewChars)
+		},
+	},
+}
+
+// checkNotifierNames verifies that every sink named in NOTIFIERS exists and is configured
+func checkNotifierNames(config *Config) error {
+	for _, name := range config.Notifiers {
+		found := false
+		for _, sink := range notifierSinks {
+			if sink.name != strings.ToLower(name) {
+				continue
+			}
+			found = true
+			if !sink.configured(config) {
+				return fmt.Errorf("NOTIFIERS includes %s, but its settings are missing", sink.name)
+			}
+		}
+		if !found {
+			return fmt.Errorf("NOTIFIERS: unknown notifier %q (must be slack, teams, or webhook)", name)
+		}
+	}
+	return nil
+}
+
+// enabledNotifiers builds the sinks named in NOTIFIERS, or every configured sink when it is unset
+func enabledNotifiers(config *Config) []Notifier {
+	var notifiers []Notifier
+	for _, sink := range notifierSinks {
+		if !sink.configured(config) {
+			continue
+		}
+		if len(config.Notifiers) > 0 && !containsFold(config.Notifiers, sink.name) {
+			continue
+		}
+		notifiers = append(notifiers, sink.build(config))
+	}
+	return notifiers
+}
+
+// containsFold reports whether names includes name, ignoring case
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// postJSON posts payload to a webhook. Errors leave out the URL, which usually carries a token.
+func postJSON(ctx context.Context, client *http.Client, webhookURL string, payload []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, errors.New("invalid webhook URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// slackAlerter posts batches of new failed queries to a Slack incoming webhook
+type slackAlerter struct {
+	webhookURL string
+	client     *http.Client
+}
+
+func newSlackAlerter(webhookURL string) *slackAlerter {
+	return &slackAlerter{
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// slackEscape escapes the characters Slack treats as markup
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// formatSlackAlert builds one message listing new failures for an account
+func formatSlackAlert(account string, queries []FailedQuery) string {
+	var b strings.Builder
+	noun := "query"
+	if len(queries) != 1 {
+		noun = "queries"
+	}
+	fmt.Fprintf(&b, ":rotating_light: *%d new failed %s* in Snowflake account `%s`\n", len(queries), noun, slackEscape(account))
+
+	for i, q := range queries {
+		if i == maxAlertQueries {
+			fmt.Fprintf(&b, "…and %d more\n", len(queries)-maxAlertQueries)
+			break
+		}
+		message := q.ErrorMessage
+		if runes := []rune(message); len(runes) > 200 {
+			message = string(runes[:200]) + "…"
+		}
+		if q.ErrorCode != "" {
+			message = q.ErrorCode + ": " + message
+		}
+		fmt.Fprintf(&b, "• `%s` — %s — %s\n", slackEscape(q.QueryID), slackEscape(q.UserName), slackEscape(message))
+	}
+
+	return b.String()
+}
+
+func (s *slackAlerter) Name() string { return "Slack" }
+
+// Notify sends new failures for an account as a single Slack message
+func (s *slackAlerter) Notify(ctx context.Context, account string, queries []FailedQuery) error {
+	payload, err := json.Marshal(map[string]string{"text": formatSlackAlert(account, queries)})
+	if err != nil {
+		return fmt.Errorf("failed to encode Slack message: %w", err)
+	}
+
+	resp, err := postJSON(ctx, s.client, s.webhookURL, payload)
+	if err != nil {
+		return fmt.Errorf("failed to post to Slack: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("slack webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// teamsNotifier posts batches of new failed queries to a Microsoft Teams
+// incoming webhook (or a Workflows webhook) as an Adaptive Card
+type teamsNotifier struct {
+	webhookURL   string
+	dashboardURL string // Base URL for per-query links; "" omits them
+	client       *http.Client
+}
+
+func newTeamsNotifier(webhookURL, dashboardURL string) *teamsNotifier {
+	return &teamsNotifier{
+		webhookURL:   webhookURL,
+		dashboardURL: dashboardURL,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (t *teamsNotifier) Name() string { return "Teams" }
+
+// teamsEscape keeps error text from being read as Adaptive Card markdown
+func teamsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "`", "'").Replace(s)
+}
+
+// queryDetailURL links to the dashboard's detail page for one query
+func queryDetailURL(dashboardURL, account, queryID string) string {
+	return dashboardURL + "/query/" + url.PathEscape(queryID) + "?account=" + url.QueryEscape(account)
+}
+
+// formatTeamsCard builds the webhook payload: one Adaptive Card listing new failures
+func formatTeamsCard(account string, queries []FailedQuery, dashboardURL string) map[string]interface{} {
+	noun := "query"
+	if len(queries) != 1 {
+		noun = "queries"
+	}
+	body := []map[string]interface{}{{
+		"type":   "TextBlock",
+		"size":   "Medium",
+		"weight": "Bolder",
+		"color":  "Attention",
+		"wrap":   true,
+		"text":   fmt.Sprintf("%d new failed %s in Snowflake account %s", len(queries), noun, teamsEscape(account)),
+	}}
+
+	for i, q := range queries {
+		if i == maxAlertQueries {
+			body = append(body, map[string]interface{}{
+				"type": "TextBlock",
+				"wrap": true,
+				"text": fmt.Sprintf("…and %d more", len(queries)-maxAlertQueries),
+			})
+			break
+		}
+		message := q.ErrorMessage
+		if runes := []rune(message); len(runes) > 200 {
+			message = string(runes[:200]) + "…"
+		}
+		if q.ErrorCode != "" {
+			message = q.ErrorCode + ": " + message
+		}
+		id := teamsEscape(q.QueryID)
+		if dashboardURL != "" {
+			id = "[" + id + "](" + queryDetailURL(dashboardURL, account, q.QueryID) + ")"
+		}
+		body = append(body, map[string]interface{}{
+			"type":      "TextBlock",
+			"wrap":      true,
+			"separator": true,
+			"text":      fmt.Sprintf("%s — **%s** — %s", id, teamsEscape(q.UserName), teamsEscape(message)),
+		})
+	}
+
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    body,
+			},
+		}},
+	}
+}
+
+// Notify sends new failures for an account as a single Teams message
+func (t *teamsNotifier) Notify(ctx context.Context, account string, queries []FailedQuery) error {
+	payload, err := json.Marshal(formatTeamsCard(account, queries, t.dashboardURL))
+	if err != nil {
+		return fmt.Errorf("failed to encode Teams message: %w", err)
+	}
+
+	resp, err := postJSON(ctx, t.client, t.webhookURL, payload)
+	if err != nil {
+		return fmt.Errorf("failed to post to Teams: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("teams webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// WebhookAlert is the JSON body the generic webhook notifier posts
+type WebhookAlert struct {
+	Account string        `json:"account"`
+	Count   int           `json:"count"`
+	Queries []FailedQuery `json:"queries"` // Query text is cut to QUERY_TEXT_PREVIEW_CHARS
+}
+
+// webhookNotifier posts batches of new failed queries as JSON to any HTTP endpoint,
+// for receivers without a dedicated integration (incident tools, automation, ...)
+type webhookNotifier struct {
+	webhookURL   string
+	previewChars int
+	client       *http.Client
+}
+
+func newWebhookNotifier(webhookURL string, previewChars int) *webhookNotifier {
+	return &webhookNotifier{
+		webhookURL:   webhookURL,
+		previewChars: previewChars,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (h *webhookNotifier) Name() string { return "Webhook" }
+
+// Notify posts new failures for an account as one WebhookAlert
+func (h *webhookNotifier) Notify(ctx context.Context, account string, queries []FailedQuery) error {
+	payload, err := json.Marshal(WebhookAlert{
+		Account: account,
+		Count:   len(queries),
+		Queries: withQueryTextPreview(queries, h.previewChars),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook alert: %w", err)
+	}
+
+	resp, err := postJSON(ctx, h.client, h.webhookURL, payload)
+	if err != nil {
+		return fmt.Errorf("failed to post to webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// pagerDutyEventsURL is the PagerDuty Events API v2 endpoint
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutyAlerter opens and resolves one PagerDuty incident per account
+// when the number of new failures in a poll crosses threshold
+type pagerDutyAlerter struct {
+	routingKey string
+	threshold  int
+	client     *http.Client
+}
+
+func newPagerDutyAlerter(routingKey string, threshold int) *pagerDutyAlerter {
+	return &pagerDutyAlerter{
+		routingKey: routingKey,
+		threshold:  threshold,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// pagerDutyEvent is the Events API v2 request body
+type pagerDutyEvent struct {
+	RoutingKey  string                 `json:"routing_key"`
+	EventAction string                 `json:"event_action"` // trigger or resolve
+	DedupKey    string                 `json:"dedup_key"`
+	Payload     *pagerDutyEventPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyEventPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+// pagerDutyDedupKey is stable per account so repeated triggers update the same incident
+func pagerDutyDedupKey(account string) string {
+	return "snowflake-failed-queries/" + account
+}
+
+// Trigger opens (or updates) the account's incident for a burst of new failures
+func (p *pagerDutyAlerter) Trigger(account string, queries []FailedQuery) error {
+	sample := make([]string, 0, maxAlertQueries)
+	for i, q := range queries {
+		if i == maxAlertQueries {
+			break
+		}
+		sample = append(sample, q.QueryID+" ("+q.UserName+", "+q.Category+")")
+	}
+
+	return p.send(pagerDutyEvent{
+		EventAction: "trigger",
+		DedupKey:    pagerDutyDedupKey(account),
+		Payload: &pagerDutyEventPayload{
+			Summary:  fmt.Sprintf("%d new failed queries in Snowflake account %s (threshold %d)", len(queries), account, p.threshold),
+			Source:   "snowflake-failed-queries-dashboard/" + account,
+			Severity: "critical",
+			CustomDetails: map[string]interface{}{
+				"account":        account,
+				"new_failures":   len(queries),
+				"threshold":      p.threshold,
+				"sample_queries": sample,
+			},
+		},
+	})
+}
+
+// Resolve closes the account's incident once failures are back under the threshold
+func (p *pagerDutyAlerter) Resolve(account string) error {
+	return p.send(pagerDutyEvent{
+		EventAction: "resolve",
+		DedupKey:    pagerDutyDedupKey(account),
+	})
+}
+
+func (p *pagerDutyAlerter) send(event pagerDutyEvent) error {
+	event.RoutingKey = p.routingKey
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode PagerDuty event: %w", err)
+	}
+
+	resp, err := p.client.Post(pagerDutyEventsURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to send PagerDuty event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("PagerDuty returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Test opens an informational test incident and resolves it straight away, under
+// its own dedup key so a real incident for the account is left alone
+func (p *pagerDutyAlerter) Test(account string) error {
+	dedupKey := pagerDutyDedupKey(account) + "/test"
+	err := p.send(pagerDutyEvent{
+		EventAction: "trigger",
+		DedupKey:    dedupKey,
+		Payload: &pagerDutyEventPayload{
+			Summary:  "TEST: notification check from the Snowflake failed queries dashboard for account " + account + " (no query failed)",
+			Source:   "snowflake-failed-queries-dashboard/" + account,
+			Severity: "info",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return p.send(pagerDutyEvent{EventAction: "resolve", DedupKey: dedupKey})
+}
+
+// testNotificationQueryID marks the synthetic failure sent by POST /api/test-notification
+const testNotificationQueryID = "TEST-NOTIFICATION"
+
+// testNotificationQuery is the synthetic failure sent to each notifier by
+// POST /api/test-notification; every free-text field says it is a test
+func testNotificationQuery() FailedQuery {
+	now := time.Now().UTC()
+	return FailedQuery{
+		QueryID:        testNotificationQueryID,
+		QueryText:      "-- TEST: sent by POST /api/test-notification, no query failed\nSELECT 1",
+		UserName:       "DASHBOARD_TEST",
+		QueryType:      "SELECT",
+		ErrorMessage:   "TEST: this is a test notification from the Snowflake failed queries dashboard. No query failed.",
+		StartTime:      now,
+		EndTime:        now,
+		Category:       otherErrorCategory,
+		DurationBucket: classifyDuration(0),
+	}
+}
+
+// TestNotificationResult reports one sink's delivery of a test notification
+type TestNotificationResult struct {
+	Sink  string `json:"sink"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"` // Why delivery failed; never includes the webhook URL
+}
+
+// sendTestNotifications sends a test message through every notifier, and a test
+// incident through PagerDuty when pager is set, reporting each sink's outcome
+func sendTestNotifications(ctx context.Context, account string, notifiers []Notifier, pager *pagerDutyAlerter) []TestNotificationResult {
+	results := []TestNotificationResult{}
+	record := func(sink string, err error) {
+		result := TestNotificationResult{Sink: sink, OK: err == nil}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	for _, n := range notifiers {
+		sendCtx, cancel := context.WithTimeout(ctx, notifierTimeout)
+		record(n.Name(), n.Notify(sendCtx, account+" [TEST]", []FailedQuery{testNotificationQuery()}))
+		cancel()
+	}
+	if pager != nil {
+		record("PagerDuty", pager.Test(account))
+	}
+	return results
+}
+
+// pollForNewFailures periodically fetches failed queries for an account and
+// alerts on query IDs it hasn't seen before: each batch goes to every notifier,
+// and PagerDuty is paged while a poll's batch exceeds the alert threshold
+// (pager may be nil). The first poll only records the current failures so a
+// restart doesn't re-alert on the whole window.
+func pollForNewFailures(account *accountConn, opts QueryOptions, interval time.Duration, notifiers []Notifier, pager *pagerDutyAlerter) {
+	var seen map[string]bool
+	paging := false
+	// Batches a notifier failed to deliver, retried with the next poll's batch
+	pending := make([][]FailedQuery, len(notifiers))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		queries, err := getFailedQueries(ctx, account.db, opts)
+		cancel()
+		if err != nil {
+			log.Printf("Alert poll failed for account %s: %v", account.name, err)
+			continue
+		}
+
+		current := make(map[string]bool, len(queries))
+		var newQueries []FailedQuery
+		for _, q := range queries {
+			current[q.QueryID] = true
+			if seen != nil && !seen[q.QueryID] {
+				newQueries = append(newQueries, q)
+			}
+		}
+
+		if pager != nil && seen != nil {
+			switch {
+			case len(newQueries) > pager.threshold && !paging:
+				if err := pager.Trigger(account.name, newQueries); err != nil {
+					log.Printf("PagerDuty trigger failed for account %s: %v", account.name, err)
+				} else {
+					paging = true
+					log.Printf("Triggered PagerDuty incident for %d new failed queries in account %s", len(newQueries), account.name)
+				}
+			case len(newQueries) <= pager.threshold && paging:
+				if err := pager.Resolve(account.name); err != nil {
+					log.Printf("PagerDuty resolve failed for account %s: %v", account.name, err)
+				} else {
+					paging = false
+					log.Printf("Resolved PagerDuty incident for account %s", account.name)
+				}
+			}
+		}
+
+		for i, n := range notifiers {
+			batch := append(pending[i], newQueries...)
+			if len(batch) == 0 {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), notifierTimeout)
+			err := n.Notify(ctx, account.name, batch)
+			cancel()
+			if err != nil {
+				log.Printf("%s alert failed for account %s: %v", n.Name(), account.name, err)
+				if len(batch) > maxPendingAlerts {
+					batch = batch[len(batch)-maxPendingAlerts:]
+				}
+				pending[i] = batch
+				continue
+			}
+			pending[i] = nil
+			log.Printf("Sent %s alert for %d new failed queries in account %s", n.Name(), len(batch), account.name)
+		}
+
+		// Only IDs still inside the window can reappear, so older ones are dropped
+		seen = current
+	}
+}
+
+// HistoryStore keeps a durable local record of failed queries in SQLite,
+// since ACCOUNT_USAGE data lags and eventually rolls off
+type HistoryStore struct {
+	db *sql.DB
+}
+
+const historySchema = `
+	CREATE TABLE IF NOT EXISTS failed_queries (
+		account                     TEXT    NOT NULL,
+		query_id                    TEXT    NOT NULL,
+		query_text                  TEXT    NOT NULL,
+		user_name                   TEXT    NOT NULL,
+		warehouse_name              TEXT    NOT NULL,
+		database_name               TEXT    NOT NULL,
+		schema_name                 TEXT    NOT NULL,
+		query_type                  TEXT    NOT NULL,
+		error_code                  TEXT    NOT NULL,
+		error_message               TEXT    NOT NULL,
+		start_time                  INTEGER NOT NULL, -- Unix milliseconds
+		end_time                    INTEGER NOT NULL, -- Unix milliseconds
+		execution_time_seconds      REAL    NOT NULL,
+		bytes_scanned               INTEGER NOT NULL,
+		credits_used_cloud_services REAL    NOT NULL,
+		account_name                TEXT    NOT NULL DEFAULT '', -- Organization account (DATA_SOURCE=organization_usage)
+		role_name                   TEXT    NOT NULL DEFAULT '',
+		PRIMARY KEY (account, query_id)
+	);
+	CREATE INDEX IF NOT EXISTS failed_queries_start_time ON failed_queries (account, start_time);`
+
+// historyAddedColumns are failed_queries columns added after the table first shipped,
+// with their definitions; openHistoryStore adds any an existing database lacks
+var historyAddedColumns = []struct{ name, definition string }{
+	{"account_name", "TEXT NOT NULL DEFAULT ''"},
+	{"role_name", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// openSQLite opens (creating if needed) the SQLite database at path and applies schema
+func openSQLite(path, schema string) (*sql.DB, error) {
+	// WAL lets handlers read while background writers write; busy_timeout waits out brief locks
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema in %s: %w", path, err)
+	}
+
+	return db, nil
+}
+
+// openHistoryStore opens (creating if needed) the history database at path
+func openHistoryStore(path string) (*HistoryStore, error) {
+	db, err := openSQLite(path, historySchema)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateHistory(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate %s: %w", path, err)
+	}
+	return &HistoryStore{db: db}, nil
+}
+
+// migrateHistory adds the historyAddedColumns a database created by an older version lacks.
+// CREATE TABLE IF NOT EXISTS leaves an existing table as it was.
+func migrateHistory(db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `SELECT name FROM pragma_table_info('failed_queries')`)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// The names and definitions are constants, so building the statement is safe
+	for _, c := range historyAddedColumns {
+		if existing[c.name] {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "ALTER TABLE failed_queries ADD COLUMN "+c.name+" "+c.definition); err != nil {
+			return fmt.Errorf("adding column %s: %w", c.name, err)
+		}
+		log.Printf("Added column %s to the history database", c.name)
+	}
+	return nil
+}
+
+func (h *HistoryStore) Close() error {
+	return h.db.Close()
+}
+
+// Save records queries for an account, skipping query IDs already stored.
+// It returns how many rows were new.
+func (h *HistoryStore) Save(account string, queries []FailedQuery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin history transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT OR IGNORE INTO failed_queries (
+			account, query_id, query_text, user_name, warehouse_name, database_name, schema_name,
+			query_type, error_code, error_message, start_time, end_time,
+			execution_time_seconds, bytes_scanned, credits_used_cloud_services, account_name, role_name
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare history insert: %w", err)
+	}
+	defer stmt.Close()
+
+	inserted := 0
+	for _, q := range queries {
+		res, err := stmt.ExecContext(ctx,
+			account, q.QueryID, q.QueryText, q.UserName, q.WarehouseName, q.DatabaseName, q.SchemaName,
+			q.QueryType, q.ErrorCode, q.ErrorMessage, q.StartTime.UnixMilli(), q.EndTime.UnixMilli(),
+			q.ExecutionTime, q.BytesScanned, q.CreditsUsedCloudServices, q.AccountName, q.RoleName,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert history row: %w", err)
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			inserted += int(n)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit history: %w", err)
+	}
+	return inserted, nil
+}
+
+// Query returns stored failures for an account, newest first. A zero start
+// and end return the most recent rows regardless of age.
+func (h *HistoryStore) Query(ctx context.Context, account string, start, end time.Time, limit int) ([]FailedQuery, error) {
+	query := `
+		SELECT query_id, query_text, user_name, warehouse_name, database_name, schema_name,
+			query_type, error_code, error_message, start_time, end_time,
+			execution_time_seconds, bytes_scanned, credits_used_cloud_services, account_name, role_name
+		FROM failed_queries
+		WHERE account = ?`
+	args := []interface{}{account}
+	if !start.IsZero() {
+		query += ` AND start_time BETWEEN ? AND ?`
+		args = append(args, start.UnixMilli(), end.UnixMilli())
+	}
+	query += ` ORDER BY start_time DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer rows.Close()
+
+	queries := []FailedQuery{}
+	for rows.Next() {
+		var q FailedQuery
+		var startMs, endMs int64
+		if err := rows.Scan(
+			&q.QueryID, &q.QueryText, &q.UserName, &q.WarehouseName, &q.DatabaseName, &q.SchemaName,
+			&q.QueryType, &q.ErrorCode, &q.ErrorMessage, &startMs, &endMs,
+			&q.ExecutionTime, &q.BytesScanned, &q.CreditsUsedCloudServices, &q.AccountName, &q.RoleName,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan history row: %w", err)
+		}
+		q.StartTime = time.UnixMilli(startMs).UTC()
+		q.EndTime = time.UnixMilli(endMs).UTC()
+		q.Category = classifyError(q.ErrorMessage, q.ErrorCode)
+		q.DurationBucket = classifyDuration(q.ExecutionTime)
+		// Rows recorded before REDACT_PATTERNS was set are stored unredacted
+		redactQuery(&q)
+		queries = append(queries, q)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating history rows: %w", err)
+	}
+	return queries, nil
+}
+
+// AckStore records which failed queries have been acknowledged during triage.
+// Acknowledgments are cached in memory for rendering and persisted to SQLite.
+// Query IDs are globally unique in Snowflake, so they aren't keyed by account.
+type AckStore struct {
+	db *sql.DB
+
+	mu    sync.RWMutex
+	acked map[string]bool
+}
+
+const ackSchema = `
+	CREATE TABLE IF NOT EXISTS acknowledgements (
+		query_id        TEXT    PRIMARY KEY,
+		acknowledged_at INTEGER NOT NULL -- Unix milliseconds
+	);`
+
+// openAckStore opens the acknowledgment database at path and loads it into memory
+func openAckStore(path string) (*AckStore, error) {
+	db, err := openSQLite(path, ackSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT query_id FROM acknowledgements`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load acknowledgements: %w", err)
+	}
+	defer rows.Close()
+
+	acked := make(map[string]bool)
+	for rows.Next() {
+		var queryID string
+		if err := rows.Scan(&queryID); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to scan acknowledgement: %w", err)
+		}
+		acked[queryID] = true
+	}
+	if err := rows.Err(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error iterating acknowledgements: %w", err)
+	}
+
+	return &AckStore{db: db, acked: acked}, nil
+}
+
+func (a *AckStore) Close() error {
+	return a.db.Close()
+}
+
+// Set acknowledges (or un-acknowledges) a query
+func (a *AckStore) Set(ctx context.Context, queryID string, acknowledged bool) error {
+	var err error
+	if acknowledged {
+		_, err = a.db.ExecContext(ctx,
+			`INSERT OR REPLACE INTO acknowledgements (query_id, acknowledged_at) VALUES (?, ?)`,
+			queryID, time.Now().UnixMilli())
+	} else {
+		_, err = a.db.ExecContext(ctx, `DELETE FROM acknowledgements WHERE query_id = ?`, queryID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save acknowledgement: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if acknowledged {
+		a.acked[queryID] = true
+	} else {
+		delete(a.acked, queryID)
+	}
+	return nil
+}
+
+// Annotate returns a copy of queries with Acknowledged filled in.
+// Cached slices are shared, so the input is never modified. A nil store returns queries as-is.
+func (a *AckStore) Annotate(queries []FailedQuery) []FailedQuery {
+	if a == nil {
+		return queries
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	annotated := make([]FailedQuery, len(queries))
+	for i, q := range queries {
+		q.Acknowledged = a.acked[q.QueryID]
+		annotated[i] = q
+	}
+	return annotated
+}
+
+// withoutAcknowledged drops acknowledged queries (for ?hide_acknowledged=true)
+func withoutAcknowledged(queries []FailedQuery) []FailedQuery {
+	kept := make([]FailedQuery, 0, len(queries))
+	for _, q := range queries {
+		if !q.Acknowledged {
+			kept = append(kept, q)
+		}
+	}
+	return kept
+}
+
+// recordHistory periodically copies an account's failed queries into the history store
+func recordHistory(account *accountConn, store *HistoryStore, opts QueryOptions, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		queries, err := getFailedQueries(ctx, account.db, opts)
+		cancel()
+		if err != nil {
+			log.Printf("History poll failed for account %s: %v", account.name, err)
+			continue
+		}
+
+		inserted, err := store.Save(account.name, queries)
+		if err != nil {
+			log.Printf("Error saving history for account %s: %v", account.name, err)
+			continue
+		}
+		if inserted > 0 {
+			log.Printf("Recorded %d new failed queries in history for account %s", inserted, account.name)
+		}
+	}
+}
+
+// defaultKeepAliveInterval is just under the default DB_CONN_MAX_IDLE_TIME, so the pinged
+// connection is never idle long enough to be closed
+const defaultKeepAliveInterval = 50 * time.Second
+
+// keepWarm runs SELECT 1 on an account's existing pool every interval, so a pooled
+// connection stays open and its session logged in between dashboard visits. Each
+// query has a cost when it keeps the warehouse from suspending (see KEEPALIVE_ENABLED).
+func keepWarm(account *accountConn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		var one int
+		err := account.db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+		cancel()
+		if err != nil {
+			log.Printf("Keep-alive query failed for account %s: %v", account.name, err)
+		}
+	}
+}
+
+// sseHeartbeatInterval keeps idle event streams from being closed by proxies
+const sseHeartbeatInterval = 15 * time.Second
+
+// queryStream polls one account in the background while clients are subscribed
+// and pushes the encoded result to all of them whenever it changes, so N open
+// dashboards cost one Snowflake query per interval instead of N.
+type queryStream struct {
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+	latest      []byte // Last published payload; nil when nobody is subscribed
+}
+
+func newQueryStream() *queryStream {
+	return &queryStream{subscribers: make(map[chan []byte]struct{})}
+}
+
+// Subscribe registers a client and returns its channel plus the latest payload (if any)
+func (s *queryStream) Subscribe() (chan []byte, []byte) {
+	ch := make(chan []byte, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers[ch] = struct{}{}
+	return ch, s.latest
+}
+
+// Unsubscribe removes a client added by Subscribe
+func (s *queryStream) Unsubscribe(ch chan []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, ch)
+	if len(s.subscribers) == 0 {
+		// Don't hand a stale snapshot to the next subscriber
+		s.latest = nil
+	}
+}
+
+func (s *queryStream) hasSubscribers() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subscribers) > 0
+}
+
+// publish sends payload to every subscriber if it differs from the last one.
+// Slow clients only ever hold the newest payload.
+func (s *queryStream) publish(payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bytes.Equal(payload, s.latest) {
+		return
+	}
+	s.latest = payload
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- payload:
+		default:
+			// Replace the unread payload with the newer one
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- payload
+		}
+	}
+}
+
+// run polls Snowflake every interval while there are subscribers
+func (s *queryStream) run(account *accountConn, opts QueryOptions, interval time.Duration, acks *AckStore, previewChars int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !s.hasSubscribers() {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		queries, _, err := account.failedQueries(ctx, opts, false)
+		cancel()
+		if err != nil {
+			log.Printf("Stream poll failed for account %s: %v", account.name, err)
+			continue
+		}
+		if queries == nil {
+			queries = []FailedQuery{}
+		}
+
+		payload, err := json.Marshal(withQueryTextPreview(acks.Annotate(queries), previewChars))
+		if err != nil {
+			log.Printf("Error encoding stream payload: %v", err)
+			continue
+		}
+		s.publish(payload)
+	}
+}
+
+// writeSSE writes one server-sent event; payload must not contain newlines
+func writeSSE(w http.ResponseWriter, event string, payload []byte) error {
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
+
+// FailureSummary aggregates failed queries sharing an error code
+type FailureSummary struct {
+	ErrorCode     string    `json:"error_code"`     // Empty when Snowflake recorded no code
+	SampleMessage string    `json:"sample_message"` // One representative error message for the group
+	Count         int       `json:"count"`
+	DistinctUsers int       `json:"distinct_users"`
+	LastSeen      time.Time `json:"last_seen"`
+}
+
+// getFailureSummary groups failed queries by error code, most frequent first
+func getFailureSummary(ctx context.Context, db *sql.DB, opts QueryOptions) ([]FailureSummary, error) {
+	where, args := buildFailedQueriesWhere(opts)
+	query := `
+		SELECT
+			ERROR_CODE,
+			ANY_VALUE(ERROR_MESSAGE) as SAMPLE_MESSAGE,
+			COUNT(*) as FAILURE_COUNT,
+			COUNT(DISTINCT USER_NAME) as DISTINCT_USERS,
+			MAX(START_TIME) as LAST_SEEN
+		FROM ` + historySource.View + where + `
+		GROUP BY ERROR_CODE
+		ORDER BY FAILURE_COUNT DESC, LAST_SEEN DESC`
+
+	rows, err := queryWithRetry(ctx, db, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query failure summary: %w", err)
+	}
+	defer rows.Close()
+
+	summaries := []FailureSummary{}
+	for rows.Next() {
+		var s FailureSummary
+		var errorCode, sampleMessage sql.NullString
+		if err := rows.Scan(&errorCode, &sampleMessage, &s.Count, &s.DistinctUsers, &s.LastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan summary row: %w", err)
+		}
+		s.ErrorCode = errorCode.String
+		s.SampleMessage = redact(sampleMessage.String)
+		if s.SampleMessage == "" {
+			s.SampleMessage = noErrorMessage
+		}
+		summaries = append(summaries, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating summary rows: %w", err)
+	}
+
+	return summaries, nil
+}
+
+// Facets are the distinct values seen among a window's failures, for populating filter
+// dropdowns with every option rather than just those on the current page
+type Facets struct {
+	Users      []string `json:"users"`
+	Warehouses []string `json:"warehouses"` // Includes "(none)" when some queries ran without a warehouse
+	Databases  []string `json:"databases"`
+	ErrorCodes []string `json:"error_codes"`
+}
+
+// facetCacheTTL is how long facets are reused; the option lists change slowly
+const facetCacheTTL = 5 * time.Minute
+
+// facetWindow keeps only the time window and exclusions from opts: facets list every
+// option in the window, so the user/warehouse/... filters don't apply to them
+func facetWindow(opts QueryOptions) QueryOptions {
+	return QueryOptions{
+		LookbackHours:     opts.LookbackHours,
+		StartTime:         opts.StartTime,
+		EndTime:           opts.EndTime,
+		ExcludePatterns:   opts.ExcludePatterns,
+		ExcludeErrorCodes: opts.ExcludeErrorCodes,
+	}
+}
+
+// getFacets collects the distinct users, warehouses, databases, and error codes of
+// the failures in opts' window with one query, each list sorted
+func getFacets(ctx context.Context, db *sql.DB, opts QueryOptions) (Facets, error) {
+	where, args := buildFailedQueriesWhere(facetWindow(opts))
+	query := `
+		WITH failed AS (
+			SELECT USER_NAME, WAREHOUSE_NAME, DATABASE_NAME, ERROR_CODE
+			FROM ` + historySource.View + where + `
+		)
+		SELECT DISTINCT 'user', USER_NAME FROM failed WHERE USER_NAME IS NOT NULL
+		UNION ALL
+		SELECT DISTINCT 'warehouse', WAREHOUSE_NAME FROM failed
+		UNION ALL
+		SELECT DISTINCT 'database', DATABASE_NAME FROM failed WHERE DATABASE_NAME IS NOT NULL
+		UNION ALL
+		SELECT DISTINCT 'error_code', ERROR_CODE FROM failed WHERE ERROR_CODE IS NOT NULL`
+
+	rows, err := queryWithRetry(ctx, db, query, args...)
+	if err != nil {
+		return Facets{}, fmt.Errorf("failed to query facets: %w", err)
+	}
+	defer rows.Close()
+
+	f := Facets{Users: []string{}, Warehouses: []string{}, Databases: []string{}, ErrorCodes: []string{}}
+	for rows.Next() {
+		var facet string
+		var value sql.NullString
+		if err := rows.Scan(&facet, &value); err != nil {
+			return Facets{}, fmt.Errorf("failed to scan facet row: %w", err)
+		}
+		switch facet {
+		case "user":
+			f.Users = append(f.Users, value.String)
+		case "warehouse":
+			if !value.Valid {
+				value.String = noWarehouse
+			}
+			f.Warehouses = append(f.Warehouses, value.String)
+		case "database":
+			f.Databases = append(f.Databases, value.String)
+		case "error_code":
+			f.ErrorCodes = append(f.ErrorCodes, value.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return Facets{}, fmt.Errorf("error iterating facet rows: %w", err)
+	}
+
+	for _, list := range [][]string{f.Users, f.Warehouses, f.Databases, f.ErrorCodes} {
+		sort.Strings(list)
+	}
+	return f, nil
+}
+
+// facetCacheEntry holds cached facets and when they expire
+type facetCacheEntry struct {
+	facets  Facets
+	expires time.Time
+}
+
+// facetCache keeps facets for facetCacheTTL per time window, independent of CACHE_TTL_SECONDS
+type facetCache struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	entries map[string]facetCacheEntry
+}
+
+func newFacetCache(db *sql.DB) *facetCache {
+	return &facetCache{db: db, entries: make(map[string]facetCacheEntry)}
+}
+
+// Get returns the facets for opts' window and whether they were served from cache.
+// The returned lists are shared between callers and must not be modified.
+func (c *facetCache) Get(ctx context.Context, opts QueryOptions) (Facets, bool, error) {
+	key := fmt.Sprintf("%#v", facetWindow(opts))
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.facets, true, nil
+	}
+
+	facets, err := getFacets(ctx, c.db, opts)
+	if err != nil {
+		return Facets{}, false, err
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	// Drop expired entries so the map doesn't grow with stale windows
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = facetCacheEntry{facets: facets, expires: now.Add(facetCacheTTL)}
+	c.mu.Unlock()
+
+	return facets, false, nil
+}
+
+// defaultStaleDataThreshold is how far behind ACCOUNT_USAGE may fall before the dashboard
+// warns; QUERY_HISTORY normally lags real time by anywhere up to 45 minutes
+const defaultStaleDataThreshold = 30 * time.Minute
+
+// freshnessCacheTTL is how long an account's newest QUERY_HISTORY timestamp is reused
+const freshnessCacheTTL = time.Minute
+
+// DataFreshness reports how far ACCOUNT_USAGE lags behind real time, so an empty window
+// can be told apart from one whose queries haven't arrived yet
+type DataFreshness struct {
+	NewestRecord time.Time `json:"newest_record"` // START_TIME of the newest query of any status
+	CheckedAt    time.Time `json:"checked_at"`    // When NewestRecord was read
+	LagSeconds   int       `json:"lag_seconds"`   // CheckedAt minus NewestRecord
+	Stale        bool      `json:"stale"`         // The lag exceeds STALE_DATA_THRESHOLD
+}
+
+// getNewestRecord returns the newest START_TIME in QUERY_HISTORY, or the zero time when
+// there's nothing from the past week. The range keeps Snowflake from scanning it all.
+func getNewestRecord(ctx context.Context, db *sql.DB) (time.Time, error) {
+	rows, err := queryWithRetry(ctx, db, `
+		SELECT MAX(START_TIME)
+		FROM `+historySource.View+`
+		WHERE START_TIME >= DATEADD(day, -7, CURRENT_TIMESTAMP())`)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to query newest record: %w", err)
+	}
+	defer rows.Close()
+
+	var newest sql.NullTime
+	if rows.Next() {
+		if err := rows.Scan(&newest); err != nil {
+			return time.Time{}, fmt.Errorf("failed to scan newest record: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return time.Time{}, fmt.Errorf("error iterating newest record rows: %w", err)
+	}
+	return newest.Time, nil
+}
+
+// freshnessCache keeps an account's newest QUERY_HISTORY timestamp for freshnessCacheTTL,
+// so every page load and API call doesn't cost another Snowflake query
+type freshnessCache struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	freshness *DataFreshness
+	expires   time.Time
+}
+
+func newFreshnessCache(db *sql.DB) *freshnessCache {
+	return &freshnessCache{db: db}
+}
+
+// Get returns how stale the account's data is against threshold, or nil when QUERY_HISTORY
+// has no recent queries to tell. The result is shared and must not be modified.
+func (c *freshnessCache) Get(ctx context.Context, threshold time.Duration) (*DataFreshness, error) {
+	c.mu.Lock()
+	freshness, expires := c.freshness, c.expires
+	c.mu.Unlock()
+	if time.Now().Before(expires) {
+		return freshness, nil
+	}
+
+	newest, err := getNewestRecord(ctx, c.db)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	freshness = nil
+	if !newest.IsZero() {
+		lag := max(now.Sub(newest), 0) // A skewed clock could put the record in the future
+		freshness = &DataFreshness{
+			NewestRecord: newest,
+			CheckedAt:    now,
+			LagSeconds:   int(lag.Seconds()),
+			Stale:        lag > threshold,
+		}
+	}
+
+	c.mu.Lock()
+	c.freshness, c.expires = freshness, now.Add(freshnessCacheTTL)
+	c.mu.Unlock()
+	return freshness, nil
+}
+
+// PeriodComparison compares the failures in a window with the window of equal length just before it
+type PeriodComparison struct {
+	CurrentCount  int      `json:"current_count"`
+	PreviousCount int      `json:"previous_count"`
+	ChangePercent *float64 `json:"change_percent"` // nil when the previous window had no failures
+}
+
+// Delta formats the change for the stats bar, e.g. "▲ 25%"
+func (c PeriodComparison) Delta() string {
+	switch {
+	case c.ChangePercent == nil && c.CurrentCount > 0:
+		return "▲ new"
+	case c.ChangePercent == nil || *c.ChangePercent == 0:
+		return "— 0%"
+	case *c.ChangePercent > 0:
+		return fmt.Sprintf("▲ %.0f%%", *c.ChangePercent)
+	default:
+		return fmt.Sprintf("▼ %.0f%%", -*c.ChangePercent)
+	}
+}
+
+// DeltaClass is the CSS class coloring the change (more failures is worse)
+func (c PeriodComparison) DeltaClass() string {
+	switch {
+	case c.CurrentCount > c.PreviousCount:
+		return "delta-up"
+	case c.CurrentCount < c.PreviousCount:
+		return "delta-down"
+	default:
+		return "delta-flat"
+	}
+}
+
+// getPeriodComparison counts failures in opts' window and in the preceding window of
+// the same length (hours -48..-24 for a 24 hour lookback) with one aggregate query.
+// Unlike the query list, the counts aren't capped by the row limit.
+func getPeriodComparison(ctx context.Context, db *sql.DB, opts QueryOptions) (PeriodComparison, error) {
+	start, end := trendWindow(opts, time.Now())
+	both := opts
+	both.StartTime = start.Add(-end.Sub(start))
+	both.EndTime = end
+
+	where, whereArgs := buildFailedQueriesWhere(both)
+	split := start.Format(timestampBindLayout)
+	query := `
+		SELECT
+			COUNT_IF(START_TIME >= TO_TIMESTAMP_TZ(?, '` + snowflakeTimestampFormat + `')) as CURRENT_COUNT,
+			COUNT_IF(START_TIME < TO_TIMESTAMP_TZ(?, '` + snowflakeTimestampFormat + `')) as PREVIOUS_COUNT
+		FROM ` + historySource.View + where
+	args := append([]interface{}{split, split}, whereArgs...)
+
+	rows, err := queryWithRetry(ctx, db, query, args...)
+	if err != nil {
+		return PeriodComparison{}, fmt.Errorf("failed to query period comparison: %w", err)
+	}
+	defer rows.Close()
+
+	var c PeriodComparison
+	if rows.Next() {
+		if err := rows.Scan(&c.CurrentCount, &c.PreviousCount); err != nil {
+			return PeriodComparison{}, fmt.Errorf("failed to scan period comparison: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return PeriodComparison{}, fmt.Errorf("error iterating period comparison rows: %w", err)
+	}
+
+	if c.PreviousCount > 0 {
+		change := math.Round(float64(c.CurrentCount-c.PreviousCount)/float64(c.PreviousCount)*1000) / 10
+		c.ChangePercent = &change
+	}
+	return c, nil
+}
+
+// OverviewCount is the number of failures in one trailing window
+type OverviewCount struct {
+	Window string `json:"window"` // As configured in OVERVIEW_WINDOWS, e.g. "24h"
+	Hours  int    `json:"hours"`
+	Count  int    `json:"count"`
+}
+
+// getFailureOverview counts failures in each trailing window (ending now) with one
+// aggregate query over the longest window. opts' filters apply, but its time range doesn't.
+func getFailureOverview(ctx context.Context, db *sql.DB, opts QueryOptions, windows []overviewWindow) ([]OverviewCount, error) {
+	counts := make([]OverviewCount, len(windows))
+	if len(windows) == 0 {
+		return counts, nil
+	}
+
+	longest := opts
+	longest.StartTime, longest.EndTime = time.Time{}, time.Time{}
+	longest.LookbackHours = windows[len(windows)-1].Hours
+	where, whereArgs := buildFailedQueriesWhere(longest)
+
+	selects := make([]string, len(windows))
+	args := make([]interface{}, 0, len(windows)+len(whereArgs))
+	for i, w := range windows {
+		selects[i] = "COUNT_IF(START_TIME >= DATEADD(hour, ?, CURRENT_TIMESTAMP()))"
+		args = append(args, -w.Hours)
+		counts[i] = OverviewCount{Window: w.Label, Hours: w.Hours}
+	}
+	query := `
+		SELECT ` + strings.Join(selects, ", ") + `
+		FROM ` + historySource.View + where
+	args = append(args, whereArgs...)
+
+	rows, err := queryWithRetry(ctx, db, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query failure overview: %w", err)
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		dests := make([]interface{}, len(counts))
+		for i := range counts {
+			dests[i] = &counts[i].Count
+		}
+		if err := rows.Scan(dests...); err != nil {
+			return nil, fmt.Errorf("failed to scan failure overview: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating failure overview rows: %w", err)
+	}
+	return counts, nil
+}
+
+// trendInterval is a bucket size accepted by /api/trend's ?interval=
+type trendInterval struct {
+	datePart string // DATE_TRUNC date part; from this allowlist only, never from the request
+	width    time.Duration
+}
+
+// trendIntervals are bucketed in UTC, so "day" means a UTC calendar day
+var trendIntervals = map[string]trendInterval{
+	"minute": {datePart: "MINUTE", width: time.Minute},
+	"hour":   {datePart: "HOUR", width: time.Hour},
+	"day":    {datePart: "DAY", width: 24 * time.Hour},
+}
+
+const (
+	defaultTrendInterval = "hour"
+	maxTrendBuckets      = 1000 // Keeps ?interval=minute over a long window from producing a huge response
+)
+
+// TrendBucket is the number of failures that started in one interval
+type TrendBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// trendWindow returns the time range opts covers
+func trendWindow(opts QueryOptions, now time.Time) (time.Time, time.Time) {
+	if !opts.StartTime.IsZero() {
+		return opts.StartTime.UTC(), opts.EndTime.UTC()
+	}
+	return now.UTC().Add(-time.Duration(opts.LookbackHours) * time.Hour), now.UTC()
+}
+
+// parseTrendInterval reads ?interval= and checks the window doesn't need too many buckets.
+// Without ?interval= it uses hours, or days when the window is too long for hourly buckets.
+func parseTrendInterval(r *http.Request, opts QueryOptions) (trendInterval, error) {
+	start, end := trendWindow(opts, time.Now())
+	tooMany := func(interval trendInterval) bool {
+		return end.Sub(start)/interval.width >= maxTrendBuckets
+	}
+
+	name := r.URL.Query().Get("interval")
+	if name == "" {
+		if tooMany(trendIntervals[defaultTrendInterval]) {
+			return trendIntervals["day"], nil
+		}
+		return trendIntervals[defaultTrendInterval], nil
+	}
+	interval, ok := trendIntervals[name]
+	if !ok {
+		return trendInterval{}, fmt.Errorf("invalid interval (must be minute, hour, or day)")
+	}
+
+	if tooMany(interval) {
+		return trendInterval{}, fmt.Errorf("too many %s buckets for this time range (max %d); use a coarser interval", name, maxTrendBuckets)
+	}
+	return interval, nil
+}
+
+// getFailureTrend counts failures per interval across the window, oldest first.
+// Intervals without failures are included with a zero count.
+func getFailureTrend(ctx context.Context, db *sql.DB, opts QueryOptions, interval trendInterval) ([]TrendBucket, error) {
+	where, args := buildFailedQueriesWhere(opts)
+	query := `
+		SELECT
+			DATE_TRUNC('` + interval.datePart + `', CONVERT_TIMEZONE('UTC', START_TIME)) as BUCKET,
+			COUNT(*) as FAILURE_COUNT
+		FROM ` + historySource.View + where + `
+		GROUP BY BUCKET
+		ORDER BY BUCKET`
+
+	rows, err := queryWithRetry(ctx, db, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query failure trend: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int64]int)
+	for rows.Next() {
+		var bucket time.Time
+		var count int
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan trend row: %w", err)
+		}
+		counts[bucket.Unix()] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating trend rows: %w", err)
+	}
+
+	start, end := trendWindow(opts, time.Now())
+	trend := []TrendBucket{}
+	for t := start.Truncate(interval.width); !t.After(end); t = t.Add(interval.width) {
+		trend = append(trend, TrendBucket{Start: t, Count: counts[t.Unix()]})
+	}
+	return trend, nil
+}
+
+// Heatmap counts failures per warehouse per UTC hour across the window:
+// Counts[i][j] is the number of failures on Warehouses[i] in the hour starting at Hours[j]
+type Heatmap struct {
+	Hours      []time.Time `json:"hours"`      // Every hour in the window, oldest first
+	Warehouses []string    `json:"warehouses"` // Most failures first; "(none)" for queries that ran without a warehouse
+	Counts     [][]int     `json:"counts"`
+	Max        int         `json:"max"` // Largest cell, for scaling colors
+}
+
+// heatmapTooLong reports whether opts' window has too many hours for a heatmap
+func heatmapTooLong(opts QueryOptions) bool {
+	start, end := trendWindow(opts, time.Now())
+	return end.Sub(start)/time.Hour >= maxTrendBuckets
+}
+
+// getFailureHeatmap counts failures per warehouse and hour. Hours without failures
+// are included with zero counts so every row has one cell per hour.
+func getFailureHeatmap(ctx context.Context, db *sql.DB, opts QueryOptions) (Heatmap, error) {
+	where, args := buildFailedQueriesWhere(opts)
+	query := `
+		SELECT
+			WAREHOUSE_NAME,
+			DATE_TRUNC('HOUR', CONVERT_TIMEZONE('UTC', START_TIME)) as BUCKET,
+			COUNT(*) as FAILURE_COUNT
+		FROM ` + historySource.View + where + `
+		GROUP BY WAREHOUSE_NAME, BUCKET`
+
+	rows, err := queryWithRetry(ctx, db, query, args...)
+	if err != nil {
+		return Heatmap{}, fmt.Errorf("failed to query failure heatmap: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]map[int64]int)
+	totals := make(map[string]int)
+	for rows.Next() {
+		var warehouse sql.NullString
+		var bucket time.Time
+		var count int
+		if err := rows.Scan(&warehouse, &bucket, &count); err != nil {
+			return Heatmap{}, fmt.Errorf("failed to scan heatmap row: %w", err)
+		}
+		name := warehouse.String
+		if !warehouse.Valid {
+			name = noWarehouse
+		}
+		if counts[name] == nil {
+			counts[name] = make(map[int64]int)
+		}
+		counts[name][bucket.Unix()] = count
+		totals[name] += count
+	}
+
+	if err := rows.Err(); err != nil {
+		return Heatmap{}, fmt.Errorf("error iterating heatmap rows: %w", err)
+	}
+
+	heatmap := Heatmap{Hours: []time.Time{}, Warehouses: []string{}, Counts: [][]int{}}
+	start, end := trendWindow(opts, time.Now())
+	for t := start.Truncate(time.Hour); !t.After(end); t = t.Add(time.Hour) {
+		heatmap.Hours = append(heatmap.Hours, t)
+	}
+
+	for warehouse := range totals {
+		heatmap.Warehouses = append(heatmap.Warehouses, warehouse)
+	}
+	sort.Slice(heatmap.Warehouses, func(i, j int) bool {
+		a, b := heatmap.Warehouses[i], heatmap.Warehouses[j]
+		if totals[a] != totals[b] {
+			return totals[a] > totals[b]
+		}
+		return a < b
+	})
+
+	for _, warehouse := range heatmap.Warehouses {
+		row := make([]int, len(heatmap.Hours))
+		for j, hour := range heatmap.Hours {
+			row[j] = counts[warehouse][hour.Unix()]
+			heatmap.Max = max(heatmap.Max, row[j])
+		}
+		heatmap.Counts = append(heatmap.Counts, row)
+	}
+	return heatmap, nil
+}
+
+// HourlyDistribution counts failures by hour of day across every day in the window, so
+// scheduled jobs that fail at the same clock time stand out regardless of date
+type HourlyDistribution struct {
+	TimeZone string `json:"timezone"` // IANA zone the hours are in
+	Counts   []int  `json:"counts"`   // 24 entries; Counts[h] covers h:00 to h:59
+	Max      int    `json:"max"`      // Largest count, for scaling bars
+}
+
+// validTimeZoneName matches IANA zone names such as America/New_York or Etc/GMT+5
+var validTimeZoneName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+/-]{0,63}$`)
+
+// parseTimeZoneParam reads ?timezone=, an IANA zone name; def is used when it's absent
+func parseTimeZoneParam(r *http.Request, def *time.Location) (*time.Location, error) {
+	name := r.URL.Query().Get("timezone")
+	if name == "" {
+		return def, nil
+	}
+	// "Local" would mean the server's zone, which Snowflake doesn't know by that name
+	if !validTimeZoneName.MatchString(name) || name == "Local" {
+		return nil, errors.New("invalid timezone parameter (must be an IANA zone name like America/New_York)")
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errors.New("invalid timezone parameter (must be an IANA zone name like America/New_York)")
+	}
+	return loc, nil
+}
+
+// getHourlyDistribution counts failures per hour of day in loc. Hours without
+// failures are included with a zero count, so there are always 24.
+func getHourlyDistribution(ctx context.Context, db *sql.DB, opts QueryOptions, loc *time.Location) (HourlyDistribution, error) {
+	where, whereArgs := buildFailedQueriesWhere(opts)
+	query := `
+		SELECT
+			HOUR(CONVERT_TIMEZONE(?, START_TIME)) as HOUR_OF_DAY,
+			COUNT(*) as FAILURE_COUNT
+		FROM ` + historySource.View + where + `
+		GROUP BY HOUR_OF_DAY`
+	args := append([]interface{}{loc.String()}, whereArgs...)
+
+	rows, err := queryWithRetry(ctx, db, query, args...)
+	if err != nil {
+		return HourlyDistribution{}, fmt.Errorf("failed to query hourly distribution: %w", err)
+	}
+	defer rows.Close()
+
+	distribution := HourlyDistribution{TimeZone: loc.String(), Counts: make([]int, 24)}
+	for rows.Next() {
+		var hour, count int
+		if err := rows.Scan(&hour, &count); err != nil {
+			return HourlyDistribution{}, fmt.Errorf("failed to scan hourly distribution row: %w", err)
+		}
+		if hour >= 0 && hour < 24 {
+			distribution.Counts[hour] = count
+			distribution.Max = max(distribution.Max, count)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return HourlyDistribution{}, fmt.Errorf("error iterating hourly distribution rows: %w", err)
+	}
+	return distribution, nil
+}
+
+// checkAccountUsageAccess verifies that the DATA_SOURCE's QUERY_HISTORY view is queryable with the current role
+func checkAccountUsageAccess(ctx context.Context, db *sql.DB) error {
+	var one int
+	err := db.QueryRowContext(ctx, "SELECT 1 FROM "+historySource.View+" LIMIT 1").Scan(&one)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to query %s: %w", historySource.View, err)
+	}
+	return nil
+}
+
+// probeHistoryColumns returns the column names of the DATA_SOURCE's QUERY_HISTORY view,
+// read from an empty result so nothing is scanned
+func probeHistoryColumns(ctx context.Context, db *sql.DB) (map[string]bool, error) {
+	rows, err := queryWithRetry(ctx, db, "SELECT * FROM "+historySource.View+" LIMIT 0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the columns of %s: %w", historySource.View, err)
+	}
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the columns of %s: %w", historySource.View, err)
+	}
+	columns := make(map[string]bool, len(names))
+	for _, name := range names {
+		columns[strings.ToUpper(name)] = true
+	}
+	return columns, nil
+}
+
+// requiredHistoryColumns are named directly by the WHERE clauses (including every ?filter=
+// field and QUERY_TEXT for EXCLUDE_PATTERNS), sorting, facets, and the heatmap's grouping,
+// so a view without one of them can't be read at all; other missing columns are selected as NULL
+var requiredHistoryColumns = []string{
+	"QUERY_ID", "QUERY_TEXT", "USER_NAME", "ROLE_NAME", "WAREHOUSE_NAME", "DATABASE_NAME", "SCHEMA_NAME",
+	"QUERY_TYPE", "QUERY_TAG", "EXECUTION_STATUS", "ERROR_CODE", "ERROR_MESSAGE", "START_TIME",
+	"TOTAL_ELAPSED_TIME", "BYTES_SCANNED", "ACCOUNT_NAME",
+}
+
+// missingHistoryColumns checks the probed columns of the ORGANIZATION_USAGE view, returning
+// the failedQueryColumns sources and queryMetricsColumns it lacks, or an error naming a
+// missing required column
+func missingHistoryColumns(columns map[string]bool) (map[string]bool, error) {
+	for _, name := range requiredHistoryColumns {
+		if !columns[name] {
+			return nil, fmt.Errorf("%s has no %s column, which the dashboard requires", historySource.View, name)
+		}
+	}
+	missing := make(map[string]bool)
+	for _, c := range failedQueryColumns {
+		if source := c.source(); !columns[source] {
+			missing[source] = true
+		}
+	}
+	for _, column := range queryMetricsColumns {
+		if !columns[column] {
+			missing[column] = true
+		}
+	}
+	return missing, nil
+}
+
+// writeHealthStatus writes a small JSON health response for orchestrator probes
+func writeHealthStatus(w http.ResponseWriter, healthy bool) {
+	status, body := http.StatusOK, "ok"
+	if !healthy {
+		status, body = http.StatusServiceUnavailable, "unhealthy"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": body}); err != nil {
+		log.Printf("Error encoding health status: %v", err)
+	}
+}
+
+// ReadinessStatus is the /readyz response body
+type ReadinessStatus struct {
+	Status    string                `json:"status"`     // "ok", "starting", or "unhealthy"
+	LastFetch map[string]*time.Time `json:"last_fetch"` // Last successful background fetch per account; null before the first
+}
+
+// writeReadiness writes the /readyz response: 200 when status is "ok", 503 otherwise
+func writeReadiness(w http.ResponseWriter, body ReadinessStatus) {
+	status := http.StatusOK
+	if body.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error encoding readiness status: %v", err)
+	}
+}
+
+// openAPIParam is one parameter in the generated OpenAPI document
+type openAPIParam struct {
+	Name        string
+	In          string // "query" or "path"
+	Schema      map[string]interface{}
+	Description string
+}
+
+// openAPIOperation is one endpoint in the generated OpenAPI document. Response
+// and Body are Go types whose JSON shape is derived by reflection, so the
+// document can't drift from what the handlers encode.
+type openAPIOperation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Params      []openAPIParam
+	Body        reflect.Type // Request body; nil for none
+	Response    reflect.Type // JSON response body; nil for ContentType responses
+	ContentType string       // Non-JSON success response (e.g. text/event-stream)
+}
+
+// openAPIVersion is the document's info.version; bump it when the API changes shape
+const openAPIVersion = "1.0.0"
+
+func queryParam(name string, schema map[string]interface{}, description string) openAPIParam {
+	return openAPIParam{Name: name, In: "query", Schema: schema, Description: description}
+}
+
+var (
+	stringSchema   = map[string]interface{}{"type": "string"}
+	numberSchema   = map[string]interface{}{"type": "number"}
+	integerSchema  = map[string]interface{}{"type": "integer"}
+	booleanSchema  = map[string]interface{}{"type": "boolean"}
+	dateTimeSchema = map[string]interface{}{"type": "string", "format": "date-time"}
+)
+
+func enumSchema(values []string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "enum": values}
+}
+
+// openAPIFilterParams are the filters applyQueryFilters accepts
+func openAPIFilterParams() []openAPIParam {
+	return []openAPIParam{
+		queryParam("account", stringSchema, "Configured account to query (defaults to the first)"),
+		queryParam("user", stringSchema, "Only failures by this Snowflake user"),
+		queryParam("user_pattern", stringSchema, "Only failures by users matching this ILIKE pattern (e.g. SVC_%); prefix with ! for users that don't match"),
+		queryParam("warehouse", stringSchema, "Only failures on this warehouse; "+noWarehouse+" for queries that ran without one"),
+		queryParam("query_type", stringSchema, "Only failures of this QUERY_TYPE (e.g. SELECT, INSERT, COPY)"),
+		queryParam("role", stringSchema, "Only failures that ran as this role"),
+		queryParam("min_duration", numberSchema, "Minimum total elapsed time in seconds"),
+		queryParam("max_duration", numberSchema, "Maximum total elapsed time in seconds"),
+		queryParam("start", dateTimeSchema, "Start of an absolute time range (RFC 3339, requires end); replaces the lookback window"),
+		queryParam("end", dateTimeSchema, "End of an absolute time range (RFC 3339, requires start)"),
+		queryParam("include_cancellations", booleanSchema, "Override whether cancelled queries (error code 000604) are included; defaults to EXCLUDE_ERROR_CODES"),
+		queryParam("filter", stringSchema, "Extra conditions as field:operator:value, comma-separated (e.g. error_code:eq:604,user_name:ilike:SVC%)"),
+		queryParam("duration_bucket", enumSchema(durationBucketNames()), "Only failures in this execution time bucket: instant (<1s), short (1-10s), medium (10-60s), or long (>60s)"),
+		queryParam("database", stringSchema, "Only failures whose session database was this one"),
+		queryParam("account_name", stringSchema, "Only failures in this organization account (requires DATA_SOURCE=organization_usage)"),
+	}
+}
+
+// openAPISortParams are the parameters applySortParams accepts
+func openAPISortParams() []openAPIParam {
+	fields := make([]string, 0, len(sortColumns))
+	for field := range sortColumns {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return []openAPIParam{
+		queryParam("sort", enumSchema(fields), "Result ordering (defaults to newest first)"),
+		queryParam("order", enumSchema([]string{"asc", "desc"}), "Sort direction"),
+	}
+}
+
+// AckRequest is the optional body of POST /api/queries/{id}/ack
+type AckRequest struct {
+	Acknowledged *bool `json:"acknowledged"` // Defaults to true; false clears the acknowledgement
+}
+
+// AckResponse reports the acknowledgement state after POST /api/queries/{id}/ack
+type AckResponse struct {
+	QueryID      string `json:"query_id"`
+	Acknowledged bool   `json:"acknowledged"`
+}
+
+// openAPIOperations lists the JSON API; keep it in step with the handlers registered in main
+func openAPIOperations() []openAPIOperation {
+	filters := openAPIFilterParams()
+	fullText := queryParam("full_text", booleanSchema, "false returns query text previews with query_text_truncated set (default true)")
+	fresh := queryParam("fresh", booleanSchema, "true queries Snowflake now instead of serving the background snapshot or cache")
+	queryIDParam := openAPIParam{Name: "id", In: "path", Schema: stringSchema, Description: "Snowflake query ID"}
+	concat := func(lists ...[]openAPIParam) []openAPIParam {
+		var all []openAPIParam
+		for _, l := range lists {
+			all = append(all, l...)
+		}
+		return all
+	}
+
+	return []openAPIOperation{
+		{
+			Method: "GET", Path: "/api/queries", Summary: "List failed queries, newest first",
+			Params: concat(filters, openAPISortParams(), []openAPIParam{
+				queryParam("fields", stringSchema, "Comma-separated fields to include in each object (see /api/columns)"),
+				queryParam("category", enumSchema(errorCategoryNames()), "Only failures in this error category"),
+				queryParam("hide_acknowledged", booleanSchema, "Leave out acknowledged failures"),
+				fullText,
+				fresh,
+			}),
+			Response: reflect.TypeOf([]FailedQuery{}),
+		},
+		{
+			Method: "GET", Path: "/api/queries.ndjson", Summary: "Failed queries as newline-delimited JSON, one object per line, streamed as they are read",
+			Params: concat(filters, openAPISortParams(), []openAPIParam{
+				queryParam("category", enumSchema(errorCategoryNames()), "Only failures in this error category"),
+				queryParam("hide_acknowledged", booleanSchema, "Leave out acknowledged failures"),
+				fullText,
+			}),
+			ContentType: "application/x-ndjson",
+		},
+		{
+			Method: "GET", Path: "/api/v2/queries", Summary: "List failed queries with pagination",
+			Params: concat(filters, openAPISortParams(), []openAPIParam{
+				queryParam("limit", integerSchema, "Page size"),
+				queryParam("offset", integerSchema, "Rows to skip"),
+				fullText,
+				fresh,
+			}),
+			Response: reflect.TypeOf(PaginatedQueries{}),
+		},
+		{
+			Method: "GET", Path: "/api/queries/{id}", Summary: "One failed query with its full text",
+			Params:   concat([]openAPIParam{queryIDParam}, filters),
+			Response: reflect.TypeOf(FailedQuery{}),
+		},
+		{
+			Method: "GET", Path: "/api/queries/{id}/metrics", Summary: "Queue time, partitions, and spill bytes of one failed query (requires QUERY_METRICS_ENABLED)",
+			Params:   []openAPIParam{queryIDParam, filters[0], filters[8], filters[9]},
+			Response: reflect.TypeOf(QueryMetrics{}),
+		},
+		{
+			Method: "POST", Path: "/api/queries/{id}/ack", Summary: "Acknowledge a failure (requires ACK_DB_PATH)",
+			Params:   []openAPIParam{queryIDParam},
+			Body:     reflect.TypeOf(AckRequest{}),
+			Response: reflect.TypeOf(AckResponse{}),
+		},
+		{
+			Method: "POST", Path: "/api/test-notification", Summary: "Send a test message through every configured notifier and report each one's outcome (requires API_KEYS)",
+			Params:   filters[:1],
+			Response: reflect.TypeOf([]TestNotificationResult{}),
+		},
+		{
+			Method: "POST", Path: "/api/refresh", Summary: "Re-query the default window now instead of waiting for the next background refresh (concurrent calls share one query)",
+			Params:   filters[:1],
+			Response: reflect.TypeOf(RefreshResult{}),
+		},
+		{
+			Method: "GET", Path: "/api/summary", Summary: "Failed queries grouped by error code, most frequent first",
+			Params:   filters,
+			Response: reflect.TypeOf([]FailureSummary{}),
+		},
+		{
+			Method: "GET", Path: "/api/trend", Summary: "Failure counts per time bucket, oldest first",
+			Params: concat(filters, []openAPIParam{
+				queryParam("interval", enumSchema([]string{"minute", "hour", "day"}), "Bucket size in UTC"),
+			}),
+			Response: reflect.TypeOf([]TrendBucket{}),
+		},
+		{
+			Method: "GET", Path: "/api/heatmap", Summary: "Failure counts per warehouse per UTC hour, busiest warehouse first",
+			Params:   filters,
+			Response: reflect.TypeOf(Heatmap{}),
+		},
+		{
+			Method: "GET", Path: "/api/hourly-distribution", Summary: "Failure counts per hour of day (0-23) across every day in the window",
+			Params: concat(filters, []openAPIParam{
+				queryParam("timezone", stringSchema, "IANA zone for the hours (defaults to DISPLAY_TIMEZONE, or UTC)"),
+			}),
+			Response: reflect.TypeOf(HourlyDistribution{}),
+		},
+		{
+			Method: "GET", Path: "/api/comparison", Summary: "Failure count compared with the preceding window of equal length",
+			Params:   filters,
+			Response: reflect.TypeOf(PeriodComparison{}),
+		},
+		{
+			Method: "GET", Path: "/api/overview", Summary: "Failure counts in each OVERVIEW_WINDOWS window ending now, shortest first",
+			Params:   concat(filters[:8], filters[10:]),
+			Response: reflect.TypeOf([]OverviewCount{}),
+		},
+		{
+			Method: "GET", Path: "/api/freshness", Summary: "Newest QUERY_HISTORY record and how far ACCOUNT_USAGE lags (cached for a minute; null when unknown)",
+			Params:   filters[:1],
+			Response: reflect.TypeOf(DataFreshness{}),
+		},
+		{
+			Method: "GET", Path: "/api/facets", Summary: "Distinct users, warehouses, databases, and error codes in the window (cached for 5 minutes)",
+			Params:   []openAPIParam{filters[0], filters[8], filters[9], filters[10]},
+			Response: reflect.TypeOf(Facets{}),
+		},
+		{
+			Method: "GET", Path: "/api/history", Summary: "Failures from the local SQLite history (requires HISTORY_DB_PATH)",
+			Params: []openAPIParam{
+				filters[0],
+				queryParam("start", dateTimeSchema, "Start of the time range (RFC 3339, requires end)"),
+				queryParam("end", dateTimeSchema, "End of the time range (RFC 3339, requires start)"),
+				queryParam("limit", integerSchema, "Maximum number of rows"),
+			},
+			Response: reflect.TypeOf([]FailedQuery{}),
+		},
+		{
+			Method: "GET", Path: "/api/columns", Summary: "Fields accepted by ?fields= on /api/queries",
+			Response: reflect.TypeOf([]string{}),
+		},
+		{
+			Method: "GET", Path: "/api/stream", Summary: "Server-Sent Events carrying the full failed query list as \"queries\" events",
+			Params:      filters[:1],
+			ContentType: "text/event-stream",
+		},
+	}
+}
+
+// openAPISchemas derives JSON schemas from Go types, collecting named structs
+// under components/schemas and referring to them by name
+type openAPISchemas map[string]interface{}
+
+func (s openAPISchemas) schemaFor(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return dateTimeSchema
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := s.schemaFor(t.Elem())
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		nullable := make(map[string]interface{}, len(schema)+1)
+		for k, v := range schema {
+			nullable[k] = v
+		}
+		nullable["nullable"] = true
+		return nullable
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schemaFor(t.Elem())}
+	case reflect.String:
+		return stringSchema
+	case reflect.Bool:
+		return booleanSchema
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return integerSchema
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return numberSchema
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.objectSchema(t)
+		}
+		if _, seen := s[t.Name()]; !seen {
+			s[t.Name()] = nil // Reserve the name so recursive types terminate
+			s[t.Name()] = s.objectSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// objectSchema lists a struct's exported fields under their json names;
+// fields without omitempty are always present, so they're marked required
+func (s openAPISchemas) objectSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.schemaFor(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// buildOpenAPISpec generates the OpenAPI 3 document served at /openapi.json
+func buildOpenAPISpec(apiKeysRequired bool) map[string]interface{} {
+	schemas := openAPISchemas{}
+	errorResponse := func(description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schemas.schemaFor(reflect.TypeOf(APIError{}))}},
+		}
+	}
+
+	paths := map[string]interface{}{}
+	for _, op := range openAPIOperations() {
+		params := make([]interface{}, 0, len(op.Params))
+		for _, p := range op.Params {
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"required":    p.In == "path",
+				"description": p.Description,
+				"schema":      p.Schema,
+			})
+		}
+
+		success := map[string]interface{}{"description": "OK"}
+		if op.Response != nil {
+			success["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": schemas.schemaFor(op.Response)}}
+		} else if op.ContentType != "" {
+			success["content"] = map[string]interface{}{op.ContentType: map[string]interface{}{"schema": stringSchema}}
+		}
+		responses := map[string]interface{}{
+			"200": success,
+			"400": errorResponse("Invalid parameter"),
+			"500": errorResponse("Internal server error"),
+		}
+		if apiKeysRequired {
+			responses["401"] = errorResponse("Missing or invalid API key")
+		}
+
+		operation := map[string]interface{}{
+			"summary":    op.Summary,
+			"parameters": params,
+			"responses":  responses,
+		}
+		if op.Body != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": schemas.schemaFor(op.Body)}},
+			}
+		}
+
+		item, _ := paths[op.Path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Snowflake Failed Queries Dashboard API",
+			"version": openAPIVersion,
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": map[string]interface{}(schemas)},
+	}
+	if apiKeysRequired {
+		components := spec["components"].(map[string]interface{})
+		components["securitySchemes"] = map[string]interface{}{
+			"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+		}
+		spec["security"] = []interface{}{
+			map[string]interface{}{"bearer": []string{}},
+			map[string]interface{}{"apiKey": []string{}},
+		}
+	}
+	return spec
+}
+
+var htmlTemplate = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Title}} - Last {{.LookbackHours}} Hours</title>
+    <link rel="icon" href="{{.FaviconURL}}">
+    <link rel="stylesheet" href="{{asset "dashboard.css"}}">
+</head>
+<body>
+    <header>
+        <button class="theme-toggle" id="theme-toggle" type="button" aria-pressed="false">🌙 Dark</button>
+        <a class="report-link" id="report-link" href="/report?account={{.Account}}" target="_blank">🖨️ Report</a>
+        <div class="container">
+            <h1>{{if .LogoURL}}<img class="header-logo" src="{{.LogoURL}}" alt="">{{else}}❄️{{end}} {{.Title}} - Last {{.LookbackHours}} Hours</h1>
+            <div class="overview hidden" id="overview"></div>
+            {{if gt (len .AccountList) 1}}
+            <div class="account-selector">
+                <label class="filter-label" for="account-filter">Account:</label>
+                <select id="account-filter" class="filter-select">
+                    {{range .AccountList}}
+                    <option value="{{.}}"{{if eq . $.Account}} selected{{end}}>{{.}}</option>
+                    {{end}}
+                </select>
+            </div>
+            {{end}}
+        </div>
+    </header>
+
+    <div class="container">
+        {{if .DatabaseList}}
+        <nav class="database-tabs" id="database-tabs">
+            <button class="database-tab{{if eq .Database ""}} active{{end}}" data-database="" onclick="selectDatabase(this)">All Databases</button>
+            {{range .DatabaseList}}
+            <button class="database-tab{{if eq . $.Database}} active{{end}}" data-database="{{.}}" onclick="selectDatabase(this)">{{.}}</button>
+            {{end}}
+        </nav>
+        {{end}}
+
+        <div class="stats">
+            <div class="stat-item">
+                <div class="stat-number stat-account" id="displayed-account">{{.Account}}</div>
+                <div class="stat-label">Snowflake Account</div>
+            </div>
+            <div class="stat-item">
+                <div class="stat-number" id="displayed-count">{{.Count}}</div>
+                <div class="stat-label">Failed Queries</div>
+            </div>
+            <div class="stat-item">
+                <div class="stat-number" id="displayed-users">{{.UniqueUsers}}</div>
+                <div class="stat-label">Unique Users</div>
+            </div>
+            <div class="stat-item{{if not .Comparison}} hidden{{end}}" id="comparison">
+                {{with .Comparison}}
+                <div class="stat-number stat-delta {{.DeltaClass}}" id="displayed-delta">{{.Delta}}</div>
+                <div class="stat-label">vs. <span id="previous-count">{{.PreviousCount}}</span> in Previous Period</div>
+                {{else}}
+                <div class="stat-number stat-delta" id="displayed-delta"></div>
+                <div class="stat-label">vs. <span id="previous-count"></span> in Previous Period</div>
+                {{end}}
+            </div>
+        </div>
+
+        <div class="trend hidden" id="trend">
+            <h2>Failure Trend</h2>
+            <svg class="trend-chart" id="trend-chart" viewBox="0 0 600 60" preserveAspectRatio="none" role="img" aria-label="Failed queries over time"></svg>
+            <div class="trend-caption">
+                <span id="trend-start"></span>
+                <span id="trend-summary"></span>
+                <span id="trend-end"></span>
+            </div>
+        </div>
+
+        <div class="trend hidden" id="heatmap">
+            <h2>Failures per Warehouse per Hour</h2>
+            <div class="heatmap-scroll">
+                <table class="heatmap-table" id="heatmap-table"></table>
+            </div>
+        </div>
+
+        <div class="trend hidden" id="hourly">
+            <h2>Failures by Hour of Day</h2>
+            <svg class="trend-chart" id="hourly-chart" viewBox="0 0 240 60" preserveAspectRatio="none" role="img" aria-label="Failed queries by hour of day"></svg>
+            <div class="trend-caption">
+                <span>00:00</span>
+                <span id="hourly-summary"></span>
+                <span>23:00</span>
+            </div>
+        </div>
+
+        <div class="top-users{{if not .TopUsers}} hidden{{end}}" id="top-users">
+            <h2>Top Users by Failures</h2>
+            <ol id="top-users-list">
+                {{range .TopUsers}}
+                <li><span class="top-user-name">{{.User}}</span><span class="top-user-count">{{.Count}}</span></li>
+                {{end}}
+            </ol>
+        </div>
+
+        <div class="duration-buckets{{if not .Count}} hidden{{end}}" id="duration-buckets">
+            <h2>Failures by Execution Time</h2>
+            {{range .DurationCounts}}
+            <button class="duration-bucket" data-bucket="{{.Name}}" onclick="selectDurationBucket(this)">{{.Label}}: <span class="duration-bucket-count">{{.Count}}</span></button>
+            {{end}}
+        </div>
+
+        {{if .OrganizationUsage}}
+        <div class="account-names{{if not .AccountNameCounts}} hidden{{end}}" id="account-names">
+            <h2>Failures by Account</h2>
+            <div id="account-name-list">
+                {{range .AccountNameCounts}}
+                <button class="account-name" data-account-name="{{.AccountName}}" onclick="selectAccountName(this)">{{.AccountName}}: <span class="account-name-count">{{.Count}}</span></button>
+                {{end}}
+            </div>
+        </div>
+        {{end}}
+
+        <div class="stale-notice{{if not (and .Freshness .Freshness.Stale)}} hidden{{end}}" id="stale-notice">
+            🕒 Data may be delayed up to 45 minutes (newest record: <span id="stale-newest">{{if .Freshness}}{{.Freshness.NewestRecord.Format "15:04"}}{{end}}</span>).
+        </div>
+
+        <div class="unavailable-notice{{if not .Unavailable}} hidden{{end}}" id="unavailable-notice">
+            ⏳ <span id="unavailable-message">{{.Unavailable}}</span>
+        </div>
+
+        {{if .AccessDenied}}
+        <div class="setup-notice">
+            <h2>🔒 No access to query history</h2>
+            <p>The role this dashboard connects with can't read <code>{{.AccessView}}</code>. {{.AccessGrantedBy}} can grant access with:</p>
+            <pre>{{.AccessGrant}}</pre>
+            <p>Use the role from <code>SNOWFLAKE_ROLE</code> (or <code>SNOWFLAKE_QUERY_ROLE</code> when set), then reload this page.</p>
+        </div>
+        {{end}}
+
+        <div class="limit-notice{{if lt .Count .RowLimit}} hidden{{end}}" id="limit-notice">
+            ⚠️ Showing the first <span id="limit-count">{{.Count}}</span> failed queries (row limit {{.RowLimit}}). Older failures in this window are not shown.
+        </div>
+
+        {{if .Queries}}
+            <div class="filter-container">
+                <div class="refresh-info">
+                    <div>
+                        <label class="filter-label" for="user-filter">Filter by User:</label>
+                        <select id="user-filter" class="filter-select">
+                            <option value="">All Users</option>
+                            {{range .UserList}}
+                            <option value="{{.}}">{{.}}</option>
+                            {{end}}
+                        </select>
+                        <label class="filter-label" for="user-kind-filter">Accounts:</label>
+                        <select id="user-kind-filter" class="filter-select" title="Service accounts match {{.ServiceAccountPattern}}">
+                            <option value="">All Accounts</option>
+                            <option value="{{.ServiceAccountPattern}}">Service Accounts</option>
+                            <option value="!{{.ServiceAccountPattern}}">Human Users</option>
+                        </select>
+                        <label class="filter-label" for="type-filter">Query Type:</label>
+                        <select id="type-filter" class="filter-select">
+                            <option value="">All Types</option>
+                            {{range .QueryTypeList}}
+                            <option value="{{.}}">{{.}}</option>
+                            {{end}}
+                        </select>
+                        {{if .OrganizationUsage}}
+                        <label class="filter-label" for="account-name-filter">Account:</label>
+                        <select id="account-name-filter" class="filter-select">
+                            <option value="">All Accounts</option>
+                            {{range .AccountNameCounts}}
+                            <option value="{{.AccountName}}">{{.AccountName}}</option>
+                            {{end}}
+                        </select>
+                        {{end}}
+                        <label class="filter-label" for="warehouse-filter">Warehouse:</label>
+                        <select id="warehouse-filter" class="filter-select">
+                            <option value="">All Warehouses</option>
+                            {{range .WarehouseList}}
+                            <option value="{{.}}">{{.}}</option>
+                            {{end}}
+                        </select>
+                        <label class="filter-label" for="category-filter">Category:</label>
+                        <select id="category-filter" class="filter-select">
+                            <option value="">All Categories</option>
+                            {{range .CategoryList}}
+                            <option value="{{.}}">{{.}}</option>
+                            {{end}}
+                        </select>
+                        {{if .AckEnabled}}
+                        <label class="filter-label"><input type="checkbox" id="hide-acknowledged"> Hide acknowledged</label>
+                        {{end}}
+                        <label class="filter-label"><input type="checkbox" id="include-cancellations"{{if .IncludeCancellations}} checked{{end}}> Include cancellations</label>
+                    </div>
+                    <div>
+                        <span class="last-updated" id="last-updated">Last updated: just now</span>
+                        {{if not .APILocked}}
+                        <span class="last-updated">· auto-refreshing every {{.RefreshIntervalSeconds}} seconds</span>
+                        <button class="refresh-button" id="refresh-button" onclick="refreshNow()">🔄 Refresh Now</button>
+                        {{end}}
+                    </div>
+                </div>
+                <div class="filter-row">
+                    <label class="filter-label" for="min-duration">Execution Time (s):</label>
+                    <input type="number" id="min-duration" class="filter-input" min="0" step="0.1" placeholder="min">
+                    –
+                    <input type="number" id="max-duration" class="filter-input" min="0" step="0.1" placeholder="max">
+                    <select id="duration-bucket-filter" class="filter-select">
+                        <option value="">Any Duration</option>
+                        {{range .DurationCounts}}
+                        <option value="{{.Name}}">{{.Label}}</option>
+                        {{end}}
+                    </select>
+                </div>
+                <div class="filter-row">
+                    <label class="filter-label" for="range-start">Time Range:</label>
+                    <input type="datetime-local" id="range-start" class="filter-input filter-datetime">
+                    –
+                    <input type="datetime-local" id="range-end" class="filter-input filter-datetime">
+                    <span class="last-updated">(local time; leave empty for the last {{.LookbackHours}} hours)</span>
+                </div>
+                <div class="filter-row">
+                    <span class="filter-label">Sort by:</span>
+                    <button class="sort-button active" data-sort="start_time" onclick="setSort(this)">Start Time<span class="sort-arrow"> ▼</span></button>
+                    <button class="sort-button" data-sort="execution_time" onclick="setSort(this)">Execution Time<span class="sort-arrow"></span></button>
+                    <button class="sort-button" data-sort="user_name" onclick="setSort(this)">User<span class="sort-arrow"></span></button>
+                </div>
+            </div>
+
+            <div id="queries-container">
+            {{range .Queries}}
+            <div class="query-card {{.CategoryClass}}{{if .Acknowledged}} acknowledged{{end}}" data-user="{{.UserName}}" data-query-type="{{.QueryType}}" data-category="{{.Category}}" data-query-id="{{.QueryID}}">
+                <div class="query-header">
+                    <span class="query-user">👤 {{.UserName}}<span class="category-badge">{{.Category}}</span></span>
+                    <span>
+                        <a class="query-id" href="/query/{{.QueryID}}?account={{$.Account}}">{{if .QueryType}}{{.QueryType}} · {{end}}ID: {{.QueryID}}</a>
+                        <a class="snowsight-link" href="{{$.SnowsightBaseURL}}/#/compute/history/queries/{{.QueryID}}/detail" target="_blank" rel="noopener noreferrer">Open in Snowflake ↗</a>
+                        {{if and $.AckEnabled (not $.APILocked)}}<button class="ack-button" onclick="toggleAck(this)">{{if .Acknowledged}}✓ Acknowledged{{else}}Acknowledge{{end}}</button>{{end}}
+                    </span>
+                </div>
+                <div class="query-header">
+                    {{if $.OrganizationUsage}}<span class="query-account">🏢 {{.AccountName}}</span>{{end}}
+                    {{if index $.VisibleColumns "start_time"}}<span class="query-time">⏰ {{.StartTime.Format "2006-01-02 15:04:05 MST"}}</span>{{end}}
+                    {{if index $.VisibleColumns "warehouse_name"}}<span class="query-warehouse">🏭 {{if .WarehouseName}}{{.WarehouseName}}{{else}}(no warehouse){{end}}</span>{{end}}
+                    {{if and .RoleName (index $.VisibleColumns "role_name")}}<span class="query-role">🎭 {{.RoleName}}</span>{{end}}
+                    {{if and .DatabaseName (index $.VisibleColumns "database_name")}}<span class="query-location">🗄️ {{.DatabaseName}}{{if .SchemaName}}.{{.SchemaName}}{{end}}</span>{{end}}
+                    {{if index $.VisibleColumns "bytes_scanned"}}<span class="query-cost">📦 {{.BytesScannedHuman}} scanned</span>{{end}}
+                    {{if index $.VisibleColumns "credits_used_cloud_services"}}<span class="query-cost">💳 {{printf "%.6f" .CreditsUsedCloudServices}} credits</span>{{end}}
+                    {{if index $.VisibleColumns "execution_time_seconds"}}<span class="execution-time">⚡ {{printf "%.2f" .ExecutionTime}}s</span>{{end}}
+                </div>
+                <div class="error-message">
+                    <strong>Error:</strong> {{if and .ErrorCode (index $.VisibleColumns "error_code")}}<span class="error-code">{{.ErrorCode}}</span>{{end}}{{.ErrorMessage}}
+                </div>
+                {{if index $.VisibleColumns "query_text"}}
+                <div class="query-text">
+                    <pre>{{.QueryText}}</pre>
+                    <button class="copy-button" onclick="copyCardQueryText(this)">📋 Copy SQL</button>
+                    {{if .QueryTextTruncated}}<button class="expand-button" onclick="expandQueryText(this)">Show full query</button>{{end}}
+                </div>
+                {{end}}
+            </div>
+            {{end}}
+            </div>
+        {{else if not (or .Unavailable .AccessDenied)}}
+            <div class="no-queries">
+                <h2>✅ No Failed Queries</h2>
+                <p>Great news! No failed queries in the last {{.LookbackHours}} hours.</p>
+            </div>
+        {{end}}
+    </div>
+
+    <footer class="version-footer">snowflake-dashboard {{.Version}}</footer>
+
+    <script>
+        // Auto-refresh configuration
+        const REFRESH_INTERVAL = {{.RefreshIntervalSeconds}} * 1000; // From REFRESH_INTERVAL_SECONDS
+        const LOOKBACK_HOURS = {{.LookbackHours}};
+        const ROW_LIMIT = {{.RowLimit}};
+        const ACCOUNT = {{.Account}};
+        const SNOWSIGHT_BASE_URL = {{.SnowsightBaseURL}};
+        const DISPLAY_TIMEZONE = {{.DisplayTimezone}};
+        const ACK_ENABLED = {{.AckEnabled}};
+        const API_LOCKED = {{.APILocked}}; // API_KEYS is set and this page has no session, so the API would refuse it
+        const ORGANIZATION_USAGE = {{.OrganizationUsage}}; // DATA_SOURCE=organization_usage: cards name their account
+        const TOP_USERS_LIMIT = {{.TopUsersLimit}};
+        const VISIBLE_COLUMNS = {{.VisibleColumns}}; // Optional card fields from VISIBLE_COLUMNS, e.g. {"query_text": true}
+        const UNAVAILABLE = {{.Unavailable}}; // Set when the page was rendered without data
+        const ACCESS_DENIED = {{.AccessDenied}}; // Polling can't help until the grant is made
+        const RETRY_AFTER = {{.RetryAfterSeconds}} * 1000;
+        const THEME_KEY = 'theme'; // localStorage key for an explicit light/dark choice
+        let refreshTimer = null;
+        let eventSource = null;
+        let lastUpdateTime = Date.now();
+        let isRefreshing = false;
+        let selectedDatabase = {{.Database}}; // Active MONITORED_DATABASES tab, kept in ?database=
+        const knownWarehouses = new Set({{.WarehouseList}});
+        const knownAccountNames = new Set([{{range $i, $a := .AccountNameCounts}}{{if $i}}, {{end}}{{$a.AccountName}}{{end}}]);
+    </script>
+    <script src="{{asset "dashboard.js"}}"></script>
+</body>
+</html>
+`
+
+// Cache-Control for the server-rendered pages, which carry live (and possibly sensitive)
+// data, and for /static/ assets, whose names change whenever their content does
+const (
+	pageCacheControl   = "private, no-cache"
+	staticCacheControl = "public, max-age=31536000, immutable"
+)
+
+// staticAsset is a file served from /static/ under a content-hashed name
+type staticAsset struct {
+	contentType string
+	body        []byte
+}
+
+// staticAssets is keyed by hashed file name (e.g. dashboard.3f2a9c1b4d5e.css), and
+// staticPaths maps each plain name to its URL for the templates' asset function
+var (
+	staticAssets = make(map[string]staticAsset)
+	staticPaths  = make(map[string]string)
+)
+
+// registerStaticAsset serves content under name with a hash of it before the extension
+func registerStaticAsset(name, contentType, content string) {
+	sum := sha256.Sum256([]byte(content))
+	ext := filepath.Ext(name)
+	hashed := strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:6]) + ext
+	staticAssets[hashed] = staticAsset{contentType: contentType, body: []byte(content)}
+	staticPaths[name] = "/static/" + hashed
+}
+
+// assetPath is the templates' asset function, returning the hashed URL of a static asset
+func assetPath(name string) (string, error) {
+	path, ok := staticPaths[name]
+	if !ok {
+		return "", fmt.Errorf("unknown static asset %q", name)
+	}
+	return path, nil
+}
+
+// dashboardCSS is the dashboard's stylesheet, served as a content-hashed static asset
+var dashboardCSS = `
+:root {
+    --bg: #f5f5f5;
+    --surface: white;
+    --surface-muted: #f0f0f0;
+    --surface-hover: #e0e0e0;
+    --code-bg: #f8f9fa;
+    --text: #333;
+    --muted: #666;
+    --header-bg: #29B5E8;
+    --shadow: rgba(0,0,0,0.1);
+    --error-bg: #fee;
+    --error-text: #c0392b;
+    --badge-bg: #ecf0f1;
+    --badge-text: #555;
+    --notice-bg: #fff8e1;
+    --notice-text: #8a6d3b;
+    --info-bg: #e3f2fd;
+    --info-text: #1f5f8b;
+}
+/* Dark colors apply when chosen with the toggle, or by default when the OS prefers them */
+:root[data-theme="dark"] {
+    color-scheme: dark;
+    --bg: #121417;
+    --surface: #1e2227;
+    --surface-muted: #2a2f36;
+    --surface-hover: #353b44;
+    --code-bg: #181b1f;
+    --text: #e4e6eb;
+    --muted: #a0a7b1;
+    --header-bg: #0f5f80;
+    --shadow: rgba(0,0,0,0.4);
+    --error-bg: #3a1f1f;
+    --error-text: #ff8a80;
+    --badge-bg: #2f353d;
+    --badge-text: #c9ced6;
+    --notice-bg: #3a3220;
+    --notice-text: #f0c674;
+    --info-bg: #1a2f3d;
+    --info-text: #8fd0f0;
+}
+@media (prefers-color-scheme: dark) {
+    :root:not([data-theme="light"]) {
+        color-scheme: dark;
+        --bg: #121417;
+        --surface: #1e2227;
+        --surface-muted: #2a2f36;
+        --surface-hover: #353b44;
+        --code-bg: #181b1f;
+        --text: #e4e6eb;
+        --muted: #a0a7b1;
+        --header-bg: #0f5f80;
+        --shadow: rgba(0,0,0,0.4);
+        --error-bg: #3a1f1f;
+        --error-text: #ff8a80;
+        --badge-bg: #2f353d;
+        --badge-text: #c9ced6;
+        --notice-bg: #3a3220;
+        --notice-text: #f0c674;
+        --info-bg: #1a2f3d;
+        --info-text: #8fd0f0;
+    }
+}
+* {
+    margin: 0;
+    padding: 0;
+    box-sizing: border-box;
+}
+body {
+    font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
+    background: var(--bg);
+    color: var(--text);
+    line-height: 1.6;
+}
+.container {
+    max-width: 1400px;
+    margin: 0 auto;
+    padding: 20px;
+}
+header {
+    position: relative;
+    background: var(--header-bg);
+    color: white;
+    padding: 30px 0;
+    margin-bottom: 30px;
+    box-shadow: 0 2px 4px var(--shadow);
+}
+.theme-toggle {
+    position: absolute;
+    top: 12px;
+    right: 20px;
+    padding: 4px 10px;
+    background: rgba(255,255,255,0.15);
+    color: white;
+    border: 1px solid rgba(255,255,255,0.6);
+    border-radius: 4px;
+    cursor: pointer;
+    font-size: 0.85em;
+}
+.report-link {
+    position: absolute;
+    top: 12px;
+    left: 20px;
+    padding: 4px 10px;
+    color: white;
+    border: 1px solid rgba(255,255,255,0.6);
+    border-radius: 4px;
+    font-size: 0.85em;
+    text-decoration: none;
+}
+header h1 {
+    text-align: center;
+    font-size: 2em;
+}
+.header-logo {
+    height: 1.2em;
+    vertical-align: middle;
+}
+.overview {
+    text-align: center;
+    margin-top: 8px;
+    opacity: 0.9;
+}
+.stats {
+    background: var(--surface);
+    padding: 20px;
+    margin-bottom: 20px;
+    border-radius: 8px;
+    box-shadow: 0 2px 4px var(--shadow);
+    display: flex;
+    justify-content: space-around;
+    flex-wrap: wrap;
+}
+.stat-item {
+    text-align: center;
+    padding: 10px 20px;
+}
+.stat-number {
+    font-size: 2em;
+    font-weight: bold;
+    color: #29B5E8;
+}
+.stat-label {
+    color: var(--muted);
+    font-size: 0.9em;
+}
+.stat-delta.delta-up {
+    color: #d32f2f;
+}
+.stat-delta.delta-down {
+    color: #388e3c;
+}
+.stat-delta.delta-flat {
+    color: var(--muted);
+}
+.top-users,
+.duration-buckets,
+.account-names {
+    background: var(--surface);
+    padding: 15px 20px;
+    margin-bottom: 20px;
+    border-radius: 8px;
+    box-shadow: 0 2px 4px var(--shadow);
+}
+.top-users h2,
+.duration-buckets h2,
+.account-names h2 {
+    font-size: 1em;
+    color: var(--muted);
+    margin-bottom: 10px;
+}
+.top-users ol {
+    padding-left: 25px;
+}
+.top-users li {
+    padding: 3px 0;
+}
+.top-user-count {
+    float: right;
+    font-weight: bold;
+    color: #e74c3c;
+}
+.trend {
+    background: var(--surface);
+    padding: 15px 20px;
+    margin-bottom: 20px;
+    border-radius: 8px;
+    box-shadow: 0 2px 4px var(--shadow);
+}
+.trend h2 {
+    font-size: 1em;
+    color: var(--muted);
+    margin-bottom: 10px;
+}
+.trend-chart {
+    display: block;
+    width: 100%;
+    height: 60px;
+}
+.trend-chart rect {
+    fill: #e74c3c;
+}
+.trend-chart line {
+    stroke: var(--muted);
+    stroke-width: 1;
+    vector-effect: non-scaling-stroke;
+}
+.trend-caption {
+    display: flex;
+    justify-content: space-between;
+    margin-top: 4px;
+    font-size: 0.75em;
+    color: var(--muted);
+}
+.heatmap-scroll {
+    overflow-x: auto;
+}
+.heatmap-table {
+    border-collapse: separate;
+    border-spacing: 1px;
+    font-size: 0.75em;
+    color: var(--muted);
+}
+.heatmap-table th {
+    font-weight: normal;
+    text-align: right;
+    padding-right: 6px;
+    white-space: nowrap;
+}
+.heatmap-table thead th {
+    text-align: left;
+    padding: 0;
+}
+.heatmap-table td {
+    width: 12px;
+    min-width: 12px;
+    height: 14px;
+    border-radius: 2px;
+}
+.heatmap-table td.heat-0 { background: var(--shadow); }
+.heatmap-table td.heat-1 { background: #f5b7b1; }
+.heatmap-table td.heat-2 { background: #ec7063; }
+.heatmap-table td.heat-3 { background: #e74c3c; }
+.heatmap-table td.heat-4 { background: #922b21; }
+.query-card {
+    background: var(--surface);
+    padding: 20px;
+    margin-bottom: 15px;
+    border-radius: 8px;
+    box-shadow: 0 2px 4px var(--shadow);
+    border-left: 4px solid #e74c3c;
+}
+.query-card.category-syntax { border-left-color: #e67e22; }
+.query-card.category-compilation { border-left-color: #f1c40f; }
+.query-card.category-permission-access { border-left-color: #8e44ad; }
+.query-card.category-timeout { border-left-color: #2980b9; }
+.query-card.category-resource-memory { border-left-color: #c0392b; }
+.query-card.category-other { border-left-color: #7f8c8d; }
+.category-badge {
+    display: inline-block;
+    margin-left: 8px;
+    padding: 2px 8px;
+    border-radius: 10px;
+    background: var(--badge-bg);
+    color: var(--badge-text);
+    font-size: 0.8em;
+}
+.query-card.acknowledged {
+    opacity: 0.55;
+    border-left-color: #27ae60;
+}
+.ack-button {
+    margin-left: 10px;
+    padding: 4px 10px;
+    background: var(--surface);
+    color: #27ae60;
+    border: 1px solid #27ae60;
+    border-radius: 4px;
+    cursor: pointer;
+    font-size: 0.85em;
+}
+.snowsight-link {
+    margin-left: 10px;
+    font-size: 0.85em;
+    color: #29B5E8;
+    white-space: nowrap;
+}
+.query-card.acknowledged .ack-button {
+    background: #27ae60;
+    color: white;
+}
+.query-header {
+    display: flex;
+    justify-content: space-between;
+    align-items: center;
+    margin-bottom: 15px;
+    flex-wrap: wrap;
+    gap: 10px;
+}
+.query-user {
+    font-weight: bold;
+    color: #29B5E8;
+    font-size: 1.1em;
+}
+.query-time {
+    color: var(--muted);
+    font-size: 0.9em;
+}
+.query-id {
+    font-family: monospace;
+    background: var(--surface-muted);
+    padding: 4px 8px;
+    border-radius: 4px;
+    font-size: 0.85em;
+    color: inherit;
+    text-decoration: none;
+}
+a.query-id:hover {
+    background: var(--surface-hover);
+}
+.error-message {
+    background: var(--error-bg);
+    border-left: 3px solid #e74c3c;
+    padding: 12px;
+    margin: 10px 0;
+    border-radius: 4px;
+    font-family: monospace;
+    font-size: 0.9em;
+    color: var(--error-text);
+}
+.error-code {
+    display: inline-block;
+    background: #e74c3c;
+    color: white;
+    padding: 1px 6px;
+    margin-right: 6px;
+    border-radius: 4px;
+    font-size: 0.85em;
+    font-weight: bold;
+}
+.query-text {
+    background: var(--code-bg);
+    padding: 15px;
+    border-radius: 4px;
+    margin: 10px 0;
+    overflow-x: auto;
+}
+.query-text pre {
+    font-family: 'Courier New', monospace;
+    font-size: 0.9em;
+    white-space: pre-wrap;
+    word-wrap: break-word;
+}
+.expand-button,
+.copy-button {
+    margin-top: 8px;
+    margin-right: 6px;
+    padding: 4px 10px;
+    background: var(--surface);
+    color: #29B5E8;
+    border: 1px solid #29B5E8;
+    border-radius: 4px;
+    cursor: pointer;
+    font-size: 0.85em;
+}
+.query-location,
+.query-warehouse,
+.query-role,
+.query-account {
+    color: var(--muted);
+    font-size: 0.9em;
+}
+.query-cost {
+    color: var(--muted);
+    font-size: 0.9em;
+}
+.execution-time {
+    display: inline-block;
+    background: #f39c12;
+    color: white;
+    padding: 4px 8px;
+    border-radius: 4px;
+    font-size: 0.85em;
+    font-weight: bold;
+}
+.no-queries {
+    text-align: center;
+    padding: 60px 20px;
+    background: var(--surface);
+    border-radius: 8px;
+    box-shadow: 0 2px 4px var(--shadow);
+}
+.no-queries h2 {
+    color: #27ae60;
+    margin-bottom: 10px;
+}
+.account-selector {
+    margin-top: 10px;
+}
+.account-selector .filter-label {
+    color: white;
+}
+.database-tabs {
+    display: flex;
+    flex-wrap: wrap;
+    gap: 4px;
+    margin-bottom: 20px;
+    border-bottom: 2px solid #29B5E8;
+}
+.database-tab {
+    padding: 8px 16px;
+    background: var(--surface);
+    color: var(--muted);
+    border: none;
+    border-radius: 6px 6px 0 0;
+    cursor: pointer;
+    font-weight: bold;
+}
+.database-tab.active {
+    background: #29B5E8;
+    color: white;
+}
+.stat-account {
+    font-size: 1.4em;
+    word-break: break-all;
+}
+.filter-container {
+    background: var(--surface);
+    padding: 20px;
+    margin-bottom: 20px;
+    border-radius: 8px;
+    box-shadow: 0 2px 4px var(--shadow);
+}
+.filter-label {
+    font-weight: bold;
+    margin-right: 10px;
+    color: var(--text);
+}
+.filter-select {
+    padding: 8px 12px;
+    font-size: 1em;
+    border: 2px solid #29B5E8;
+    border-radius: 4px;
+    background: var(--surface);
+    cursor: pointer;
+    min-width: 200px;
+}
+.filter-input {
+    padding: 8px 12px;
+    font-size: 1em;
+    border: 2px solid #29B5E8;
+    border-radius: 4px;
+    width: 110px;
+}
+.filter-datetime {
+    width: 220px;
+}
+.filter-row {
+    margin-top: 15px;
+}
+.filter-input:focus,
+.filter-select:focus {
+    outline: none;
+    border-color: #1a8ab8;
+    box-shadow: 0 0 0 3px rgba(41, 181, 232, 0.1);
+}
+.hidden {
+    display: none !important;
+}
+.refresh-info {
+    display: flex;
+    justify-content: space-between;
+    align-items: center;
+    flex-wrap: wrap;
+    gap: 10px;
+}
+.last-updated {
+    font-size: 0.9em;
+    color: var(--muted);
+}
+.refresh-button {
+    padding: 8px 16px;
+    background: #29B5E8;
+    color: white;
+    border: none;
+    border-radius: 4px;
+    cursor: pointer;
+    font-size: 0.9em;
+    font-weight: bold;
+}
+.refresh-button:hover {
+    background: #1a8ab8;
+}
+.sort-button,
+.duration-bucket,
+.account-name {
+    padding: 4px 10px;
+    background: var(--surface);
+    color: #29B5E8;
+    border: 1px solid #29B5E8;
+    border-radius: 4px;
+    cursor: pointer;
+    font-size: 0.85em;
+}
+.sort-button.active,
+.duration-bucket.active,
+.account-name.active {
+    background: #29B5E8;
+    color: white;
+}
+.refresh-button:active {
+    transform: scale(0.98);
+}
+.refreshing {
+    opacity: 0.6;
+}
+.limit-notice {
+    background: var(--notice-bg);
+    border-left: 4px solid #f39c12;
+    padding: 12px 20px;
+    margin-bottom: 20px;
+    border-radius: 8px;
+    color: var(--notice-text);
+}
+.setup-notice {
+    background: var(--notice-bg);
+    border-left: 4px solid #e74c3c;
+    padding: 12px 20px;
+    margin-bottom: 20px;
+    border-radius: 8px;
+    color: var(--notice-text);
+}
+.setup-notice h2 {
+    font-size: 1.1em;
+    margin-bottom: 8px;
+}
+.setup-notice p {
+    margin: 8px 0;
+}
+.setup-notice pre {
+    background: var(--surface);
+    padding: 10px;
+    border-radius: 4px;
+    overflow-x: auto;
+}
+.unavailable-notice,
+.stale-notice {
+    background: var(--info-bg);
+    border-left: 4px solid #29B5E8;
+    padding: 12px 20px;
+    margin-bottom: 20px;
+    border-radius: 8px;
+    color: var(--info-text);
+}
+.version-footer {
+    text-align: center;
+    padding: 20px;
+    font-size: 0.8em;
+    opacity: 0.6;
+}
+@media (max-width: 768px) {
+    .query-header {
+        flex-direction: column;
+        align-items: flex-start;
+    }
+    .filter-container {
+        text-align: center;
+    }
+    .filter-select {
+        margin-top: 10px;
+        width: 100%;
+    }
+}
+`
+
+// dashboardJS is the dashboard's script, served as a content-hashed static asset. It
+// runs after the page's inline script, whose constants carry the template values.
+var dashboardJS = `
+// Sorting is done by Snowflake; the default matches the server's (newest first)
+let sortField = 'start_time';
+let sortOrder = 'desc';
+const SORT_DEFAULT_ORDER = { start_time: 'desc', execution_time: 'desc', user_name: 'asc' };
+
+// Runs before DOMContentLoaded (this script is at the end of the body) to limit flashing
+initTheme();
+
+document.addEventListener('DOMContentLoaded', function() {
+    // Snowflake was briefly unavailable; try the whole page again
+    if (UNAVAILABLE) {
+        setTimeout(() => location.reload(), RETRY_AFTER);
+        return;
+    }
+    if (ACCESS_DENIED) return;
+
+    // Initialize filter functionality
+    initializeFilter();
+
+    // The report covers whatever filters are applied when it's opened
+    const reportLink = document.getElementById('report-link');
+    reportLink.addEventListener('click', () => {
+        reportLink.href = '/report?' + buildQueryParams().toString();
+    });
+
+    // Filtering the rendered cards still works; everything below calls the API
+    if (API_LOCKED) {
+        showUnavailable('Live updates need an API key (API_KEYS); reload the page to see new failures.');
+        updateTimestamp();
+        setInterval(updateTimestamp, 1000);
+        return;
+    }
+
+    // Start live updates (falls back to polling)
+    startLiveUpdates();
+
+    refreshTrend();
+    refreshHeatmap();
+    refreshHourlyDistribution();
+    refreshComparison();
+    refreshOverview();
+    refreshFreshness();
+
+    // Update "last updated" timestamp display
+    updateTimestamp();
+    setInterval(updateTimestamp, 1000);
+
+    // Pause/resume polling based on page visibility
+    document.addEventListener('visibilitychange', handleVisibilityChange);
+});
+
+function initializeFilter() {
+    // Switching accounts reloads the page so filters and stats start fresh
+    const accountFilter = document.getElementById('account-filter');
+    if (accountFilter) {
+        accountFilter.addEventListener('change', function() {
+            let url = '/?account=' + encodeURIComponent(accountFilter.value);
+            if (selectedDatabase) url += '&database=' + encodeURIComponent(selectedDatabase);
+            window.location.href = url;
+        });
+    }
+
+    const userFilter = document.getElementById('user-filter');
+    if (!userFilter) return;
+
+    userFilter.addEventListener('change', applyFilter);
+
+    const typeFilter = document.getElementById('type-filter');
+    if (typeFilter) typeFilter.addEventListener('change', applyFilter);
+
+    const categoryFilter = document.getElementById('category-filter');
+    if (categoryFilter) categoryFilter.addEventListener('change', applyFilter);
+
+    const hideAcknowledged = document.getElementById('hide-acknowledged');
+    if (hideAcknowledged) hideAcknowledged.addEventListener('change', applyFilter);
+
+    // Account kind, organization account, warehouse, duration bounds, and the time range are applied server-side, so changing them re-fetches
+    ['user-kind-filter', 'account-name-filter', 'warehouse-filter', 'min-duration', 'max-duration', 'duration-bucket-filter', 'range-start', 'range-end', 'include-cancellations'].forEach(function(id) {
+        const input = document.getElementById(id);
+        if (input) input.addEventListener('change', refreshData);
+    });
+}
+
+function buildQueryParams() {
+    const params = new URLSearchParams();
+    params.set('account', ACCOUNT);
+    if (selectedDatabase) params.set('database', selectedDatabase);
+    const userKindFilter = document.getElementById('user-kind-filter');
+    if (userKindFilter && userKindFilter.value) params.set('user_pattern', userKindFilter.value);
+    const accountNameFilter = document.getElementById('account-name-filter');
+    if (accountNameFilter && accountNameFilter.value) params.set('account_name', accountNameFilter.value);
+    const warehouseFilter = document.getElementById('warehouse-filter');
+    if (warehouseFilter && warehouseFilter.value) params.set('warehouse', warehouseFilter.value);
+    const minDuration = document.getElementById('min-duration');
+    const maxDuration = document.getElementById('max-duration');
+    if (minDuration && minDuration.value) params.set('min_duration', minDuration.value);
+    if (maxDuration && maxDuration.value) params.set('max_duration', maxDuration.value);
+    const durationBucketFilter = document.getElementById('duration-bucket-filter');
+    if (durationBucketFilter && durationBucketFilter.value) params.set('duration_bucket', durationBucketFilter.value);
+
+    // datetime-local values are in the browser's zone; send them as UTC
+    const rangeStart = document.getElementById('range-start');
+    const rangeEnd = document.getElementById('range-end');
+    if (rangeStart && rangeEnd && rangeStart.value && rangeEnd.value) {
+        params.set('start', new Date(rangeStart.value).toISOString());
+        params.set('end', new Date(rangeEnd.value).toISOString());
+    }
+
+    // Only sent when it differs from the server's default, so the live stream stays usable
+    const includeCancellations = document.getElementById('include-cancellations');
+    if (includeCancellations && includeCancellations.checked !== includeCancellations.defaultChecked) {
+        params.set('include_cancellations', includeCancellations.checked);
+    }
+
+    if (sortField !== 'start_time' || sortOrder !== 'desc') {
+        params.set('sort', sortField);
+        params.set('order', sortOrder);
+    }
+    return params;
+}
+
+// Switching tabs filters server-side and records the tab in the URL, so reloads and shared links keep it
+function selectDatabase(button) {
+    selectedDatabase = button.getAttribute('data-database');
+    document.querySelectorAll('.database-tab').forEach(function(tab) {
+        tab.classList.toggle('active', tab === button);
+    });
+
+    const url = new URL(window.location.href);
+    if (selectedDatabase) {
+        url.searchParams.set('database', selectedDatabase);
+    } else {
+        url.searchParams.delete('database');
+    }
+    history.replaceState(null, '', url);
+    refreshData();
+}
+
+// Clicking the active sort button flips the direction; another one switches to it
+function setSort(button) {
+    const field = button.getAttribute('data-sort');
+    if (field === sortField) {
+        sortOrder = sortOrder === 'desc' ? 'asc' : 'desc';
+    } else {
+        sortField = field;
+        sortOrder = SORT_DEFAULT_ORDER[field];
+    }
+
+    document.querySelectorAll('.sort-button').forEach(function(b) {
+        const active = b.getAttribute('data-sort') === sortField;
+        b.classList.toggle('active', active);
+        b.querySelector('.sort-arrow').textContent = active ? (sortOrder === 'desc' ? ' ▼' : ' ▲') : '';
+    });
+    refreshData();
+}
+
+function applyFilter() {
+    const userFilter = document.getElementById('user-filter');
+    const typeFilter = document.getElementById('type-filter');
+    const selectedUser = userFilter ? userFilter.value : '';
+    const selectedType = typeFilter ? typeFilter.value : '';
+    const categoryFilter = document.getElementById('category-filter');
+    const selectedCategory = categoryFilter ? categoryFilter.value : '';
+    const hideAcknowledged = document.getElementById('hide-acknowledged');
+    const hideAcked = hideAcknowledged ? hideAcknowledged.checked : false;
+
+    const queryCards = document.querySelectorAll('.query-card');
+    const displayedCount = document.getElementById('displayed-count');
+    const displayedUsers = document.getElementById('displayed-users');
+
+    let visibleCount = 0;
+    const visibleUsers = new Set();
+
+    queryCards.forEach(function(card) {
+        const cardUser = card.getAttribute('data-user');
+        const cardType = card.getAttribute('data-query-type');
+        const cardCategory = card.getAttribute('data-category');
+        if ((selectedUser === '' || cardUser === selectedUser) &&
+            (selectedType === '' || cardType === selectedType) &&
+            (selectedCategory === '' || cardCategory === selectedCategory) &&
+            !(hideAcked && card.classList.contains('acknowledged'))) {
+            card.classList.remove('hidden');
+            visibleCount++;
+            visibleUsers.add(cardUser);
+        } else {
+            card.classList.add('hidden');
+        }
+    });
+
+    // Update stats
+    if (displayedCount) displayedCount.textContent = visibleCount;
+    if (displayedUsers) displayedUsers.textContent = visibleUsers.size;
+}
+
+// Prefer server-pushed updates; poll when EventSource is unavailable
+function startLiveUpdates() {
+    if (!window.EventSource) {
+        startAutoRefresh();
+        return;
+    }
+    if (eventSource) return;
+
+    eventSource = new EventSource('/api/stream?account=' + encodeURIComponent(ACCOUNT));
+    eventSource.addEventListener('queries', function(event) {
+        // The stream carries the unfiltered window; server-side filters need their own fetch
+        if (hasServerSideFilters()) {
+            refreshData();
+            return;
+        }
+        updateDashboard(JSON.parse(event.data));
+        lastUpdateTime = Date.now();
+        updateTimestamp();
+    });
+    eventSource.addEventListener('error', function() {
+        // EventSource reconnects on its own unless the server refused the stream
+        if (eventSource && eventSource.readyState === EventSource.CLOSED) {
+            eventSource = null;
+            startAutoRefresh();
+        }
+    });
+}
+
+function stopLiveUpdates() {
+    if (eventSource) {
+        eventSource.close();
+        eventSource = null;
+    }
+    stopAutoRefresh();
+}
+
+function hasServerSideFilters() {
+    return Array.from(buildQueryParams().keys()).some(key => key !== 'account');
+}
+
+function startAutoRefresh() {
+    // Clear any existing timer
+    if (refreshTimer) {
+        clearInterval(refreshTimer);
+    }
+
+    // Set up interval to refresh every REFRESH_INTERVAL milliseconds
+    refreshTimer = setInterval(refreshData, REFRESH_INTERVAL);
+}
+
+function stopAutoRefresh() {
+    if (refreshTimer) {
+        clearInterval(refreshTimer);
+        refreshTimer = null;
+    }
+}
+
+// The button has the server re-query Snowflake first, so it doesn't just reload the last background poll
+function refreshNow() {
+    if (isRefreshing) return;
+
+    const refreshButton = document.getElementById('refresh-button');
+    if (refreshButton) {
+        refreshButton.disabled = true;
+        refreshButton.textContent = '⏳ Refreshing...';
+    }
+
+    fetch('/api/refresh?account=' + encodeURIComponent(ACCOUNT), { method: 'POST' })
+        .then(response => {
+            if (response.status === 401) {
+                throw new Error('Refreshing needs an API key; reload the page to see new failures.');
+            }
+            if (!response.ok) {
+                throw new Error('Refresh failed (HTTP ' + response.status + '); showing the last background refresh.');
+            }
+            refreshData();
+        })
+        .catch(error => {
+            console.error('Error forcing refresh:', error);
+            showUnavailable(error instanceof TypeError ? 'Refresh failed; check your connection and try again.' : error.message);
+            if (refreshButton) {
+                refreshButton.disabled = false;
+                refreshButton.textContent = '🔄 Refresh Now';
+            }
+        });
+}
+
+function refreshData() {
+    if (isRefreshing) return; // Prevent multiple simultaneous refreshes
+
+    isRefreshing = true;
+    const refreshButton = document.getElementById('refresh-button');
+    const container = document.getElementById('queries-container');
+
+    if (refreshButton) {
+        refreshButton.disabled = true;
+        refreshButton.textContent = '⏳ Refreshing...';
+    }
+
+    if (container) {
+        container.classList.add('refreshing');
+    }
+
+    // Fetch fresh data from API; long query text is fetched per card on demand
+    const params = buildQueryParams();
+    params.set('full_text', 'false');
+    fetch('/api/queries?' + params.toString())
+        .then(response => {
+            // Transient (e.g. warehouse resuming): keep the current cards and retry soon
+            if (response.status === 503) {
+                return response.json().then(body => {
+                    showUnavailable(body.error);
+                    setTimeout(refreshData, RETRY_AFTER);
+                    return null;
+                });
+            }
+            // API_KEYS is set and this page was loaded without a key: keep the server-rendered cards
+            if (response.status === 401) {
+                showUnavailable('Live updates need an API key; reload the page to see new failures.');
+                return null;
+            }
+            if (!response.ok) {
+                throw new Error('Failed to fetch data');
+            }
+            return response.json();
+        })
+        .then(data => {
+            if (data === null) return;
+            showUnavailable('');
+            updateDashboard(data);
+            lastUpdateTime = Date.now();
+            updateTimestamp();
+        })
+        .catch(error => {
+            console.error('Error refreshing data:', error);
+            // Don't stop auto-refresh on error, just log it
+        })
+        .finally(() => {
+            isRefreshing = false;
+            if (refreshButton) {
+                refreshButton.disabled = false;
+                refreshButton.textContent = '🔄 Refresh Now';
+            }
+            if (container) {
+                container.classList.remove('refreshing');
+            }
+        });
+}
+
+// Without a stored choice the stylesheet follows prefers-color-scheme by itself
+function initTheme() {
+    let theme = null;
+    try {
+        theme = localStorage.getItem(THEME_KEY);
+    } catch (e) {
+        // Storage can be disabled; fall back to the OS preference
+    }
+    if (theme === 'dark' || theme === 'light') {
+        document.documentElement.setAttribute('data-theme', theme);
+    }
+
+    const toggle = document.getElementById('theme-toggle');
+    if (toggle) toggle.addEventListener('click', toggleTheme);
+    updateThemeToggle();
+}
+
+function currentTheme() {
+    const chosen = document.documentElement.getAttribute('data-theme');
+    if (chosen) return chosen;
+    return window.matchMedia && window.matchMedia('(prefers-color-scheme: dark)').matches ? 'dark' : 'light';
+}
+
+function toggleTheme() {
+    const theme = currentTheme() === 'dark' ? 'light' : 'dark';
+    document.documentElement.setAttribute('data-theme', theme);
+    try {
+        localStorage.setItem(THEME_KEY, theme);
+    } catch (e) {
+        // The choice just won't survive a reload
+    }
+    updateThemeToggle();
+}
+
+// The button names the theme it switches to
+function updateThemeToggle() {
+    const toggle = document.getElementById('theme-toggle');
+    if (!toggle) return;
+    const dark = currentTheme() === 'dark';
+    toggle.textContent = dark ? '☀️ Light' : '🌙 Dark';
+    toggle.setAttribute('aria-pressed', dark ? 'true' : 'false');
+}
+
+// An empty message hides the banner
+function showUnavailable(message) {
+    const notice = document.getElementById('unavailable-notice');
+    const text = document.getElementById('unavailable-message');
+    if (!notice || !text) return;
+    text.textContent = message;
+    notice.classList.toggle('hidden', message === '');
+}
+
+function updateDashboard(queries) {
+    // Update query cards
+    updateQueryCards(queries);
+
+    // Update filter dropdowns (selections are preserved)
+    updateFilterOptions('user-filter', queries.map(q => q.user_name), 'All Users');
+    updateFilterOptions('type-filter', queries.map(q => q.query_type).filter(t => t), 'All Types');
+
+    // The warehouse filter narrows the fetched data itself, so options accumulate
+    // rather than shrinking to the selected warehouse
+    queries.forEach(q => knownWarehouses.add(q.warehouse_name || '(none)'));
+    updateFilterOptions('warehouse-filter', Array.from(knownWarehouses), 'All Warehouses');
+    if (ORGANIZATION_USAGE) {
+        queries.forEach(q => knownAccountNames.add(q.account_name));
+        updateFilterOptions('account-name-filter', Array.from(knownAccountNames), 'All Accounts');
+    }
+
+    // Update statistics
+    updateStatistics(queries);
+    refreshTrend();
+    refreshHeatmap();
+    refreshHourlyDistribution();
+    refreshComparison();
+    refreshOverview();
+    refreshFreshness();
+
+    // Re-apply current filters
+    applyFilter();
+}
+
+function updateQueryCards(queries) {
+    const container = document.getElementById('queries-container');
+    if (!container) return;
+
+    if (queries.length === 0) {
+        container.innerHTML = '<div class="no-queries"><h2>✅ No Failed Queries</h2><p>Great news! No failed queries in the last ' + LOOKBACK_HOURS + ' hours.</p></div>';
+        return;
+    }
+
+    let html = '';
+    queries.forEach(q => {
+        const startTime = new Date(q.start_time);
+        const timeStr = startTime.toLocaleString('en-US', {
+            year: 'numeric',
+            month: '2-digit',
+            day: '2-digit',
+            hour: '2-digit',
+            minute: '2-digit',
+            second: '2-digit',
+            timeZoneName: 'short',
+            timeZone: DISPLAY_TIMEZONE || undefined
+        });
+
+        html += '<div class="query-card ' + categoryClass(q.category) + (q.acknowledged ? ' acknowledged' : '') + '" data-user="' + escapeHtml(q.user_name) + '" data-query-type="' + escapeHtml(q.query_type) + '" data-category="' + escapeHtml(q.category) + '" data-query-id="' + escapeHtml(q.query_id) + '">' +
+            '<div class="query-header">' +
+                '<span class="query-user">👤 ' + escapeHtml(q.user_name) + '<span class="category-badge">' + escapeHtml(q.category) + '</span></span>' +
+                '<span>' +
+                    '<a class="query-id" href="/query/' + encodeURIComponent(q.query_id) + '?account=' + encodeURIComponent(ACCOUNT) + '">' + (q.query_type ? escapeHtml(q.query_type) + ' · ' : '') + 'ID: ' + escapeHtml(q.query_id) + '</a>' +
+                    '<a class="snowsight-link" href="' + escapeHtml(snowsightQueryURL(q.query_id)) + '" target="_blank" rel="noopener noreferrer">Open in Snowflake ↗</a>' +
+                    (ACK_ENABLED && !API_LOCKED ? '<button class="ack-button" onclick="toggleAck(this)">' + (q.acknowledged ? '✓ Acknowledged' : 'Acknowledge') + '</button>' : '') +
+                '</span>' +
+            '</div>' +
+            '<div class="query-header">' +
+                (ORGANIZATION_USAGE ? '<span class="query-account">🏢 ' + escapeHtml(q.account_name) + '</span>' : '') +
+                (VISIBLE_COLUMNS.start_time ? '<span class="query-time">⏰ ' + timeStr + '</span>' : '') +
+                (VISIBLE_COLUMNS.warehouse_name ? '<span class="query-warehouse">🏭 ' + (q.warehouse_name ? escapeHtml(q.warehouse_name) : '(no warehouse)') + '</span>' : '') +
+                (q.role_name && VISIBLE_COLUMNS.role_name ? '<span class="query-role">🎭 ' + escapeHtml(q.role_name) + '</span>' : '') +
+                (q.database_name && VISIBLE_COLUMNS.database_name ? '<span class="query-location">🗄️ ' + escapeHtml(q.database_name) + (q.schema_name ? '.' + escapeHtml(q.schema_name) : '') + '</span>' : '') +
+                (VISIBLE_COLUMNS.bytes_scanned ? '<span class="query-cost">📦 ' + formatBytes(q.bytes_scanned) + ' scanned</span>' : '') +
+                (VISIBLE_COLUMNS.credits_used_cloud_services ? '<span class="query-cost">💳 ' + q.credits_used_cloud_services.toFixed(6) + ' credits</span>' : '') +
+                (VISIBLE_COLUMNS.execution_time_seconds ? '<span class="execution-time">⚡ ' + q.execution_time_seconds.toFixed(2) + 's</span>' : '') +
+            '</div>' +
+            '<div class="error-message">' +
+                '<strong>Error:</strong> ' +
+                (q.error_code && VISIBLE_COLUMNS.error_code ? '<span class="error-code">' + escapeHtml(q.error_code) + '</span>' : '') +
+                escapeHtml(q.error_message) +
+            '</div>' +
+            (VISIBLE_COLUMNS.query_text ?
+            '<div class="query-text">' +
+                '<pre>' + escapeHtml(q.query_text) + '</pre>' +
+                '<button class="copy-button" onclick="copyCardQueryText(this)">📋 Copy SQL</button>' +
+                (q.query_text_truncated ? '<button class="expand-button" onclick="expandQueryText(this)">Show full query</button>' : '') +
+            '</div>' : '') +
+        '</div>';
+    });
+
+    container.innerHTML = html;
+}
+
+function updateFilterOptions(selectId, values, allLabel) {
+    const select = document.getElementById(selectId);
+    if (!select) return;
+
+    const currentValue = select.value;
+    const sortedValues = Array.from(new Set(values)).sort();
+
+    let html = '<option value="">' + escapeHtml(allLabel) + '</option>';
+    sortedValues.forEach(value => {
+        html += '<option value="' + escapeHtml(value) + '">' + escapeHtml(value) + '</option>';
+    });
+
+    select.innerHTML = html;
+    select.value = currentValue; // Restore selection
+}
+
+function updateStatistics(queries) {
+    const displayedCount = document.getElementById('displayed-count');
+    const displayedUsers = document.getElementById('displayed-users');
+
+    const uniqueUsers = new Set();
+    queries.forEach(q => uniqueUsers.add(q.user_name));
+
+    if (displayedCount) displayedCount.textContent = queries.length;
+    if (displayedUsers) displayedUsers.textContent = uniqueUsers.size;
+
+    // Warn when results were truncated by the row limit
+    const limitNotice = document.getElementById('limit-notice');
+    const limitCount = document.getElementById('limit-count');
+    if (limitNotice) limitNotice.classList.toggle('hidden', queries.length < ROW_LIMIT);
+    if (limitCount) limitCount.textContent = queries.length;
+
+    updateTopUsers(queries);
+    updateDurationBuckets(queries);
+    updateAccountNames(queries);
+}
+
+// Same ranking as the server: most failures first, ties by user name
+function updateTopUsers(queries) {
+    const section = document.getElementById('top-users');
+    const list = document.getElementById('top-users-list');
+    if (!section || !list) return;
+
+    const counts = new Map();
+    queries.forEach(q => counts.set(q.user_name, (counts.get(q.user_name) || 0) + 1));
+    const ranked = Array.from(counts.entries())
+        .sort((a, b) => b[1] - a[1] || (a[0] < b[0] ? -1 : a[0] > b[0] ? 1 : 0))
+        .slice(0, TOP_USERS_LIMIT);
+
+    list.innerHTML = ranked.map(([user, count]) =>
+        '<li><span class="top-user-name">' + escapeHtml(user) + '</span><span class="top-user-count">' + count + '</span></li>'
+    ).join('');
+    section.classList.toggle('hidden', ranked.length === 0);
+}
+
+// Recount the execution time buckets and highlight the one being filtered to
+function updateDurationBuckets(queries) {
+    const section = document.getElementById('duration-buckets');
+    if (!section) return;
+
+    const counts = new Map();
+    queries.forEach(q => counts.set(q.duration_bucket, (counts.get(q.duration_bucket) || 0) + 1));
+    const filter = document.getElementById('duration-bucket-filter');
+    section.querySelectorAll('.duration-bucket').forEach(function(button) {
+        const bucket = button.getAttribute('data-bucket');
+        button.querySelector('.duration-bucket-count').textContent = counts.get(bucket) || 0;
+        button.classList.toggle('active', filter !== null && filter.value === bucket);
+    });
+    section.classList.toggle('hidden', queries.length === 0 && !(filter && filter.value));
+}
+
+// Clicking a bucket filters to it; clicking the active one clears the filter
+function selectDurationBucket(button) {
+    const filter = document.getElementById('duration-bucket-filter');
+    if (!filter) return;
+    const bucket = button.getAttribute('data-bucket');
+    filter.value = filter.value === bucket ? '' : bucket;
+    refreshData();
+}
+
+// Regroup failures by organization account, most first like the server, highlighting the one filtered to
+function updateAccountNames(queries) {
+    const section = document.getElementById('account-names');
+    const list = document.getElementById('account-name-list');
+    if (!section || !list) return;
+
+    const counts = new Map();
+    queries.forEach(q => counts.set(q.account_name, (counts.get(q.account_name) || 0) + 1));
+    const ranked = Array.from(counts.entries())
+        .sort((a, b) => b[1] - a[1] || (a[0] < b[0] ? -1 : a[0] > b[0] ? 1 : 0));
+
+    const filter = document.getElementById('account-name-filter');
+    list.innerHTML = ranked.map(([name, count]) =>
+        '<button class="account-name' + (filter && filter.value === name ? ' active' : '') + '" data-account-name="' + escapeHtml(name) + '" onclick="selectAccountName(this)">' +
+            escapeHtml(name) + ': <span class="account-name-count">' + count + '</span></button>'
+    ).join('');
+    section.classList.toggle('hidden', ranked.length === 0);
+}
+
+// Clicking an account filters to it; clicking the active one clears the filter
+function selectAccountName(button) {
+    const filter = document.getElementById('account-name-filter');
+    if (!filter) return;
+    const name = button.getAttribute('data-account-name');
+    filter.value = filter.value === name ? '' : name;
+    refreshData();
+}
+
+// The trend covers the same window and server-side filters as the query list
+function refreshTrend() {
+    if (!document.getElementById('trend-chart')) return;
+
+    fetch('/api/trend?' + buildQueryParams().toString())
+        .then(response => {
+            if (!response.ok) {
+                throw new Error('Failed to fetch trend');
+            }
+            return response.json();
+        })
+        .then(renderTrend)
+        .catch(error => {
+            console.error('Error refreshing trend:', error);
+        });
+}
+
+// The heatmap covers the same window and server-side filters as the query list
+function refreshHeatmap() {
+    if (!document.getElementById('heatmap-table')) return;
+
+    fetch('/api/heatmap?' + buildQueryParams().toString())
+        .then(response => {
+            if (!response.ok) {
+                throw new Error('Failed to fetch heatmap');
+            }
+            return response.json();
+        })
+        .then(renderHeatmap)
+        .catch(error => {
+            console.error('Error refreshing heatmap:', error);
+        });
+}
+
+// The hour-of-day chart uses the zone timestamps are shown in, so its hours match the cards
+function refreshHourlyDistribution() {
+    if (!document.getElementById('hourly-chart')) return;
+
+    const params = buildQueryParams();
+    params.set('timezone', DISPLAY_TIMEZONE || Intl.DateTimeFormat().resolvedOptions().timeZone);
+    fetch('/api/hourly-distribution?' + params.toString())
+        .then(response => {
+            if (!response.ok) {
+                throw new Error('Failed to fetch hourly distribution');
+            }
+            return response.json();
+        })
+        .then(renderHourlyDistribution)
+        .catch(error => {
+            console.error('Error refreshing hourly distribution:', error);
+        });
+}
+
+// 24 SVG bars scaled to the worst hour, drawn like the trend chart
+function renderHourlyDistribution(distribution) {
+    const section = document.getElementById('hourly');
+    const chart = document.getElementById('hourly-chart');
+    if (!section || !chart) return;
+
+    const svgNS = 'http://www.w3.org/2000/svg';
+    const width = 240, height = 60;
+    const barWidth = width / 24;
+    const hourLabel = h => String(h).padStart(2, '0') + ':00';
+
+    while (chart.firstChild) chart.removeChild(chart.firstChild);
+
+    const baseline = document.createElementNS(svgNS, 'line');
+    baseline.setAttribute('x1', 0);
+    baseline.setAttribute('x2', width);
+    baseline.setAttribute('y1', height - 0.5);
+    baseline.setAttribute('y2', height - 0.5);
+    chart.appendChild(baseline);
+
+    distribution.counts.forEach((count, hour) => {
+        const barHeight = distribution.max > 0 ? (count / distribution.max) * (height - 2) : 0;
+        const bar = document.createElementNS(svgNS, 'rect');
+        bar.setAttribute('x', hour * barWidth);
+        bar.setAttribute('y', height - barHeight);
+        bar.setAttribute('width', barWidth - 1);
+        bar.setAttribute('height', barHeight);
+
+        const title = document.createElementNS(svgNS, 'title');
+        title.textContent = hourLabel(hour) + '–' + hourLabel(hour).replace(':00', ':59') + ': ' + count + ' failed';
+        bar.appendChild(title);
+        chart.appendChild(bar);
+    });
+
+    const worst = distribution.counts.indexOf(distribution.max);
+    document.getElementById('hourly-summary').textContent = distribution.max === 0
+        ? 'No failures in this window'
+        : 'Worst hour ' + hourLabel(worst) + ' (' + distribution.max + ' failed) · ' + distribution.timezone;
+    section.classList.remove('hidden');
+}
+
+// A plain table with one shaded cell per warehouse and hour; shades are CSS classes
+// (heat-0 to heat-4) so no inline styles are needed
+function renderHeatmap(heatmap) {
+    const section = document.getElementById('heatmap');
+    const table = document.getElementById('heatmap-table');
+    if (!section || !table) return;
+
+    const hourOf = hour => new Date(hour).toLocaleString('en-US', {
+        hour: '2-digit',
+        hourCycle: 'h23',
+        timeZone: DISPLAY_TIMEZONE || undefined
+    });
+    const label = hour => new Date(hour).toLocaleString('en-US', {
+        month: '2-digit',
+        day: '2-digit',
+        hour: '2-digit',
+        minute: '2-digit',
+        timeZoneName: 'short',
+        timeZone: DISPLAY_TIMEZONE || undefined
+    });
+
+    while (table.firstChild) table.removeChild(table.firstChild);
+
+    // Hour-of-day labels every six hours keep the header readable over long windows
+    const head = table.createTHead().insertRow();
+    head.appendChild(document.createElement('th'));
+    heatmap.hours.forEach(hour => {
+        const th = document.createElement('th');
+        const h = hourOf(hour);
+        th.textContent = Number(h) % 6 === 0 ? h : '';
+        head.appendChild(th);
+    });
+
+    const body = table.createTBody();
+    heatmap.warehouses.forEach((warehouse, i) => {
+        const row = body.insertRow();
+        const th = document.createElement('th');
+        th.textContent = warehouse;
+        row.appendChild(th);
+        heatmap.counts[i].forEach((count, j) => {
+            const cell = row.insertCell();
+            const level = count === 0 ? 0 : Math.ceil(count / heatmap.max * 4);
+            cell.className = 'heat-' + level;
+            cell.title = warehouse + ' · ' + label(heatmap.hours[j]) + ': ' + count + ' failed';
+        });
+    });
+    section.classList.toggle('hidden', heatmap.warehouses.length === 0);
+}
+
+// Failures vs. the preceding window of equal length, with the same server-side filters
+function refreshComparison() {
+    if (!document.getElementById('comparison')) return;
+
+    fetch('/api/comparison?' + buildQueryParams().toString())
+        .then(response => {
+            if (!response.ok) {
+                throw new Error('Failed to fetch comparison');
+            }
+            return response.json();
+        })
+        .then(renderComparison)
+        .catch(error => {
+            console.error('Error refreshing comparison:', error);
+        });
+}
+
+// Failure counts over the OVERVIEW_WINDOWS windows, e.g. "1h: 3 · 24h: 40 · 7d: 210"
+function refreshOverview() {
+    const overview = document.getElementById('overview');
+    if (!overview) return;
+
+    fetch('/api/overview?' + buildQueryParams().toString())
+        .then(response => {
+            if (!response.ok) {
+                throw new Error('Failed to fetch overview');
+            }
+            return response.json();
+        })
+        .then(counts => {
+            overview.textContent = counts.map(c => c.window + ': ' + c.count).join(' · ');
+            overview.classList.toggle('hidden', counts.length === 0);
+        })
+        .catch(error => {
+            console.error('Error refreshing overview:', error);
+        });
+}
+
+// Shows the "data may be delayed" banner while ACCOUNT_USAGE lags past STALE_DATA_THRESHOLD,
+// so an empty list isn't mistaken for no failures when the data just hasn't arrived
+function refreshFreshness() {
+    const notice = document.getElementById('stale-notice');
+    const newest = document.getElementById('stale-newest');
+    if (!notice || !newest) return;
+
+    fetch('/api/freshness?account=' + encodeURIComponent(ACCOUNT))
+        .then(response => {
+            if (!response.ok) {
+                throw new Error('Failed to fetch data freshness');
+            }
+            return response.json();
+        })
+        .then(freshness => {
+            if (!freshness) {
+                notice.classList.add('hidden');
+                return;
+            }
+            newest.textContent = new Date(freshness.newest_record).toLocaleTimeString('en-US', {
+                hour: '2-digit',
+                minute: '2-digit',
+                hourCycle: 'h23',
+                timeZone: DISPLAY_TIMEZONE || undefined
+            });
+            notice.classList.toggle('hidden', !freshness.stale);
+        })
+        .catch(error => {
+            console.error('Error refreshing data freshness:', error);
+        });
+}
+
+// Same formatting as the server's PeriodComparison.Delta and DeltaClass
+function renderComparison(c) {
+    const section = document.getElementById('comparison');
+    const delta = document.getElementById('displayed-delta');
+    const previous = document.getElementById('previous-count');
+    if (!section || !delta || !previous) return;
+
+    let text;
+    if (c.change_percent === null) {
+        text = c.current_count > 0 ? '▲ new' : '— 0%';
+    } else if (c.change_percent > 0) {
+        text = '▲ ' + Math.round(c.change_percent) + '%';
+    } else if (c.change_percent < 0) {
+        text = '▼ ' + Math.round(-c.change_percent) + '%';
+    } else {
+        text = '— 0%';
+    }
+
+    delta.textContent = text;
+    delta.classList.toggle('delta-up', c.current_count > c.previous_count);
+    delta.classList.toggle('delta-down', c.current_count < c.previous_count);
+    delta.classList.toggle('delta-flat', c.current_count === c.previous_count);
+    previous.textContent = c.previous_count;
+    section.classList.remove('hidden');
+}
+
+// Plain SVG bars, one per bucket, scaled to the busiest bucket
+function renderTrend(buckets) {
+    const section = document.getElementById('trend');
+    const chart = document.getElementById('trend-chart');
+    if (!section || !chart) return;
+
+    const svgNS = 'http://www.w3.org/2000/svg';
+    const width = 600, height = 60;
+    const max = buckets.reduce((m, b) => Math.max(m, b.count), 0);
+    const barWidth = width / Math.max(buckets.length, 1);
+
+    const label = start => new Date(start).toLocaleString('en-US', {
+        month: '2-digit',
+        day: '2-digit',
+        hour: '2-digit',
+        minute: '2-digit',
+        timeZoneName: 'short',
+        timeZone: DISPLAY_TIMEZONE || undefined
+    });
+
+    while (chart.firstChild) chart.removeChild(chart.firstChild);
+
+    // A baseline keeps the chart readable when every bucket is empty
+    const baseline = document.createElementNS(svgNS, 'line');
+    baseline.setAttribute('x1', 0);
+    baseline.setAttribute('x2', width);
+    baseline.setAttribute('y1', height - 0.5);
+    baseline.setAttribute('y2', height - 0.5);
+    chart.appendChild(baseline);
+
+    buckets.forEach((b, i) => {
+        const barHeight = max > 0 ? (b.count / max) * (height - 2) : 0;
+        const bar = document.createElementNS(svgNS, 'rect');
+        bar.setAttribute('x', i * barWidth);
+        bar.setAttribute('y', height - barHeight);
+        bar.setAttribute('width', Math.max(barWidth - 1, 0.5));
+        bar.setAttribute('height', barHeight);
+
+        const title = document.createElementNS(svgNS, 'title');
+        title.textContent = label(b.start) + ': ' + b.count + ' failed';
+        bar.appendChild(title);
+        chart.appendChild(bar);
+    });
+
+    // Text lives outside the SVG, which is stretched to fit (preserveAspectRatio="none")
+    const total = buckets.reduce((sum, b) => sum + b.count, 0);
+    document.getElementById('trend-start').textContent = buckets.length ? label(buckets[0].start) : '';
+    document.getElementById('trend-end').textContent = buckets.length ? label(buckets[buckets.length - 1].start) : '';
+    document.getElementById('trend-summary').textContent = total === 0
+        ? 'No failures in this window'
+        : total + ' failed · peak ' + max + ' per bucket';
+    section.classList.toggle('hidden', buckets.length === 0);
+}
+
+function updateTimestamp() {
+    const lastUpdated = document.getElementById('last-updated');
+    if (!lastUpdated) return;
+
+    const seconds = Math.floor((Date.now() - lastUpdateTime) / 1000);
+
+    if (seconds < 60) {
+        lastUpdated.textContent = 'Last updated: ' + seconds + ' second' + (seconds !== 1 ? 's' : '') + ' ago';
+    } else {
+        const minutes = Math.floor(seconds / 60);
+        lastUpdated.textContent = 'Last updated: ' + minutes + ' minute' + (minutes !== 1 ? 's' : '') + ' ago';
+    }
+}
+
+function handleVisibilityChange() {
+    if (document.hidden) {
+        // Page is hidden, stop updates to save resources
+        stopLiveUpdates();
+    } else {
+        // Page is visible again, resume updates
+        startLiveUpdates();
+        // The stream resends its latest data on connect; polling needs an explicit refresh
+        if (!eventSource) refreshData();
+    }
+}
+
+function toggleAck(button) {
+    const card = button.closest('.query-card');
+    const queryId = card.getAttribute('data-query-id');
+    const acknowledged = !card.classList.contains('acknowledged');
+
+    button.disabled = true;
+    fetch('/api/queries/' + encodeURIComponent(queryId) + '/ack', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ acknowledged: acknowledged })
+    })
+        .then(response => {
+            if (response.status === 401) {
+                throw new Error('Acknowledging needs an API key; reload the page with one to acknowledge failures.');
+            }
+            if (!response.ok) {
+                throw new Error('Failed to save acknowledgement (HTTP ' + response.status + ')');
+            }
+            card.classList.toggle('acknowledged', acknowledged);
+            button.textContent = acknowledged ? '✓ Acknowledged' : 'Acknowledge';
+            applyFilter();
+        })
+        .catch(error => {
+            console.error('Error acknowledging query:', error);
+            showUnavailable(error instanceof TypeError ? 'Failed to save acknowledgement; check your connection and try again.' : error.message);
+        })
+        .finally(() => {
+            button.disabled = false;
+        });
+}
+
+// Mirrors formatBytes in main.go (decimal units)
+// Replaces a card's query text preview with the full text
+function expandQueryText(button) {
+    const card = button.closest('.query-card');
+    if (!card) return;
+
+    button.disabled = true;
+    fullQueryText(card)
+        .catch(error => {
+            console.error('Error fetching full query text:', error);
+            button.disabled = false;
+        });
+}
+
+// Query profile page in Snowsight for one query
+function snowsightQueryURL(queryId) {
+    return SNOWSIGHT_BASE_URL + '/#/compute/history/queries/' + encodeURIComponent(queryId) + '/detail';
+}
+
+// Resolves to the card's complete SQL, fetching it first when only a preview is shown
+function fullQueryText(card) {
+    const pre = card.querySelector('.query-text pre');
+    const expandButton = card.querySelector('.expand-button');
+    if (!expandButton) return Promise.resolve(pre.textContent);
+
+    return fetch('/api/queries/' + encodeURIComponent(card.getAttribute('data-query-id')) + '?' + buildQueryParams().toString())
+        .then(response => {
+            if (!response.ok) {
+                throw new Error('Failed to fetch query text');
+            }
+            return response.json();
+        })
+        .then(q => {
+            pre.textContent = q.query_text;
+            expandButton.remove();
+            return q.query_text;
+        });
+}
+
+// textContent is the unescaped SQL, so quotes and newlines copy exactly as written
+function copyCardQueryText(button) {
+    const card = button.closest('.query-card');
+    if (!card) return;
+
+    fullQueryText(card)
+        .then(text => navigator.clipboard.writeText(text))
+        .then(() => {
+            button.textContent = '✅ Copied!';
+            setTimeout(() => { button.textContent = '📋 Copy SQL'; }, 2000);
+        })
+        .catch(error => {
+            console.error('Error copying query text:', error);
+            button.textContent = '⚠️ Copy failed';
+            setTimeout(() => { button.textContent = '📋 Copy SQL'; }, 2000);
+        });
+}
+
+// Mirrors categoryClass in main.go
+function categoryClass(category) {
+    return 'category-' + String(category || '').toLowerCase().replace(/[^a-z0-9]+/g, '-');
+}
+
+function formatBytes(n) {
+    if (n < 1000) return n + ' B';
+    const units = ['kB', 'MB', 'GB', 'TB', 'PB', 'EB'];
+    let value = n / 1000;
+    let i = 0;
+    while (value >= 1000 && i < units.length - 1) {
+        value /= 1000;
+        i++;
+    }
+    return value.toFixed(1) + ' ' + units[i];
+}
+
+function escapeHtml(text) {
+    const div = document.createElement('div');
+    div.textContent = text;
+    return div.innerHTML;
+}
+`
+
+var detailTemplate = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Failed Query {{.Query.QueryID}} - {{.Title}}</title>
+    <link rel="icon" href="{{.FaviconURL}}">
+    <style>
+        * {
+            margin: 0;
+            padding: 0;
+            box-sizing: border-box;
+        }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
+            background: #f5f5f5;
+            color: #333;
+            line-height: 1.6;
+        }
+        .container {
+            max-width: 1100px;
+            margin: 0 auto;
+            padding: 20px;
+        }
+        header {
+            background: #29B5E8;
+            color: white;
+            padding: 30px 0;
+            margin-bottom: 30px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        header h1 {
+            font-size: 1.6em;
+            word-break: break-all;
+        }
+        .header-logo {
+            height: 1.2em;
+            vertical-align: middle;
+        }
+        header a {
+            color: white;
+        }
+        .panel {
+            background: white;
+            padding: 20px;
+            margin-bottom: 20px;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        .metadata {
+            display: grid;
+            grid-template-columns: max-content 1fr;
+            gap: 8px 20px;
+        }
+        .metadata dt {
+            font-weight: bold;
+            color: #666;
+        }
+        .metadata dd {
+            font-family: monospace;
+            word-break: break-all;
+        }
+        .error-message {
+            background: #fee;
+            border-left: 3px solid #e74c3c;
+            padding: 12px;
+            border-radius: 4px;
+            font-family: monospace;
+            font-size: 0.9em;
+            color: #c0392b;
+        }
+        .query-text {
+            background: #f8f9fa;
+            padding: 15px;
+            border-radius: 4px;
+            margin-top: 10px;
+            overflow-x: auto;
+        }
+        .query-text pre {
+            font-family: 'Courier New', monospace;
+            font-size: 0.9em;
+            white-space: pre-wrap;
+            word-wrap: break-word;
+        }
+        .panel-header {
+            display: flex;
+            justify-content: space-between;
+            align-items: center;
+        }
+        .copy-button {
+            padding: 6px 14px;
+            background: #29B5E8;
+            color: white;
+            border: none;
+            border-radius: 4px;
+            cursor: pointer;
+            font-weight: bold;
+        }
+        .copy-button:hover {
+            background: #1a8ab8;
+        }
+        .metrics-status {
+            color: #666;
+        }
+    </style>
+</head>
+<body>
+    <header>
+        <div class="container">
+            <p><a href="/?account={{.Account}}">← Back to dashboard</a> · <a href="{{.SnowsightBaseURL}}/#/compute/history/queries/{{.Query.QueryID}}/detail" target="_blank" rel="noopener noreferrer">Open in Snowflake ↗</a></p>
+            <h1>{{if .LogoURL}}<img class="header-logo" src="{{.LogoURL}}" alt="">{{else}}❄️{{end}} Failed Query {{.Query.QueryID}}</h1>
+        </div>
+    </header>
+
+    <div class="container">
+        <div class="panel">
+            <dl class="metadata">
+                <dt>Account</dt><dd>{{.Account}}</dd>
+                {{if .Query.AccountName}}<dt>Organization Account</dt><dd>{{.Query.AccountName}}</dd>{{end}}
+                <dt>Query ID</dt><dd>{{.Query.QueryID}}</dd>
+                <dt>User</dt><dd>{{.Query.UserName}}</dd>
+                <dt>Query Type</dt><dd>{{if .Query.QueryType}}{{.Query.QueryType}}{{else}}—{{end}}</dd>
+                <dt>Warehouse</dt><dd>{{if .Query.WarehouseName}}{{.Query.WarehouseName}}{{else}}(no warehouse){{end}}</dd>
+                <dt>Role</dt><dd>{{if .Query.RoleName}}{{.Query.RoleName}}{{else}}—{{end}}</dd>
+                <dt>Database</dt><dd>{{if .Query.DatabaseName}}{{.Query.DatabaseName}}{{else}}—{{end}}</dd>
+                <dt>Schema</dt><dd>{{if .Query.SchemaName}}{{.Query.SchemaName}}{{else}}—{{end}}</dd>
+                <dt>Start Time</dt><dd>{{.Query.StartTime.Format "2006-01-02 15:04:05.000 MST"}}</dd>
+                <dt>End Time</dt><dd>{{.Query.EndTime.Format "2006-01-02 15:04:05.000 MST"}}</dd>
+                <dt>Execution Time</dt><dd>{{printf "%.3f" .Query.ExecutionTime}}s</dd>
+                <dt>Bytes Scanned</dt><dd>{{.Query.BytesScannedHuman}} ({{.Query.BytesScanned}} bytes)</dd>
+                <dt>Cloud Services Credits</dt><dd>{{printf "%.6f" .Query.CreditsUsedCloudServices}}</dd>
+                <dt>Error Code</dt><dd>{{if .Query.ErrorCode}}{{.Query.ErrorCode}}{{else}}—{{end}}</dd>
+                <dt>Category</dt><dd>{{.Query.Category}}</dd>
+            </dl>
+        </div>
+
+        <div class="panel">
+            <div class="error-message">
+                <strong>Error:</strong> {{.Query.ErrorMessage}}
+            </div>
+        </div>
+
+        {{if .MetricsEnabled}}
+        <div class="panel">
+            <strong>Execution Metrics</strong>
+            <p class="metrics-status" id="metrics-status">Loading…</p>
+            <dl class="metadata" id="metrics" hidden></dl>
+        </div>
+        {{end}}
+
+        <div class="panel">
+            <div class="panel-header">
+                <strong>Query Text</strong>
+                <button class="copy-button" id="copy-button" onclick="copyQueryText()">📋 Copy SQL</button>
+            </div>
+            <div class="query-text">
+                <pre id="query-text">{{.Query.QueryText}}</pre>
+            </div>
+        </div>
+    </div>
+
+    <script>
+        function copyQueryText() {
+            const text = document.getElementById('query-text').textContent;
+            const button = document.getElementById('copy-button');
+            navigator.clipboard.writeText(text)
+                .then(() => {
+                    button.textContent = '✅ Copied!';
+                    setTimeout(() => { button.textContent = '📋 Copy SQL'; }, 2000);
+                })
+                .catch(error => {
+                    console.error('Error copying query text:', error);
+                });
+        }
+        {{if .MetricsEnabled}}
+
+        function formatMs(ms) {
+            return ms >= 1000 ? (ms / 1000).toFixed(2) + 's' : ms + 'ms';
+        }
+
+        function formatBytes(n) {
+            const units = ['B', 'kB', 'MB', 'GB', 'TB', 'PB'];
+            let i = 0;
+            while (n >= 1000 && i < units.length - 1) {
+                n /= 1000;
+                i++;
+            }
+            return (i === 0 ? n : n.toFixed(1)) + ' ' + units[i];
+        }
+
+        // Metrics are a separate, slower query; the page is complete without them
+        function loadMetrics() {
+            const status = document.getElementById('metrics-status');
+            const list = document.getElementById('metrics');
+            fetch('/api/queries/' + encodeURIComponent({{.Query.QueryID}}) + '/metrics?account=' + encodeURIComponent({{.Account}}))
+                .then(response => {
+                    if (!response.ok) {
+                        throw new Error('Failed to fetch metrics: ' + response.status);
+                    }
+                    return response.json();
+                })
+                .then(m => {
+                    const partitions = m.partitions_total > 0
+                        ? m.partitions_scanned + ' of ' + m.partitions_total + ' (' + (100 * m.partitions_scanned / m.partitions_total).toFixed(1) + '%)'
+                        : '—';
+                    const rows = [
+                        ['Warehouse Size', m.warehouse_size || '—'],
+                        ['Compilation Time', formatMs(m.compilation_ms)],
+                        ['Execution Time', formatMs(m.execution_ms)],
+                        ['Queued (Provisioning)', formatMs(m.queued_provisioning_ms)],
+                        ['Queued (Repair)', formatMs(m.queued_repair_ms)],
+                        ['Queued (Overload)', formatMs(m.queued_overload_ms)],
+                        ['Partitions Scanned', partitions],
+                        ['Spilled to Local Storage', formatBytes(m.bytes_spilled_to_local_storage)],
+                        ['Spilled to Remote Storage', formatBytes(m.bytes_spilled_to_remote_storage)],
+                        ['Rows Produced', String(m.rows_produced)],
+                    ];
+                    list.replaceChildren();
+                    rows.forEach(([label, value]) => {
+                        const dt = document.createElement('dt');
+                        dt.textContent = label;
+                        const dd = document.createElement('dd');
+                        dd.textContent = value;
+                        list.append(dt, dd);
+                    });
+                    list.hidden = false;
+                    status.hidden = true;
+                })
+                .catch(error => {
+                    console.error('Error loading query metrics:', error);
+                    status.textContent = 'Execution metrics are not available for this query.';
+                });
+        }
+
+        loadMetrics();
+        {{end}}
+    </script>
+</body>
+</html>
+`
+
+// DetailPageData is the data rendered by detailTemplate
+type DetailPageData struct {
+	Branding
+
+	Query   FailedQuery
+	Account string
+
+	SnowsightBaseURL string
+
+	MetricsEnabled bool // The page fetches /api/queries/{id}/metrics once loaded
+}
+
+// reportTemplate is a static, print-friendly snapshot of a window for attaching to incident docs.
+// It has no auto-refresh or theme script, so "Print to PDF" captures exactly what was rendered.
+var reportTemplate = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Title}} Report - {{.Account}} - {{.GeneratedAt.Format "2006-01-02 15:04 MST"}}</title>
+    <link rel="icon" href="{{.FaviconURL}}">
+    <style>
+        * {
+            margin: 0;
+            padding: 0;
+            box-sizing: border-box;
+        }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
             background: white;
-            padding: 20px;
-            margin-bottom: 20px;
-            border-radius: 8px;
-            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+            color: #222;
+            line-height: 1.5;
+            font-size: 14px;
+        }
+        .container {
+            max-width: 1000px;
+            margin: 0 auto;
+            padding: 30px 20px;
+        }
+        h1 {
+            font-size: 1.6em;
+            border-bottom: 3px solid #29B5E8;
+            padding-bottom: 8px;
+            margin-bottom: 12px;
+        }
+        .header-logo {
+            height: 1.2em;
+            vertical-align: middle;
+        }
+        h2 {
+            font-size: 1.2em;
+            margin: 28px 0 10px;
+            color: #29B5E8;
+        }
+        .meta {
+            color: #555;
+        }
+        .print-button {
+            float: right;
+            padding: 6px 14px;
+            background: #29B5E8;
+            color: white;
+            border: none;
+            border-radius: 4px;
+            cursor: pointer;
+            font-weight: bold;
+        }
+        .stats {
             display: flex;
-            justify-content: space-around;
-            flex-wrap: wrap;
+            gap: 16px;
+            margin-top: 20px;
         }
-        .stat-item {
+        .stat {
+            flex: 1;
+            border: 1px solid #ddd;
+            border-radius: 6px;
+            padding: 12px;
             text-align: center;
-            padding: 10px 20px;
         }
         .stat-number {
-            font-size: 2em;
+            font-size: 1.8em;
             font-weight: bold;
-            color: #29B5E8;
         }
         .stat-label {
             color: #666;
-            font-size: 0.9em;
+            font-size: 0.85em;
         }
-        .query-card {
-            background: white;
-            padding: 20px;
-            margin-bottom: 15px;
-            border-radius: 8px;
-            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
-            border-left: 4px solid #e74c3c;
+        table {
+            width: 100%;
+            border-collapse: collapse;
         }
-        .query-header {
-            display: flex;
-            justify-content: space-between;
-            align-items: center;
-            margin-bottom: 15px;
-            flex-wrap: wrap;
-            gap: 10px;
+        th, td {
+            text-align: left;
+            vertical-align: top;
+            padding: 6px 8px;
+            border-bottom: 1px solid #e5e5e5;
         }
-        .query-user {
-            font-weight: bold;
-            color: #29B5E8;
-            font-size: 1.1em;
+        th {
+            background: #f3f3f3;
         }
-        .query-time {
-            color: #666;
-            font-size: 0.9em;
+        td.number {
+            text-align: right;
+            white-space: nowrap;
         }
-        .query-id {
+        .mono {
             font-family: monospace;
-            background: #f0f0f0;
-            padding: 4px 8px;
+            word-break: break-all;
+        }
+        .query {
+            border: 1px solid #ddd;
+            border-left: 4px solid #e74c3c;
             border-radius: 4px;
-            font-size: 0.85em;
+            padding: 10px 12px;
+            margin-bottom: 10px;
+            break-inside: avoid;
+        }
+        .query-meta {
+            color: #555;
+            font-size: 0.9em;
         }
         .error-message {
-            background: #fee;
-            border-left: 3px solid #e74c3c;
-            padding: 12px;
-            margin: 10px 0;
-            border-radius: 4px;
+            color: #c0392b;
             font-family: monospace;
             font-size: 0.9em;
-            color: #c0392b;
-        }
-        .query-text {
-            background: #f8f9fa;
-            padding: 15px;
-            border-radius: 4px;
-            margin: 10px 0;
-            overflow-x: auto;
+            margin: 6px 0;
         }
-        .query-text pre {
+        .query pre {
             font-family: 'Courier New', monospace;
-            font-size: 0.9em;
+            font-size: 0.85em;
+            background: #f8f9fa;
+            padding: 8px;
             white-space: pre-wrap;
             word-wrap: break-word;
         }
-        .execution-time {
-            display: inline-block;
-            background: #f39c12;
-            color: white;
-            padding: 4px 8px;
-            border-radius: 4px;
-            font-size: 0.85em;
-            font-weight: bold;
-        }
-        .no-queries {
-            text-align: center;
-            padding: 60px 20px;
-            background: white;
-            border-radius: 8px;
-            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
-        }
-        .no-queries h2 {
-            color: #27ae60;
-            margin-bottom: 10px;
-        }
-        .filter-container {
-            background: white;
-            padding: 20px;
-            margin-bottom: 20px;
-            border-radius: 8px;
-            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
-        }
-        .filter-label {
-            font-weight: bold;
-            margin-right: 10px;
-            color: #333;
-        }
-        .filter-select {
-            padding: 8px 12px;
-            font-size: 1em;
-            border: 2px solid #29B5E8;
-            border-radius: 4px;
-            background: white;
-            cursor: pointer;
-            min-width: 200px;
-        }
-        .filter-select:focus {
-            outline: none;
-            border-color: #1a8ab8;
-            box-shadow: 0 0 0 3px rgba(41, 181, 232, 0.1);
-        }
-        .hidden {
-            display: none !important;
-        }
-        .refresh-info {
-            display: flex;
-            justify-content: space-between;
-            align-items: center;
-            flex-wrap: wrap;
-            gap: 10px;
-        }
-        .last-updated {
-            font-size: 0.9em;
+        .note {
             color: #666;
+            font-style: italic;
         }
-        .refresh-button {
-            padding: 8px 16px;
-            background: #29B5E8;
-            color: white;
-            border: none;
-            border-radius: 4px;
-            cursor: pointer;
-            font-size: 0.9em;
-            font-weight: bold;
-        }
-        .refresh-button:hover {
-            background: #1a8ab8;
-        }
-        .refresh-button:active {
-            transform: scale(0.98);
-        }
-        .refreshing {
-            opacity: 0.6;
+        @page {
+            margin: 1.5cm;
         }
-        @media (max-width: 768px) {
-            .query-header {
-                flex-direction: column;
-                align-items: flex-start;
+        @media print {
+            .container {
+                max-width: none;
+                padding: 0;
             }
-            .filter-container {
-                text-align: center;
+            .print-button {
+                display: none;
             }
-            .filter-select {
-                margin-top: 10px;
-                width: 100%;
+            h2 {
+                break-after: avoid;
+            }
+            thead {
+                display: table-header-group;
+            }
+            tr {
+                break-inside: avoid;
+            }
+            * {
+                -webkit-print-color-adjust: exact;
+                print-color-adjust: exact;
             }
         }
     </style>
 </head>
 <body>
-    <header>
-        <div class="container">
-            <h1>❄️ Failed Snowflake Queries - Last 24 Hours</h1>
+    <div class="container">
+        {{if not .Email}}<button class="print-button" onclick="window.print()">🖨️ Print / Save as PDF</button>{{end}}
+        <h1>{{if .LogoURL}}<img class="header-logo" src="{{.LogoURL}}" alt="">{{else}}❄️{{end}} {{.Title}} Report</h1>
+        <p class="meta">
+            Account <strong>{{.Account}}</strong> ·
+            {{.WindowStart.Format "2006-01-02 15:04 MST"}} – {{.WindowEnd.Format "2006-01-02 15:04 MST"}} ·
+            generated {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}
+        </p>
+        {{if .Filters}}<p class="meta">Filters: {{range $i, $f := .Filters}}{{if $i}}, {{end}}<span class="mono">{{$f}}</span>{{end}}</p>{{end}}
+
+        <div class="stats">
+            <div class="stat">
+                <div class="stat-number">{{.Count}}{{if .Truncated}}+{{end}}</div>
+                <div class="stat-label">Failed Queries</div>
+            </div>
+            <div class="stat">
+                <div class="stat-number">{{.UniqueUsers}}</div>
+                <div class="stat-label">Unique Users</div>
+            </div>
+            {{if .Comparison}}
+            <div class="stat">
+                <div class="stat-number">{{.Comparison.Delta}}</div>
+                <div class="stat-label">vs. previous period ({{.Comparison.PreviousCount}})</div>
+            </div>
+            {{end}}
         </div>
-    </header>
 
-    <div class="container">
-        <div class="stats">
-            <div class="stat-item">
-                <div class="stat-number" id="displayed-count">{{.Count}}</div>
-                <div class="stat-label">Failed Queries</div>
-            </div>
-            <div class="stat-item">
-                <div class="stat-number" id="displayed-users">{{.UniqueUsers}}</div>
-                <div class="stat-label">Unique Users</div>
-            </div>
-        </div>
+        <h2>Top Errors</h2>
+        {{if .TopErrors}}
+        <table>
+            <thead>
+                <tr><th>Error Code</th><th>Sample Message</th><th>Failures</th><th>Users</th><th>Last Seen</th></tr>
+            </thead>
+            <tbody>
+                {{range .TopErrors}}
+                <tr>
+                    <td class="mono">{{if .ErrorCode}}{{.ErrorCode}}{{else}}—{{end}}</td>
+                    <td>{{.SampleMessage}}</td>
+                    <td class="number">{{.Count}}</td>
+                    <td class="number">{{.DistinctUsers}}</td>
+                    <td class="number">{{.LastSeen.Format "2006-01-02 15:04 MST"}}</td>
+                </tr>
+                {{end}}
+            </tbody>
+        </table>
+        {{else}}
+        <p class="note">No failures in this window.</p>
+        {{end}}
+
+        {{if not .Email}}
+        <h2>Top Users</h2>
+        {{if .TopUsers}}
+        <table>
+            <thead>
+                <tr><th>User</th><th>Failures</th></tr>
+            </thead>
+            <tbody>
+                {{range .TopUsers}}
+                <tr><td class="mono">{{.User}}</td><td class="number">{{.Count}}</td></tr>
+                {{end}}
+            </tbody>
+        </table>
+        {{else}}
+        <p class="note">No failures in this window.</p>
+        {{end}}
+        {{end}}
+
+        <h2>All Failed Queries</h2>
+        {{if .Truncated}}<p class="note">Only the first {{.Count}} failures are listed (QUERY_ROW_LIMIT).</p>{{end}}
+        {{range .Queries}}
+        <div class="query">
+            <div class="query-meta">
+                {{.StartTime.Format "2006-01-02 15:04:05 MST"}} · 👤 {{.UserName}} ·
+                🏭 {{if .WarehouseName}}{{.WarehouseName}}{{else}}(no warehouse){{end}} ·
+                {{if .RoleName}}🎭 {{.RoleName}} · {{end}}
+                {{if .QueryType}}{{.QueryType}} · {{end}}{{.Category}} ·
+                ⚡ {{printf "%.2f" .ExecutionTime}}s ·
+                <span class="mono">{{.QueryID}}</span>
+            </div>
+            <div class="error-message">{{if .ErrorCode}}[{{.ErrorCode}}] {{end}}{{.ErrorMessage}}</div>
+            <pre>{{.QueryText}}{{if .QueryTextTruncated}} …{{end}}</pre>
+        </div>
+        {{else}}
+        <p class="note">No failures in this window.</p>
+        {{end}}
+    </div>
+</body>
+</html>
+`
+
+// reportTopErrors is how many error codes the report's Top Errors table lists
+const reportTopErrors = 10
+
+// ReportPageData is the data rendered by reportTemplate
+type ReportPageData struct {
+	Branding
+
+	Account     string
+	Filters     []string // Active filters as name=value, for the report header
+	GeneratedAt time.Time
+	WindowStart time.Time
+	WindowEnd   time.Time
+
+	Count       int
+	Truncated   bool // The list stopped at the row limit
+	UniqueUsers int
+	Comparison  *PeriodComparison // nil if it couldn't be fetched
+
+	TopErrors []FailureSummary
+	TopUsers  []UserFailureCount
+	Queries   []FailedQuery
+
+	Email bool // Rendered as a digest email: no print button or Top Users table
+}
+
+type PageData struct {
+	Branding
+
+	Queries     []FailedQuery
+	Count       int
+	UniqueUsers int
+	UserList    []string
+
+	QueryTypeList []string
+	CategoryList  []string
+	WarehouseList []string // Includes "(none)" when some queries ran without a warehouse
+	LookbackHours int
+	RowLimit      int
+
+	Account     string   // Name of the account being shown
+	AccountList []string // All configured account names
+
+	SnowsightBaseURL string // Prefix for "Open in Snowflake" query profile links
+
+	DisplayTimezone string // IANA zone for JS-rendered timestamps ("" uses the browser's)
+
+	RefreshIntervalSeconds int
+	AckEnabled             bool // Whether acknowledgements can be recorded (ACK_DB_PATH set)
+
+	APILocked bool // API_KEYS is set and the page got no session, so Refresh Now, Acknowledge, and live updates are left out
+
+	IncludeCancellations bool // Initial state of the "Include cancellations" toggle (false when EXCLUDE_ERROR_CODES has 604)
+
+	ServiceAccountPattern string // USER_NAME pattern behind the service account / human toggle
+
+	VisibleColumns map[string]bool // Optional card fields to render (see cardColumns)
+
+	Database     string   // Selected ?database= tab ("" for all databases)
+	DatabaseList []string // One tab per MONITORED_DATABASES entry
+
+	TopUsers      []UserFailureCount // Users with the most failures, most first
+	TopUsersLimit int
+
+	DurationCounts []DurationBucketCount // Failures per execution time bucket, shortest first
+
+	OrganizationUsage bool               // DATA_SOURCE=organization_usage: cards name their account, grouped and filterable
+	AccountNameCounts []AccountNameCount // Failures per organization account, most first
+
+	Comparison *PeriodComparison // Failures vs. the preceding window (nil if it couldn't be fetched)
+
+	Freshness *DataFreshness // ACCOUNT_USAGE lag behind the "data may be delayed" banner (nil if unknown)
+
+	Unavailable       string // Set when Snowflake is temporarily unavailable (e.g. warehouse resuming)
+	RetryAfterSeconds int    // How long to wait before retrying after a 503
+
+	AccessDenied    bool   // The role can't read the DATA_SOURCE view; the page explains the grant instead
+	AccessGrant     string // The GRANT statement that fixes AccessDenied
+	AccessView      string // The DATA_SOURCE view that couldn't be read
+	AccessGrantedBy string // Who can run AccessGrant
+
+	Version string // Build version shown in the footer
+}
+
+// topUsersLimit is how many users the dashboard's leaderboard lists
+const topUsersLimit = 5
+
+// UserFailureCount is one row of the top-users leaderboard
+type UserFailureCount struct {
+	User  string
+	Count int
+}
+
+// AccountNameCount is how many failures one organization account had
+type AccountNameCount struct {
+	AccountName string
+	Count       int
+}
+
+// countByAccountName ranks organization accounts by failure count, ties broken by name.
+// It returns nil unless DATA_SOURCE=organization_usage.
+func countByAccountName(queries []FailedQuery) []AccountNameCount {
+	if !organizationUsage() {
+		return nil
+	}
+	counts := make(map[string]int)
+	for _, q := range queries {
+		counts[q.AccountName]++
+	}
+
+	ranked := make([]AccountNameCount, 0, len(counts))
+	for name, count := range counts {
+		ranked = append(ranked, AccountNameCount{AccountName: name, Count: count})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].AccountName < ranked[j].AccountName
+	})
+	return ranked
+}
+
+// topUsersByFailures ranks users by failure count (ties broken by name) and keeps the first n
+func topUsersByFailures(queries []FailedQuery, n int) []UserFailureCount {
+	counts := make(map[string]int)
+	for _, q := range queries {
+		counts[q.UserName]++
+	}
+
+	ranked := make([]UserFailureCount, 0, len(counts))
+	for user, count := range counts {
+		ranked = append(ranked, UserFailureCount{User: user, Count: count})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].User < ranked[j].User
+	})
+
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// summarizeFailures groups already-fetched queries by error code like getFailureSummary,
+// most frequent first, and keeps the first n
+func summarizeFailures(queries []FailedQuery, n int) []FailureSummary {
+	groups := make(map[string]*FailureSummary)
+	users := make(map[string]map[string]bool)
+	for _, q := range queries {
+		s, ok := groups[q.ErrorCode]
+		if !ok {
+			s = &FailureSummary{ErrorCode: q.ErrorCode, SampleMessage: q.ErrorMessage}
+			if s.SampleMessage == "" {
+				s.SampleMessage = noErrorMessage
+			}
+			groups[q.ErrorCode] = s
+			users[q.ErrorCode] = make(map[string]bool)
+		}
+		s.Count++
+		users[q.ErrorCode][q.UserName] = true
+		if q.StartTime.After(s.LastSeen) {
+			s.LastSeen = q.StartTime
+		}
+	}
+
+	summaries := make([]FailureSummary, 0, len(groups))
+	for code, s := range groups {
+		s.DistinctUsers = len(users[code])
+		summaries = append(summaries, *s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Count != summaries[j].Count {
+			return summaries[i].Count > summaries[j].Count
+		}
+		return summaries[i].LastSeen.After(summaries[j].LastSeen)
+	})
+
+	if len(summaries) > n {
+		summaries = summaries[:n]
+	}
+	return summaries
+}
+
+// defaultDigestTime is when digests go out each day, in DISPLAY_TIMEZONE (or UTC)
+const defaultDigestTime = "08:00"
+
+// digestSchedule is a daily send time limited to some weekdays; each digest covers
+// the failures since the previous scheduled send, so Monday's includes the weekend
+// when DIGEST_DAYS skips it
+type digestSchedule struct {
+	Hour, Minute int
+	Days         [7]bool // Indexed by time.Weekday
+}
+
+// parseDigestSchedule parses DIGEST_TIME (HH:MM) and DIGEST_DAYS (weekday names like
+// mon,tue; every day when empty)
+func parseDigestSchedule(at string, days []string) (digestSchedule, error) {
+	var schedule digestSchedule
+	if at == "" {
+		at = defaultDigestTime
+	}
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return schedule, fmt.Errorf("invalid DIGEST_TIME %q (must be HH:MM, e.g. 08:00)", at)
+	}
+	schedule.Hour, schedule.Minute = t.Hour(), t.Minute()
+
+	if len(days) == 0 {
+		for i := range schedule.Days {
+			schedule.Days[i] = true
+		}
+		return schedule, nil
+	}
+	for _, day := range days {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			name := d.String()
+			if strings.EqualFold(day, name) || strings.EqualFold(day, name[:3]) {
+				schedule.Days[d] = true
+				found = true
+			}
+		}
+		if !found {
+			return schedule, fmt.Errorf("invalid DIGEST_DAYS entry %q (must be weekday names like mon,tue,wed)", day)
+		}
+	}
+	return schedule, nil
+}
+
+// next returns the first scheduled send strictly after t, in t's location
+func (d digestSchedule) next(t time.Time) time.Time {
+	for i := 0; i <= 7; i++ {
+		candidate := time.Date(t.Year(), t.Month(), t.Day()+i, d.Hour, d.Minute, 0, 0, t.Location())
+		if candidate.After(t) && d.Days[candidate.Weekday()] {
+			return candidate
+		}
+	}
+	return t.AddDate(0, 0, 7) // Unreachable: parseDigestSchedule always enables a day
+}
+
+// prev returns the last scheduled send strictly before t, in t's location
+func (d digestSchedule) prev(t time.Time) time.Time {
+	for i := 0; i <= 7; i++ {
+		candidate := time.Date(t.Year(), t.Month(), t.Day()-i, d.Hour, d.Minute, 0, 0, t.Location())
+		if candidate.Before(t) && d.Days[candidate.Weekday()] {
+			return candidate
+		}
+	}
+	return t.AddDate(0, 0, -7)
+}
+
+// parseDigestEmails parses DIGEST_EMAILS entries of the form USER_NAME=address
+func parseDigestEmails(entries []string) (map[string]string, error) {
+	emails := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		user, address, ok := strings.Cut(entry, "=")
+		user = strings.TrimSpace(user)
+		if !ok || user == "" {
+			return nil, fmt.Errorf("invalid DIGEST_EMAILS entry %q (must be USER_NAME=address)", entry)
+		}
+		parsed, err := mail.ParseAddress(strings.TrimSpace(address))
+		if err != nil {
+			return nil, fmt.Errorf("invalid DIGEST_EMAILS address for %s: %w", user, err)
+		}
+		emails[strings.ToUpper(user)] = parsed.Address
+	}
+	return emails, nil
+}
+
+// digestEnabled reports whether there's both a mail server and a way to find addresses
+func digestEnabled(config *Config) bool {
+	return config.SMTP.Host != "" && (len(config.DigestEmails) > 0 || config.DigestEmailDomain != "")
+}
+
+// digestRecipient returns the address a user's digest goes to, or "" to skip them.
+// DIGEST_EMAILS wins; with DIGEST_EMAIL_DOMAIN set, email-style user names are used
+// as they are and other names get the domain appended.
+func digestRecipient(config *Config, user string) string {
+	if address, ok := config.DigestEmails[strings.ToUpper(user)]; ok {
+		return address
+	}
+	if config.DigestEmailDomain == "" {
+		return ""
+	}
+	address := user
+	if !strings.Contains(user, "@") {
+		address = strings.ToLower(user) + "@" + config.DigestEmailDomain
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return ""
+	}
+	return parsed.Address
+}
+
+// smtpTimeout bounds one message delivery, from dial to QUIT
+const smtpTimeout = 30 * time.Second
+
+// sendHTMLMail delivers one HTML email through the configured server. Authentication
+// is refused over an unencrypted connection to anything but localhost (smtp.PlainAuth).
+func sendHTMLMail(settings SMTPSettings, to, subject, body string) error {
+	addr := net.JoinHostPort(settings.Host, strconv.Itoa(settings.Port))
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	tlsConfig := &tls.Config{ServerName: settings.Host, MinVersion: tls.VersionTLS12}
+
+	var conn net.Conn
+	var err error
+	if settings.Port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(smtpTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, settings.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if settings.Port != 465 {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("STARTTLS failed: %w", err)
+			}
+		}
+	}
+	if settings.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", settings.Username, settings.Password, settings.Host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+	if err := client.Mail(settings.From.Address); err != nil {
+		return fmt.Errorf("SMTP server rejected the sender: %w", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("SMTP server rejected the recipient: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(htmlMessage(settings.From, to, subject, body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected the message: %w", err)
+	}
+	return client.Quit()
+}
+
+// htmlMessage builds a MIME message; the body is base64 so long HTML lines stay
+// within SMTP's line limit, and the subject is encoded so user names can't add headers
+func htmlMessage(from *mail.Address, to, subject, body string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from.String())
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+	return b.Bytes()
+}
+
+// runDigests sends the digests at each scheduled time, in DISPLAY_TIMEZONE (or UTC)
+func runDigests(accounts accountSet, config *Config, tmpl *template.Template) {
+	loc := config.DisplayLocation
+	if loc == nil {
+		loc = time.UTC
+	}
+	for {
+		run := config.DigestSchedule.next(time.Now().In(loc))
+		time.Sleep(time.Until(run))
+		for _, account := range accounts {
+			sendDigests(account, config, tmpl, config.DigestSchedule.prev(run), run)
+		}
+	}
+}
+
+// sendDigests emails each user with an address their own failures on one account
+// between start and end, rendered with the report template. Everything comes from
+// one Snowflake query per account.
+func sendDigests(account *accountConn, config *Config, tmpl *template.Template, start, end time.Time) {
+	opts := defaultQueryOptions(config)
+	opts.StartTime, opts.EndTime = start.UTC(), end.UTC()
+	opts.RowLimit = maxRowLimit
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	queries, _, err := account.failedQueries(ctx, opts, true)
+	cancel()
+	if err != nil {
+		log.Printf("Error fetching failures for digests on account %s: %v", account.name, err)
+		return
+	}
+	truncated := len(queries) >= opts.RowLimit
+
+	byUser := make(map[string][]FailedQuery)
+	for _, q := range queries {
+		byUser[q.UserName] = append(byUser[q.UserName], q)
+	}
+
+	sent, skipped := 0, 0
+	for user, userQueries := range byUser {
+		to := digestRecipient(config, user)
+		if to == "" {
+			skipped++
+			continue
+		}
+
+		var body bytes.Buffer
+		if err := tmpl.Execute(&body, ReportPageData{
+			Branding: config.Branding,
+
+			Account:     account.name,
+			Filters:     []string{"user=" + user},
+			GeneratedAt: time.Now().In(start.Location()),
+			WindowStart: start,
+			WindowEnd:   end,
+
+			Count:       len(userQueries),
+			Truncated:   truncated,
+			UniqueUsers: 1,
+
+			TopErrors: summarizeFailures(inDisplayLocation(userQueries, start.Location()), reportTopErrors),
+			Queries:   withQueryTextPreview(inDisplayLocation(userQueries, start.Location()), config.QueryTextPreviewChars),
+			Email:     true,
+		}); err != nil {
+			log.Printf("Error rendering digest for %s on account %s: %v", user, account.name, err)
+			continue
+		}
+
+		subject := fmt.Sprintf("%d failed Snowflake queries for %s on %s", len(userQueries), user, account.name)
+		if err := sendHTMLMail(config.SMTP, to, subject, body.String()); err != nil {
+			log.Printf("Error sending digest for %s on account %s: %v", user, account.name, err)
+			continue
+		}
+		sent++
+	}
+	log.Printf("Sent %d failure digests for account %s (%d users skipped without an email address)", sent, account.name, skipped)
+}
+
+// Build information, set at link time:
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// BuildInfo is the response of GET /version
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+}
+
+// validateSetup checks each account's credentials and connectivity without starting the
+// server (--validate), printing one line per account. It reports whether all of them passed.
+func validateSetup(config *Config) bool {
+	passed := true
+	for i := range config.Accounts {
+		account := &config.Accounts[i]
+
+		db, privateKey, err := getSnowflakeConnection(account, config.Pool, config.PingTimeout)
+		clearSensitiveData(account)
+		if err != nil {
+			fmt.Printf("FAIL  %s: %v\n", account.Name, err)
+			passed = false
+			continue
+		}
+		if privateKey != nil {
+			clearPrivateKey(privateKey)
+		}
+
+		// Report who the session really is, after any SNOWFLAKE_QUERY_ROLE / DASHBOARD_WAREHOUSE switch
+		ctx, cancel := context.WithTimeout(context.Background(), config.QueryTimeout)
+		var one int
+		var user, role, warehouse sql.NullString
+		err = db.QueryRowContext(ctx, "SELECT 1, CURRENT_USER(), CURRENT_ROLE(), CURRENT_WAREHOUSE()").Scan(&one, &user, &role, &warehouse)
+		cancel()
+		db.Close()
+		if err != nil {
+			fmt.Printf("FAIL  %s: connected, but SELECT 1 failed: %v\n", account.Name, err)
+			passed = false
+			continue
+		}
+		fmt.Printf("OK    %s: user %s, role %s, warehouse %s\n", account.Name, user.String, role.String, warehouse.String)
+	}
+	return passed
+}
+
+func main() {
+	configFile := flag.String("config", "", "path to a YAML config file (or set CONFIG_FILE)")
+	validate := flag.Bool("validate", false, "check the configuration and Snowflake connectivity, then exit (0 on success, 1 on failure)")
+	flag.Parse()
+
+	config, err := loadConfig(*configFile)
+	if *validate {
+		if err != nil {
+			fmt.Printf("FAIL  configuration: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("OK    configuration: %d account(s)\n", len(config.Accounts))
+		if !validateSetup(config) {
+			fmt.Println("Validation failed")
+			os.Exit(1)
+		}
+		fmt.Println("Validation passed")
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	queryRetryPolicy = RetryPolicy{MaxRetries: config.QueryRetries, BaseDelay: config.RetryBaseDelay}
+	queryTimeout = config.QueryTimeout
+	redactPatterns = config.RedactPatterns
+	maxQueryTextBytes = config.MaxQueryTextBytes
+	cspHeader = contentSecurityPolicy(config.Branding.logoOrigin())
+	historySource = config.DataSource
+
+	shutdownTracing, err := initTracing(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("Error flushing traces: %v", err)
+		}
+	}()
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		log.Printf("OpenTelemetry tracing enabled (exporting to %s)", endpoint)
+	}
+
+	log.Printf("snowflake-dashboard %s (commit %s, built %s)", version, commit, buildDate)
+	log.Printf("Snowflake timeouts: query %s, connection ping %s", config.QueryTimeout, config.PingTimeout)
+	log.Printf("Connection pool per account: max open %d, max idle %d, max lifetime %s, max idle time %s",
+		config.Pool.MaxOpenConns, config.Pool.MaxIdleConns, config.Pool.ConnMaxLifetime, config.Pool.ConnMaxIdleTime)
+
+	if organizationUsage() {
+		// Filled in per account below from the view's actual columns
+		unavailableHistoryColumns = make(map[string]bool)
+		log.Printf("Reading failures for every account in the organization from %s", historySource.View)
+	}
+
+	// Each account gets its own connection pool and result cache
+	accounts := make(accountSet, 0, len(config.Accounts))
+	for i := range config.Accounts {
+		account := &config.Accounts[i]
+
+		db, privateKey, err := connectWithRetry(account, config.Pool, config.PingTimeout, config.ConnectAttempts, config.ConnectRetryDelay)
+		if err != nil {
+			log.Fatalf("Failed to connect to Snowflake account %s: %v", account.Name, err)
+		}
+		defer db.Close()
+
+		// Security Fix #3: Clear sensitive data from memory after successful connection
+		clearSensitiveData(account)
+
+		// Clear private key material from memory after connection is established
+		// The key is no longer needed since the DB connection has been authenticated
+		if privateKey != nil {
+			clearPrivateKey(privateKey)
+		}
+
+		// ORGANIZATION_USAGE doesn't carry every ACCOUNT_USAGE column, so check what's there
+		// rather than fail every query. Without access the dashboard explains the grant, as
+		// it does for ACCOUNT_USAGE.
+		if organizationUsage() {
+			ctx, cancel := context.WithTimeout(context.Background(), config.QueryTimeout)
+			columns, err := probeHistoryColumns(ctx, db)
+			cancel()
+			switch {
+			case isAccountUsageAccessError(err):
+				log.Printf("Warning: account %s cannot read %s, which requires the ORGADMIN role. %s can grant it with %s; then set SNOWFLAKE_ROLE or SNOWFLAKE_QUERY_ROLE to ORGADMIN (%v)",
+					account.Name, historySource.View, historySource.GrantedBy, historySource.Grant, err)
+			case err != nil:
+				// queryWithRetry already retried transient failures; without the column set every
+				// query could name a column the view lacks, so stop as for a missing required column
+				log.Fatalf("DATA_SOURCE=organization_usage for account %s: %v", account.Name, err)
+			default:
+				missing, err := missingHistoryColumns(columns)
+				if err != nil {
+					log.Fatalf("DATA_SOURCE=organization_usage for account %s: %v", account.Name, err)
+				}
+				for column := range missing {
+					unavailableHistoryColumns[column] = true
+				}
+			}
+		}
+
+		accounts = append(accounts, &accountConn{
+			name:      account.Name,
+			db:        db,
+			cache:     newQueryCache(db, config.CacheTTL),
+			snapshot:  newQuerySnapshot(defaultQueryOptions(config)),
+			facets:    newFacetCache(db),
+			freshness: newFreshnessCache(db),
+			stream:    newQueryStream(),
+			uiBaseURL: account.UIBaseURL,
+		})
+		log.Printf("Connected to Snowflake account %s", account.Name)
+		if account.DashboardWarehouse != "" {
+			log.Printf("Dashboard queries for account %s run on warehouse %s", account.Name, account.DashboardWarehouse)
+		}
+	}
+
+	if organizationUsage() && len(unavailableHistoryColumns) > 0 {
+		missing := slices.Sorted(maps.Keys(unavailableHistoryColumns))
+		log.Printf("%s has no %s; those fields are left empty", historySource.View, strings.Join(missing, ", "))
+	}
+
+	// Security Fix #4: Go's html/template automatically escapes all interpolated values
+	// to prevent XSS attacks. This includes QueryText, ErrorMessage, UserName, etc.
+	// The template engine escapes HTML, JavaScript, CSS, and URL contexts automatically.
+	registerStaticAsset("dashboard.css", "text/css; charset=utf-8", dashboardCSS)
+	registerStaticAsset("dashboard.js", "text/javascript; charset=utf-8", dashboardJS)
+	tmpl, err := template.New("dashboard").Funcs(template.FuncMap{"asset": assetPath}).Parse(htmlTemplate)
+	if err != nil {
+		log.Fatalf("Failed to parse template: %v", err)
+	}
+	detailTmpl, err := template.New("detail").Parse(detailTemplate)
+	if err != nil {
+		log.Fatalf("Failed to parse detail template: %v", err)
+	}
+	reportTmpl, err := template.New("report").Parse(reportTemplate)
+	if err != nil {
+		log.Fatalf("Failed to parse report template: %v", err)
+	}
+
+	if config.CacheTTL > 0 {
+		log.Printf("Query result caching enabled (TTL %s)", config.CacheTTL)
+	}
+
+	var acks *AckStore
+	if config.AckDBPath != "" {
+		acks, err = openAckStore(config.AckDBPath)
+		if err != nil {
+			log.Fatalf("Failed to open acknowledgement store: %v", err)
+		}
+		defer acks.Close()
+		log.Printf("Acknowledgements stored in %s", config.AckDBPath)
+	}
+
+	// One background refresher per account serves every unfiltered request, and one
+	// poller per account feeds every open /api/stream connection from it
+	refreshInterval := time.Duration(config.RefreshIntervalSeconds) * time.Second
+	for _, account := range accounts {
+		go account.snapshot.run(account.name, account.db, refreshInterval)
+		go account.stream.run(account, defaultQueryOptions(config), refreshInterval, acks, config.QueryTextPreviewChars)
+	}
+
+	var history *HistoryStore
+	if config.HistoryDBPath != "" {
+		history, err = openHistoryStore(config.HistoryDBPath)
+		if err != nil {
+			log.Fatalf("Failed to open history store: %v", err)
+		}
+		defer history.Close()
+
+		for _, account := range accounts {
+			go recordHistory(account, history, defaultQueryOptions(config), config.HistoryPollInterval)
+		}
+		log.Printf("Recording failure history to %s (polling every %s)", config.HistoryDBPath, config.HistoryPollInterval)
+	}
+
+	if config.KeepAlive {
+		for _, account := range accounts {
+			go keepWarm(account, config.KeepAliveInterval)
+		}
+		log.Printf("Keep-alive enabled (SELECT 1 every %s per account)", config.KeepAliveInterval)
+		if config.KeepAliveInterval >= config.Pool.ConnMaxIdleTime {
+			log.Printf("Warning: KEEPALIVE_INTERVAL (%s) is not shorter than DB_CONN_MAX_IDLE_TIME (%s), so idle connections are still closed between keep-alive queries", config.KeepAliveInterval, config.Pool.ConnMaxIdleTime)
+		}
+	}
+
+	if digestEnabled(config) {
+		go runDigests(accounts, config, reportTmpl)
+		log.Printf("Failure digests enabled (%02d:%02d on the scheduled days, via %s)", config.DigestSchedule.Hour, config.DigestSchedule.Minute, config.SMTP.Host)
+	} else if config.SMTP.Host != "" {
+		log.Printf("Warning: SMTP_HOST is set but neither DIGEST_EMAILS nor DIGEST_EMAIL_DOMAIN is, so no digests will be sent")
+	}
+
+	notifiers := enabledNotifiers(config)
+	for _, n := range notifiers {
+		log.Printf("%s alerting enabled (polling every %s)", n.Name(), config.AlertInterval)
+	}
+	if len(notifiers) == 0 {
+		log.Println("No alert notifiers configured (SLACK_WEBHOOK_URL, TEAMS_WEBHOOK_URL, ALERT_WEBHOOK_URL), alerting disabled")
+	}
+	var pager *pagerDutyAlerter
+	if config.PagerDutyRoutingKey != "" {
+		pager = newPagerDutyAlerter(config.PagerDutyRoutingKey, config.AlertThreshold)
+		log.Printf("PagerDuty paging enabled (more than %d new failures per %s poll)", config.AlertThreshold, config.AlertInterval)
+	}
+	if len(notifiers) > 0 || pager != nil {
+		for _, account := range accounts {
+			go pollForNewFailures(account, defaultQueryOptions(config), config.AlertInterval, notifiers, pager)
+		}
+	}
+
+	if len(config.CORSAllowedOrigins) > 0 {
+		log.Printf("CORS enabled for the JSON API (origins: %s)", strings.Join(config.CORSAllowedOrigins, ", "))
+	}
+	if len(config.APIKeys) > 0 {
+		log.Printf("API key authentication enabled for /api/ (%d keys)", len(config.APIKeys))
+	}
+
+	var limiter *ipRateLimiter
+	if config.RateLimitPerMinute > 0 {
+		limiter = newIPRateLimiter(config.RateLimitPerMinute, config.RateLimitBurst, config.TrustedProxies)
+		go limiter.evictIdle(time.Minute)
+		log.Printf("Rate limiting enabled: %d requests per minute per client IP (burst %d, %d trusted proxy ranges)",
+			config.RateLimitPerMinute, config.RateLimitBurst, len(config.TrustedProxies))
+	}
+
+	http.HandleFunc("/", securityHeaders(gzipResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", pageCacheControl)
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fresh, err := parseFreshParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts := defaultQueryOptions(config)
+		if opts.DatabaseName, err = parseDatabaseParam(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		queries, hit, err := account.failedQueries(ctx, opts, fresh)
+		if err != nil {
+			// Show a banner instead of an error page; the page reloads itself after Retry-After
+			if msg, ok := transientErrorMessage(err); ok {
+				requestLogger(r.Context()).Warn("Snowflake temporarily unavailable", "error", err)
+				w.Header().Set("Retry-After", strconv.Itoa(int(config.WarehouseRetryAfter.Seconds())))
+				w.WriteHeader(http.StatusServiceUnavailable)
+				data := PageData{
+					Branding: config.Branding,
+
+					LookbackHours:          config.LookbackHours,
+					RowLimit:               config.RowLimit,
+					Account:                account.name,
+					AccountList:            accounts.names(),
+					SnowsightBaseURL:       account.uiBaseURL,
+					DisplayTimezone:        displayTimezone(config.DisplayLocation),
+					RefreshIntervalSeconds: config.RefreshIntervalSeconds,
+					VisibleColumns:         config.VisibleColumns,
+					Database:               opts.DatabaseName,
+					DatabaseList:           config.MonitoredDatabases,
+					OrganizationUsage:      organizationUsage(),
+					Unavailable:            msg,
+					RetryAfterSeconds:      int(config.WarehouseRetryAfter.Seconds()),
+					Version:                version,
+				}
+				if err := tmpl.Execute(w, data); err != nil {
+					requestLogger(r.Context()).Error("Error executing template", "error", err)
+				}
+				return
+			}
+			// Explain the missing grant on the page instead of a bare 500
+			if isAccountUsageAccessError(err) {
+				requestLogger(r.Context()).Error("No access to "+historySource.View+"; grant it with "+historySource.Grant, "error", err)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				data := PageData{
+					Branding: config.Branding,
+
+					LookbackHours:          config.LookbackHours,
+					RowLimit:               config.RowLimit,
+					Account:                account.name,
+					AccountList:            accounts.names(),
+					SnowsightBaseURL:       account.uiBaseURL,
+					DisplayTimezone:        displayTimezone(config.DisplayLocation),
+					RefreshIntervalSeconds: config.RefreshIntervalSeconds,
+					VisibleColumns:         config.VisibleColumns,
+					Database:               opts.DatabaseName,
+					DatabaseList:           config.MonitoredDatabases,
+					OrganizationUsage:      organizationUsage(),
+					AccessDenied:           true,
+					AccessGrant:            historySource.Grant,
+					AccessView:             historySource.View,
+					AccessGrantedBy:        historySource.GrantedBy,
+					Version:                version,
+				}
+				if err := tmpl.Execute(w, data); err != nil {
+					requestLogger(r.Context()).Error("Error executing template", "error", err)
+				}
+				return
+			}
+			// Security Fix #6: Return generic error to client, log details server-side
+			http.Error(w, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching queries", "error", err)
+			return
+		}
+
+		uniqueUsers := make(map[string]bool)
+		uniqueTypes := make(map[string]bool)
+		uniqueWarehouses := make(map[string]bool)
+		for _, q := range queries {
+			uniqueUsers[q.UserName] = true
+			if q.QueryType != "" {
+				uniqueTypes[q.QueryType] = true
+			}
+			if q.WarehouseName != "" {
+				uniqueWarehouses[q.WarehouseName] = true
+			} else {
+				uniqueWarehouses[noWarehouse] = true
+			}
+		}
+
+		// Build sorted user and query type lists
+		userList := make([]string, 0, len(uniqueUsers))
+		for user := range uniqueUsers {
+			userList = append(userList, user)
+		}
+		sort.Strings(userList)
+
+		queryTypeList := make([]string, 0, len(uniqueTypes))
+		for queryType := range uniqueTypes {
+			queryTypeList = append(queryTypeList, queryType)
+		}
+		sort.Strings(queryTypeList)
+
+		warehouseList := make([]string, 0, len(uniqueWarehouses))
+		for warehouse := range uniqueWarehouses {
+			warehouseList = append(warehouseList, warehouse)
+		}
+		sort.Strings(warehouseList)
+
+		// The comparison is a nice-to-have; the dashboard still renders without it
+		var comparison *PeriodComparison
+		if c, err := getPeriodComparison(ctx, account.db, opts); err != nil {
+			requestLogger(r.Context()).Warn("Error fetching period comparison", "error", err)
+		} else {
+			comparison = &c
+		}
+
+		// Likewise the freshness; a missing banner only loses the hint
+		freshness, err := account.freshness.Get(ctx, config.StaleDataThreshold)
+		if err != nil {
+			requestLogger(r.Context()).Warn("Error fetching data freshness", "error", err)
+		} else if freshness != nil && config.DisplayLocation != nil {
+			f := *freshness
+			f.NewestRecord = f.NewestRecord.In(config.DisplayLocation)
+			freshness = &f
+		}
+
+		data := PageData{
+			Branding: config.Branding,
+
+			Queries:     withQueryTextPreview(inDisplayLocation(acks.Annotate(queries), config.DisplayLocation), config.QueryTextPreviewChars),
+			Count:       len(queries),
+			UniqueUsers: len(uniqueUsers),
+			UserList:    userList,
+
+			QueryTypeList: queryTypeList,
+			CategoryList:  errorCategoryNames(),
+			WarehouseList: warehouseList,
+			LookbackHours: config.LookbackHours,
+			RowLimit:      config.RowLimit,
+
+			Account:     account.name,
+			AccountList: accounts.names(),
+
+			SnowsightBaseURL: account.uiBaseURL,
+
+			DisplayTimezone: displayTimezone(config.DisplayLocation),
+
+			RefreshIntervalSeconds: config.RefreshIntervalSeconds,
+			AckEnabled:             acks != nil,
+
+			APILocked: apiLocked(r.Context()),
+
+			IncludeCancellations: !excludesCancellations(config.ExcludeErrorCodes),
+
+			ServiceAccountPattern: config.ServiceAccountPattern,
+			VisibleColumns:        config.VisibleColumns,
+
+			Database:     opts.DatabaseName,
+			DatabaseList: config.MonitoredDatabases,
+
+			TopUsers:      topUsersByFailures(queries, topUsersLimit),
+			TopUsersLimit: topUsersLimit,
+
+			DurationCounts: countDurationBuckets(queries),
+
+			OrganizationUsage: organizationUsage(),
+			AccountNameCounts: countByAccountName(queries),
+
+			Comparison: comparison,
+			Freshness:  freshness,
+
+			RetryAfterSeconds: int(config.WarehouseRetryAfter.Seconds()),
+
+			Version: version,
+		}
+
+		setCacheHeader(w, account.cache, hit)
+		if err := tmpl.Execute(w, data); err != nil {
+			requestLogger(r.Context()).Error("Error executing template", "error", err)
+		}
+	}))))
+
+	http.HandleFunc("/api/queries", securityHeaders(gzipResponse(etagResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts := defaultQueryOptions(config)
+		if err := applyQueryFilters(r, &opts, config.MaxTimeRange); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := applySortParams(r, &opts); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fields, err := parseFieldsParam(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		category, err := parseCategoryParam(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fullText, err := parseFullTextParam(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fresh, err := parseFreshParam(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		queries, hit, err := account.failedQueries(ctx, opts, fresh)
+		if err != nil {
+			if writeTransientError(w, r, err, config.WarehouseRetryAfter) {
+				return
+			}
+			if writeAccessDeniedError(w, r, err) {
+				return
+			}
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching queries", "error", err)
+			return
+		}
+
+		queries = acks.Annotate(queries)
+		if r.URL.Query().Get("hide_acknowledged") == "true" {
+			queries = withoutAcknowledged(queries)
+		}
+		if category != "" {
+			queries = withCategory(queries, category)
+		}
+		if !fullText {
+			queries = withQueryTextPreview(queries, config.QueryTextPreviewChars)
+		}
+
+		var body interface{} = queries
+		if fields != nil {
+			body = projectFields(queries, fields)
+		}
+
+		setCacheHeader(w, account.cache, hit)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))))
+
+	// Newline-delimited JSON, written row by row as Snowflake returns them so memory stays
+	// flat. Not gzip-wrapped: that middleware buffers, and each line is flushed as it's ready.
+	http.HandleFunc("/api/queries.ndjson", securityHeaders(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts := defaultQueryOptions(config)
+		if err := applyQueryFilters(r, &opts, config.MaxTimeRange); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := applySortParams(r, &opts); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		category, err := parseCategoryParam(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fullText, err := parseFullTextParam(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hideAcknowledged := r.URL.Query().Get("hide_acknowledged") == "true"
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
 
-        {{if .Queries}}
-            <div class="filter-container">
-                <div class="refresh-info">
-                    <div>
-                        <label class="filter-label" for="user-filter">Filter by User:</label>
-                        <select id="user-filter" class="filter-select">
-                            <option value="">All Users</option>
-                            {{range .UserList}}
-                            <option value="{{.}}">{{.}}</option>
-                            {{end}}
-                        </select>
-                    </div>
-                    <div>
-                        <span class="last-updated" id="last-updated">Last updated: just now</span>
-                        <button class="refresh-button" id="refresh-button" onclick="refreshData()">🔄 Refresh Now</button>
-                    </div>
-                </div>
-            </div>
+		rc := http.NewResponseController(w)
+		enc := json.NewEncoder(w)
+		started := false
+		err = streamFailedQueries(ctx, account.db, opts, func(q FailedQuery) error {
+			q = acks.Annotate([]FailedQuery{q})[0]
+			if (hideAcknowledged && q.Acknowledged) || (category != "" && q.Category != category) {
+				return nil
+			}
+			if !fullText {
+				q = withQueryTextPreview([]FailedQuery{q}, config.QueryTextPreviewChars)[0]
+			}
 
-            <div id="queries-container">
-            {{range .Queries}}
-            <div class="query-card" data-user="{{.UserName}}">
-                <div class="query-header">
-                    <span class="query-user">👤 {{.UserName}}</span>
-                    <span class="query-id">ID: {{.QueryID}}</span>
-                </div>
-                <div class="query-header">
-                    <span class="query-time">⏰ {{.StartTime.Format "2006-01-02 15:04:05 MST"}}</span>
-                    <span class="execution-time">⚡ {{printf "%.2f" .ExecutionTime}}s</span>
-                </div>
-                <div class="error-message">
-                    <strong>Error:</strong> {{.ErrorMessage}}
-                </div>
-                <div class="query-text">
-                    <pre>{{.QueryText}}</pre>
-                </div>
-            </div>
-            {{end}}
-            </div>
-        {{else}}
-            <div class="no-queries">
-                <h2>✅ No Failed Queries</h2>
-                <p>Great news! No failed queries in the last 24 hours.</p>
-            </div>
-        {{end}}
-    </div>
+			if !started {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				started = true
+			}
+			if err := enc.Encode(q); err != nil {
+				return err
+			}
+			return rc.Flush()
+		})
+		if err == nil {
+			if !started {
+				// No rows: an empty stream
+				w.Header().Set("Content-Type", "application/x-ndjson")
+			}
+			return
+		}
+		if started {
+			// The status line is long gone; the client sees a truncated stream
+			requestLogger(r.Context()).Error("Error streaming queries", "error", err)
+			return
+		}
+		if writeTransientError(w, r, err, config.WarehouseRetryAfter) {
+			return
+		}
+		if writeAccessDeniedError(w, r, err) {
+			return
+		}
+		// Security Fix #6: Return generic error to client, log details server-side
+		writeJSONError(w, r, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+		requestLogger(r.Context()).Error("Error fetching queries", "error", err)
+	})))
+
+	// Failures recorded in the local SQLite history (404 when HISTORY_DB_PATH is unset)
+	http.HandleFunc("/api/history", securityHeaders(gzipResponse(etagResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		if history == nil {
+			writeJSONError(w, r, "History is not enabled (set HISTORY_DB_PATH)", http.StatusNotFound)
+			return
+		}
+
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		start, end, err := parseTimeRange(r, historyMaxRange)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := parseIntParam(r, "limit", config.RowLimit, 1, maxRowLimit)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		queries, err := history.Query(r.Context(), account.name, start, end, limit)
+		if err != nil {
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error reading history", "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(queries); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))))
+
+	// Marks a failure as reviewed (body {"acknowledged": false} clears it)
+	http.HandleFunc("POST /api/queries/{id}/ack", securityHeaders(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		if acks == nil {
+			writeJSONError(w, r, "Acknowledgements are not enabled (set ACK_DB_PATH)", http.StatusNotFound)
+			return
+		}
+
+		// Requiring JSON forces a CORS preflight, so other sites can't submit this with a plain form
+		if mediaType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";"); strings.TrimSpace(mediaType) != "application/json" {
+			writeJSONError(w, r, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		queryID := r.PathValue("id")
+		if !validQueryID.MatchString(queryID) {
+			writeJSONError(w, r, "Invalid query ID", http.StatusBadRequest)
+			return
+		}
+
+		var req AckRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeJSONError(w, r, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		acknowledged := req.Acknowledged == nil || *req.Acknowledged
+
+		if err := acks.Set(r.Context(), queryID, acknowledged); err != nil {
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to save acknowledgement", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error acknowledging query", "query_id", queryID, "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(AckResponse{QueryID: queryID, Acknowledged: acknowledged}); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))
+
+	// Lists the fields available to ?fields= on /api/queries
+	http.HandleFunc("/api/columns", securityHeaders(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(columnFields()); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))
+
+	// Live updates for the dashboard via Server-Sent Events (not gzipped: events must flush immediately)
+	http.HandleFunc("/api/stream", securityHeaders(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The stream outlives the server's WriteTimeout, so lift it for this connection
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			writeJSONError(w, r, "Streaming unsupported", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error disabling write deadline for stream", "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+		w.WriteHeader(http.StatusOK)
+
+		updates, latest := account.stream.Subscribe()
+		defer account.stream.Unsubscribe(updates)
+
+		if latest != nil {
+			if err := writeSSE(w, "queries", latest); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(sseHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				// Client disconnected
+				return
+			case payload := <-updates:
+				if err := writeSSE(w, "queries", payload); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	})))
+
+	// Paginated API: same filters as /api/queries, wrapped in an envelope with paging metadata
+	http.HandleFunc("/api/v2/queries", securityHeaders(gzipResponse(etagResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts := defaultQueryOptions(config)
+		if err := applyQueryFilters(r, &opts, config.MaxTimeRange); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := applySortParams(r, &opts); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limit, err := parseIntParam(r, "limit", config.RowLimit, 1, maxRowLimit)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		offset, err := parseIntParam(r, "offset", 0, 0, maxPageOffset)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fullText, err := parseFullTextParam(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fresh, err := parseFreshParam(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Fetch one extra row to find out whether another page exists
+		opts.RowLimit = limit + 1
+		opts.Offset = offset
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		queries, hit, err := account.failedQueries(ctx, opts, fresh)
+		if err != nil {
+			if writeTransientError(w, r, err, config.WarehouseRetryAfter) {
+				return
+			}
+			if writeAccessDeniedError(w, r, err) {
+				return
+			}
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching queries", "error", err)
+			return
+		}
+
+		page := PaginatedQueries{
+			Queries: acks.Annotate(queries),
+			Limit:   limit,
+			Offset:  offset,
+		}
+		if len(queries) > limit {
+			page.Queries = page.Queries[:limit]
+			next := offset + limit
+			page.NextOffset = &next
+		} else {
+			total := offset + len(queries)
+			page.Total = &total
+		}
+		if page.Queries == nil {
+			page.Queries = []FailedQuery{}
+		}
+		if !fullText {
+			page.Queries = withQueryTextPreview(page.Queries, config.QueryTextPreviewChars)
+		}
+
+		// Freshness is a nice-to-have; the page is still returned without it
+		if freshness, err := account.freshness.Get(ctx, config.StaleDataThreshold); err != nil {
+			requestLogger(r.Context()).Warn("Error fetching data freshness", "error", err)
+		} else {
+			page.Freshness = freshness
+		}
+
+		setCacheHeader(w, account.cache, hit)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(page); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))))
+
+	http.HandleFunc("/api/summary", securityHeaders(gzipResponse(etagResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts := defaultQueryOptions(config)
+		if err := applyQueryFilters(r, &opts, config.MaxTimeRange); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		summary, err := getFailureSummary(ctx, account.db, opts)
+		if err != nil {
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching failure summary", "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(summary); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))))
+
+	http.HandleFunc("/api/comparison", securityHeaders(gzipResponse(etagResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts := defaultQueryOptions(config)
+		if err := applyQueryFilters(r, &opts, config.MaxTimeRange); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		comparison, err := getPeriodComparison(ctx, account.db, opts)
+		if err != nil {
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching period comparison", "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(comparison); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))))
+
+	// Failure counts over several trailing windows (1h, 24h, 7d by default) in one call
+	http.HandleFunc("/api/overview", securityHeaders(gzipResponse(etagResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts := defaultQueryOptions(config)
+		if err := applyQueryFilters(r, &opts, config.MaxTimeRange); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		overview, err := getFailureOverview(ctx, account.db, opts, config.OverviewWindows)
+		if err != nil {
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching failure overview", "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(overview); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))))
+
+	// How far ACCOUNT_USAGE lags, behind the dashboard's "data may be delayed" banner
+	http.HandleFunc("/api/freshness", securityHeaders(gzipResponse(etagResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		freshness, err := account.freshness.Get(ctx, config.StaleDataThreshold)
+		if err != nil {
+			if writeTransientError(w, r, err, config.WarehouseRetryAfter) {
+				return
+			}
+			if writeAccessDeniedError(w, r, err) {
+				return
+			}
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching data freshness", "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(freshness); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))))
+
+	// Distinct filter values in the window, for building filter dropdowns
+	http.HandleFunc("/api/facets", securityHeaders(gzipResponse(etagResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts := defaultQueryOptions(config)
+		if err := applyQueryFilters(r, &opts, config.MaxTimeRange); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		facets, hit, err := account.facets.Get(ctx, opts)
+		if err != nil {
+			if writeTransientError(w, r, err, config.WarehouseRetryAfter) {
+				return
+			}
+			if writeAccessDeniedError(w, r, err) {
+				return
+			}
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching facets", "error", err)
+			return
+		}
+
+		if hit {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(facets); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))))
+
+	// One failed query with its full text (the dashboard's "Show full query" button)
+	http.HandleFunc("GET /api/queries/{id}", securityHeaders(gzipResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		queryID := r.PathValue("id")
+		if !validQueryID.MatchString(queryID) {
+			writeJSONError(w, r, "Invalid query ID", http.StatusBadRequest)
+			return
+		}
+
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-    <script>
-        // Auto-refresh configuration
-        const REFRESH_INTERVAL = 30000; // 30 seconds
-        let refreshTimer = null;
-        let lastUpdateTime = Date.now();
-        let isRefreshing = false;
+		opts := defaultQueryOptions(config)
+		if err := applyQueryFilters(r, &opts, config.MaxTimeRange); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-        document.addEventListener('DOMContentLoaded', function() {
-            // Initialize filter functionality
-            initializeFilter();
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
 
-            // Start auto-refresh
-            startAutoRefresh();
+		query, err := getQueryByID(ctx, account.db, opts, queryID)
+		if err != nil {
+			if writeTransientError(w, r, err, config.WarehouseRetryAfter) {
+				return
+			}
+			if writeAccessDeniedError(w, r, err) {
+				return
+			}
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching query", "query_id", queryID, "error", err)
+			return
+		}
+		if query == nil {
+			writeJSONError(w, r, "Failed query not found in the requested time range", http.StatusNotFound)
+			return
+		}
 
-            // Update "last updated" timestamp display
-            updateTimestamp();
-            setInterval(updateTimestamp, 1000);
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(acks.Annotate([]FailedQuery{*query})[0]); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	}))))
 
-            // Pause/resume polling based on page visibility
-            document.addEventListener('visibilitychange', handleVisibilityChange);
-        });
+	// Sends a synthetic, clearly labelled failure through every configured sink to check the setup.
+	// It has side effects outside the dashboard (PagerDuty opens an incident), so it is only served
+	// behind API_KEYS, to requests carrying a key header; the dashboard session cookie is not enough.
+	http.HandleFunc("POST /api/test-notification", securityHeaders(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		if len(config.APIKeys) == 0 {
+			writeJSONError(w, r, "Test notifications require API key authentication (set API_KEYS)", http.StatusNotFound)
+			return
+		}
+		// apiKeyAuth has already checked any key sent; this also holds if the session rules change
+		if requestAPIKey(r) == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			writeJSONError(w, r, "Test notifications require an Authorization or X-API-Key header", http.StatusUnauthorized)
+			return
+		}
+		if len(notifiers) == 0 && pager == nil {
+			writeJSONError(w, r, "No notifiers are configured (set SLACK_WEBHOOK_URL, TEAMS_WEBHOOK_URL, ALERT_WEBHOOK_URL, or PAGERDUTY_ROUTING_KEY)", http.StatusNotFound)
+			return
+		}
 
-        function initializeFilter() {
-            const userFilter = document.getElementById('user-filter');
-            if (!userFilter) return;
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-            userFilter.addEventListener('change', function() {
-                applyFilter(this.value);
-            });
-        }
+		results := sendTestNotifications(r.Context(), account.name, notifiers, pager)
+		for _, result := range results {
+			if !result.OK {
+				requestLogger(r.Context()).Warn("Test notification failed", "sink", result.Sink, "error", result.Error)
+			}
+		}
 
-        function applyFilter(selectedUser) {
-            const queryCards = document.querySelectorAll('.query-card');
-            const displayedCount = document.getElementById('displayed-count');
-            const displayedUsers = document.getElementById('displayed-users');
-
-            let visibleCount = 0;
-            const visibleUsers = new Set();
-
-            queryCards.forEach(function(card) {
-                const cardUser = card.getAttribute('data-user');
-                if (selectedUser === '' || cardUser === selectedUser) {
-                    card.classList.remove('hidden');
-                    visibleCount++;
-                    visibleUsers.add(cardUser);
-                } else {
-                    card.classList.add('hidden');
-                }
-            });
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))
 
-            // Update stats
-            if (displayedCount) displayedCount.textContent = visibleCount;
-            if (displayedUsers) displayedUsers.textContent = visibleUsers.size;
-        }
+	// Re-queries the default window now instead of waiting for the next background refresh.
+	// Behind API_KEYS and the rate limit like every /api/ route; ForceRefresh coalesces the rest.
+	http.HandleFunc("POST /api/refresh", securityHeaders(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-        function startAutoRefresh() {
-            // Clear any existing timer
-            if (refreshTimer) {
-                clearInterval(refreshTimer);
-            }
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
 
-            // Set up interval to refresh every 30 seconds
-            refreshTimer = setInterval(refreshData, REFRESH_INTERVAL);
-        }
+		queries, updated, err := account.snapshot.ForceRefresh(ctx, account.name, account.db)
+		if err != nil {
+			if writeTransientError(w, r, err, config.WarehouseRetryAfter) {
+				return
+			}
+			if writeAccessDeniedError(w, r, err) {
+				return
+			}
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to refresh data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error refreshing queries", "error", err)
+			return
+		}
 
-        function stopAutoRefresh() {
-            if (refreshTimer) {
-                clearInterval(refreshTimer);
-                refreshTimer = null;
-            }
-        }
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(RefreshResult{Count: len(queries), UpdatedAt: updated}); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))
 
-        function refreshData() {
-            if (isRefreshing) return; // Prevent multiple simultaneous refreshes
+	// Execution metrics for the detail page, fetched lazily after it renders
+	http.HandleFunc("GET /api/queries/{id}/metrics", securityHeaders(gzipResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		if !config.QueryMetrics {
+			writeJSONError(w, r, "Query metrics are not enabled (set QUERY_METRICS_ENABLED=true)", http.StatusNotFound)
+			return
+		}
 
-            isRefreshing = true;
-            const refreshButton = document.getElementById('refresh-button');
-            const container = document.getElementById('queries-container');
+		queryID := r.PathValue("id")
+		if !validQueryID.MatchString(queryID) {
+			writeJSONError(w, r, "Invalid query ID", http.StatusBadRequest)
+			return
+		}
 
-            if (refreshButton) {
-                refreshButton.disabled = true;
-                refreshButton.textContent = '⏳ Refreshing...';
-            }
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-            if (container) {
-                container.classList.add('refreshing');
-            }
+		opts := defaultQueryOptions(config)
+		if opts.StartTime, opts.EndTime, err = parseTimeRange(r, config.MaxTimeRange); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-            // Remember current filter selection
-            const userFilter = document.getElementById('user-filter');
-            const currentFilter = userFilter ? userFilter.value : '';
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
 
-            // Fetch fresh data from API
-            fetch('/api/queries')
-                .then(response => {
-                    if (!response.ok) {
-                        throw new Error('Failed to fetch data');
-                    }
-                    return response.json();
-                })
-                .then(data => {
-                    updateDashboard(data, currentFilter);
-                    lastUpdateTime = Date.now();
-                    updateTimestamp();
-                })
-                .catch(error => {
-                    console.error('Error refreshing data:', error);
-                    // Don't stop auto-refresh on error, just log it
-                })
-                .finally(() => {
-                    isRefreshing = false;
-                    if (refreshButton) {
-                        refreshButton.disabled = false;
-                        refreshButton.textContent = '🔄 Refresh Now';
-                    }
-                    if (container) {
-                        container.classList.remove('refreshing');
-                    }
-                });
-        }
+		metrics, err := getQueryMetrics(ctx, account.db, opts, queryID)
+		if err != nil {
+			if writeTransientError(w, r, err, config.WarehouseRetryAfter) {
+				return
+			}
+			if writeAccessDeniedError(w, r, err) {
+				return
+			}
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching query metrics", "query_id", queryID, "error", err)
+			return
+		}
+		if metrics == nil {
+			writeJSONError(w, r, "Failed query not found in the requested time range", http.StatusNotFound)
+			return
+		}
 
-        function updateDashboard(queries, currentFilter) {
-            // Update query cards
-            updateQueryCards(queries);
-
-            // Update user filter dropdown
-            updateUserFilter(queries);
-
-            // Update statistics
-            updateStatistics(queries);
-
-            // Re-apply current filter
-            if (currentFilter) {
-                const userFilter = document.getElementById('user-filter');
-                if (userFilter) {
-                    userFilter.value = currentFilter;
-                    applyFilter(currentFilter);
-                }
-            } else {
-                applyFilter('');
-            }
-        }
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(metrics); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	}))))
 
-        function updateQueryCards(queries) {
-            const container = document.getElementById('queries-container');
-            if (!container) return;
+	// Failure counts per interval for the dashboard's trend chart
+	http.HandleFunc("/api/trend", securityHeaders(gzipResponse(etagResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-            if (queries.length === 0) {
-                container.innerHTML = '<div class="no-queries"><h2>✅ No Failed Queries</h2><p>Great news! No failed queries in the last 24 hours.</p></div>';
-                return;
-            }
+		opts := defaultQueryOptions(config)
+		if err := applyQueryFilters(r, &opts, config.MaxTimeRange); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		interval, err := parseTrendInterval(r, opts)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-            let html = '';
-            queries.forEach(q => {
-                const startTime = new Date(q.start_time);
-                const timeStr = startTime.toLocaleString('en-US', {
-                    year: 'numeric',
-                    month: '2-digit',
-                    day: '2-digit',
-                    hour: '2-digit',
-                    minute: '2-digit',
-                    second: '2-digit',
-                    timeZoneName: 'short'
-                });
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
 
-                html += '<div class="query-card" data-user="' + escapeHtml(q.user_name) + '">' +
-                    '<div class="query-header">' +
-                        '<span class="query-user">👤 ' + escapeHtml(q.user_name) + '</span>' +
-                        '<span class="query-id">ID: ' + escapeHtml(q.query_id) + '</span>' +
-                    '</div>' +
-                    '<div class="query-header">' +
-                        '<span class="query-time">⏰ ' + timeStr + '</span>' +
-                        '<span class="execution-time">⚡ ' + q.execution_time_seconds.toFixed(2) + 's</span>' +
-                    '</div>' +
-                    '<div class="error-message">' +
-                        '<strong>Error:</strong> ' + escapeHtml(q.error_message) +
-                    '</div>' +
-                    '<div class="query-text">' +
-                        '<pre>' + escapeHtml(q.query_text) + '</pre>' +
-                    '</div>' +
-                '</div>';
-            });
+		trend, err := getFailureTrend(ctx, account.db, opts, interval)
+		if err != nil {
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching failure trend", "error", err)
+			return
+		}
 
-            container.innerHTML = html;
-        }
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(trend); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))))
 
-        function updateUserFilter(queries) {
-            const userFilter = document.getElementById('user-filter');
-            if (!userFilter) return;
+	http.HandleFunc("/api/heatmap", securityHeaders(gzipResponse(etagResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-            const currentValue = userFilter.value;
-            const users = new Set();
+		opts := defaultQueryOptions(config)
+		if err := applyQueryFilters(r, &opts, config.MaxTimeRange); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if heatmapTooLong(opts) {
+			writeJSONError(w, r, fmt.Sprintf("time range too long for an hourly heatmap (max %d hours)", maxTrendBuckets), http.StatusBadRequest)
+			return
+		}
 
-            queries.forEach(q => {
-                users.add(q.user_name);
-            });
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
 
-            const sortedUsers = Array.from(users).sort();
+		heatmap, err := getFailureHeatmap(ctx, account.db, opts)
+		if err != nil {
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching failure heatmap", "error", err)
+			return
+		}
 
-            let html = '<option value="">All Users</option>';
-            sortedUsers.forEach(user => {
-                html += '<option value="' + escapeHtml(user) + '">' + escapeHtml(user) + '</option>';
-            });
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(heatmap); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))))
 
-            userFilter.innerHTML = html;
-            userFilter.value = currentValue; // Restore selection
-        }
+	// Failures by hour of day across the whole window, for spotting scheduled-job failures
+	http.HandleFunc("/api/hourly-distribution", securityHeaders(gzipResponse(etagResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-        function updateStatistics(queries) {
-            const displayedCount = document.getElementById('displayed-count');
-            const displayedUsers = document.getElementById('displayed-users');
+		opts := defaultQueryOptions(config)
+		if err := applyQueryFilters(r, &opts, config.MaxTimeRange); err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Hours are in DISPLAY_TIMEZONE, or UTC, unless the client asks for its own zone
+		defaultLoc := config.DisplayLocation
+		if defaultLoc == nil {
+			defaultLoc = time.UTC
+		}
+		loc, err := parseTimeZoneParam(r, defaultLoc)
+		if err != nil {
+			writeJSONError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-            const uniqueUsers = new Set();
-            queries.forEach(q => uniqueUsers.add(q.user_name));
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
 
-            if (displayedCount) displayedCount.textContent = queries.length;
-            if (displayedUsers) displayedUsers.textContent = uniqueUsers.size;
-        }
+		distribution, err := getHourlyDistribution(ctx, account.db, opts, loc)
+		if err != nil {
+			// Security Fix #6: Return generic error to client, log details server-side
+			writeJSONError(w, r, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching hourly distribution", "error", err)
+			return
+		}
 
-        function updateTimestamp() {
-            const lastUpdated = document.getElementById('last-updated');
-            if (!lastUpdated) return;
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(distribution); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
+		}
+	})))))
 
-            const seconds = Math.floor((Date.now() - lastUpdateTime) / 1000);
+	// Generated description of the JSON API, for clients that want typed bindings
+	openAPISpec, err := json.Marshal(buildOpenAPISpec(len(config.APIKeys) > 0))
+	if err != nil {
+		log.Fatalf("Failed to build OpenAPI document: %v", err)
+	}
+	http.HandleFunc("GET /openapi.json", securityHeaders(gzipResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPISpec)
+	}))))
 
-            if (seconds < 60) {
-                lastUpdated.textContent = 'Last updated: ' + seconds + ' second' + (seconds !== 1 ? 's' : '') + ' ago';
-            } else {
-                const minutes = Math.floor(seconds / 60);
-                lastUpdated.textContent = 'Last updated: ' + minutes + ' minute' + (minutes !== 1 ? 's' : '') + ' ago';
-            }
-        }
+	// Per-query detail page, linked from each card's query ID
+	http.HandleFunc("/query/{id}", securityHeaders(gzipResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", pageCacheControl)
+		queryID := r.PathValue("id")
+		if !validQueryID.MatchString(queryID) {
+			http.Error(w, "Invalid query ID", http.StatusBadRequest)
+			return
+		}
 
-        function handleVisibilityChange() {
-            if (document.hidden) {
-                // Page is hidden, stop auto-refresh to save resources
-                stopAutoRefresh();
-            } else {
-                // Page is visible again, resume auto-refresh
-                startAutoRefresh();
-                // Optionally refresh immediately when tab becomes visible
-                refreshData();
-            }
-        }
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-        function escapeHtml(text) {
-            const div = document.createElement('div');
-            div.textContent = text;
-            return div.innerHTML;
-        }
-    </script>
-</body>
-</html>
-`
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
 
-type PageData struct {
-	Queries     []FailedQuery
-	Count       int
-	UniqueUsers int
-	UserList    []string
-}
+		query, err := getQueryByID(ctx, account.db, defaultQueryOptions(config), queryID)
+		if err != nil {
+			if writeTransientError(w, r, err, config.WarehouseRetryAfter) {
+				return
+			}
+			if writeAccessDeniedError(w, r, err) {
+				return
+			}
+			// Security Fix #6: Return generic error to client, log details server-side
+			http.Error(w, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching query", "query_id", queryID, "error", err)
+			return
+		}
+		if query == nil {
+			http.Error(w, fmt.Sprintf("Failed query not found in the last %d hours", config.LookbackHours), http.StatusNotFound)
+			return
+		}
 
-func main() {
-	config, err := loadConfig()
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
-	}
+		if err := detailTmpl.Execute(w, DetailPageData{
+			Branding: config.Branding,
 
-	db, privateKey, err := getSnowflakeConnection(config)
-	if err != nil {
-		log.Fatalf("Failed to connect to Snowflake: %v", err)
-	}
-	defer db.Close()
+			Query:            inDisplayLocation(acks.Annotate([]FailedQuery{*query}), config.DisplayLocation)[0],
+			Account:          account.name,
+			SnowsightBaseURL: account.uiBaseURL,
+			MetricsEnabled:   config.QueryMetrics && !apiLocked(r.Context()), // The metrics call would be refused
+		}); err != nil {
+			requestLogger(r.Context()).Error("Error executing detail template", "error", err)
+		}
+	}))))
 
-	// Security Fix #3: Clear sensitive data from memory after successful connection
-	clearSensitiveData(config)
+	// Print-friendly snapshot of the window, for "Print to PDF" into incident docs
+	http.HandleFunc("/report", securityHeaders(gzipResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", pageCacheControl)
+		account, err := accounts.fromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-	// Clear private key material from memory after connection is established
-	// The key is no longer needed since the DB connection has been authenticated
-	if privateKey != nil {
-		clearPrivateKey(privateKey)
-	}
+		opts := defaultQueryOptions(config)
+		if err := applyQueryFilters(r, &opts, config.MaxTimeRange); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := applySortParams(r, &opts); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fresh, err := parseFreshParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-	// Security Fix #4: Go's html/template automatically escapes all interpolated values
-	// to prevent XSS attacks. This includes QueryText, ErrorMessage, UserName, etc.
-	// The template engine escapes HTML, JavaScript, CSS, and URL contexts automatically.
-	tmpl, err := template.New("dashboard").Parse(htmlTemplate)
-	if err != nil {
-		log.Fatalf("Failed to parse template: %v", err)
-	}
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
 
-	http.HandleFunc("/", securityHeaders(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
-		queries, err := getFailedQueries(db)
+		queries, _, err := account.failedQueries(ctx, opts, fresh)
+		if err != nil {
+			if writeTransientError(w, r, err, config.WarehouseRetryAfter) {
+				return
+			}
+			if writeAccessDeniedError(w, r, err) {
+				return
+			}
+			// Security Fix #6: Return generic error to client, log details server-side
+			http.Error(w, "Internal server error - unable to fetch data", http.StatusInternalServerError)
+			requestLogger(r.Context()).Error("Error fetching queries", "error", err)
+			return
+		}
+		topErrors, err := getFailureSummary(ctx, account.db, opts)
 		if err != nil {
+			if writeTransientError(w, r, err, config.WarehouseRetryAfter) {
+				return
+			}
+			if writeAccessDeniedError(w, r, err) {
+				return
+			}
 			// Security Fix #6: Return generic error to client, log details server-side
 			http.Error(w, "Internal server error - unable to fetch data", http.StatusInternalServerError)
-			log.Printf("Error fetching queries: %v", err)
+			requestLogger(r.Context()).Error("Error fetching failure summary", "error", err)
 			return
 		}
+		if len(topErrors) > reportTopErrors {
+			topErrors = topErrors[:reportTopErrors]
+		}
 
 		uniqueUsers := make(map[string]bool)
 		for _, q := range queries {
 			uniqueUsers[q.UserName] = true
 		}
 
-		// Build sorted user list
-		userList := make([]string, 0, len(uniqueUsers))
-		for user := range uniqueUsers {
-			userList = append(userList, user)
+		// Report times use DISPLAY_TIMEZONE, or UTC since there's no browser to localize them
+		loc := config.DisplayLocation
+		if loc == nil {
+			loc = time.UTC
 		}
+		now := time.Now()
+		start, end := trendWindow(opts, now)
+
+		data := ReportPageData{
+			Branding: config.Branding,
+
+			Account:     account.name,
+			GeneratedAt: now.In(loc),
+			WindowStart: start.In(loc),
+			WindowEnd:   end.In(loc),
 
-		data := PageData{
-			Queries:     queries,
 			Count:       len(queries),
+			Truncated:   len(queries) >= opts.RowLimit,
 			UniqueUsers: len(uniqueUsers),
-			UserList:    userList,
+
+			TopErrors: topErrors,
+			TopUsers:  topUsersByFailures(queries, topUsersLimit),
+			Queries:   withQueryTextPreview(inDisplayLocation(queries, loc), config.QueryTextPreviewChars),
+		}
+		for name, values := range r.URL.Query() {
+			if name != "account" && values[0] != "" {
+				data.Filters = append(data.Filters, name+"="+values[0])
+			}
 		}
+		sort.Strings(data.Filters)
 
-		if err := tmpl.Execute(w, data); err != nil {
-			log.Printf("Error executing template: %v", err)
+		// The comparison is a nice-to-have; the report still renders without it
+		if c, err := getPeriodComparison(ctx, account.db, opts); err != nil {
+			requestLogger(r.Context()).Warn("Error fetching period comparison", "error", err)
+		} else {
+			data.Comparison = &c
 		}
-	})))
 
-	http.HandleFunc("/api/queries", securityHeaders(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
-		queries, err := getFailedQueries(db)
-		if err != nil {
-			// Security Fix #6: Return generic error to client, log details server-side
-			http.Error(w, "Internal server error - unable to fetch data", http.StatusInternalServerError)
-			log.Printf("Error fetching queries: %v", err)
+		if err := reportTmpl.Execute(w, data); err != nil {
+			requestLogger(r.Context()).Error("Error executing report template", "error", err)
+		}
+	}))))
+
+	// The dashboard's CSS and JS; a changed file gets a new name, so browsers can keep these forever
+	http.HandleFunc("GET /static/{name}", securityHeaders(gzipResponse(limitRequestSize(func(w http.ResponseWriter, r *http.Request) {
+		asset, ok := staticAssets[r.PathValue("name")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", asset.contentType)
+		w.Header().Set("Cache-Control", staticCacheControl)
+		if _, err := w.Write(asset.body); err != nil {
+			requestLogger(r.Context()).Error("Error writing static asset", "error", err)
+		}
+	}))))
+
+	// Liveness: cheap ping only, kept off the heavier request path
+	http.HandleFunc("/healthz", securityHeaders(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		for _, account := range accounts {
+			if err := account.db.PingContext(ctx); err != nil {
+				requestLogger(r.Context()).Error("Health check failed", "account", account.name, "error", err)
+				writeHealthStatus(w, false)
+				return
+			}
+		}
+		writeHealthStatus(w, true)
+	}))
+
+	// Readiness: not ready until every account's background snapshot has been fetched once,
+	// so a load balancer never routes to an empty dashboard; then also verifies the
+	// ACCOUNT_USAGE view is still queryable
+	http.HandleFunc("/readyz", securityHeaders(func(w http.ResponseWriter, r *http.Request) {
+		body := ReadinessStatus{Status: "ok", LastFetch: make(map[string]*time.Time, len(accounts))}
+		for _, account := range accounts {
+			body.LastFetch[account.name] = nil
+			if updated := account.snapshot.LastSuccess(); !updated.IsZero() {
+				body.LastFetch[account.name] = &updated
+			} else {
+				body.Status = "starting"
+			}
+		}
+		if body.Status != "ok" {
+			writeReadiness(w, body)
 			return
 		}
 
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		for _, account := range accounts {
+			if err := checkAccountUsageAccess(ctx, account.db); err != nil {
+				requestLogger(r.Context()).Error("Readiness check failed", "account", account.name, "error", err)
+				body.Status = "unhealthy"
+				break
+			}
+		}
+		writeReadiness(w, body)
+	}))
+
+	http.HandleFunc("/version", securityHeaders(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(queries); err != nil {
-			log.Printf("Error encoding JSON: %v", err)
+		if err := json.NewEncoder(w).Encode(BuildInfo{Version: version, Commit: commit, BuildDate: buildDate}); err != nil {
+			requestLogger(r.Context()).Error("Error encoding JSON", "error", err)
 		}
-	})))
+	}))
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	log.Printf("Starting server on :%s", port)
-	log.Printf("Dashboard: http://localhost:%s", port)
-	log.Printf("API endpoint: http://localhost:%s/api/queries", port)
+	// BIND_ADDR restricts listening to one interface; empty keeps listening on all interfaces
+	addr, err := serverAddr(os.Getenv("BIND_ADDR"), port)
+	if err != nil {
+		log.Fatalf("Invalid server address: %v", err)
+	}
+
+	tlsEnabled := config.TLSCertFile != ""
+	scheme := "http"
+	if tlsEnabled {
+		scheme = "https"
+	}
+
+	log.Printf("Starting server on %s", addr)
+	log.Printf("Dashboard: %s://localhost:%s", scheme, port)
+	log.Printf("API endpoint: %s://localhost:%s/api/queries", scheme, port)
+	log.Printf("Health checks: %s://localhost:%s/healthz, %s://localhost:%s/readyz", scheme, port, scheme, port)
+
+	// A response can't be written after WriteTimeout, so leave room for the slowest query
+	writeTimeout := max(10*time.Second, config.QueryTimeout+5*time.Second)
 
 	// Security Fix #7: Configure HTTP server with timeouts and limits
 	// to prevent resource exhaustion and slow HTTP attacks (slowloris)
 	server := &http.Server{
-		Addr:              ":" + port,
-		Handler:           nil,
+		Addr:              addr,
+		Handler:           requestID(accessLog(config.AccessLog, config.TrustedProxies, traceRequest(rateLimit(limiter, corsHeaders(config.CORSAllowedOrigins, apiKeyAuth(config.APIKeys, http.DefaultServeMux.ServeHTTP)))))),
 		ReadTimeout:       10 * time.Second,  // Maximum time to read request (prevents slowloris)
-		WriteTimeout:      10 * time.Second,  // Maximum time to write response
+		WriteTimeout:      writeTimeout,      // Maximum time to write response
 		MaxHeaderBytes:    1 << 20,           // 1 MB max header size
 		IdleTimeout:       60 * time.Second,  // Keep-alive timeout
 		ReadHeaderTimeout: 5 * time.Second,   // Time to read request headers
 	}
+
+	if tlsEnabled {
+		server.TLSConfig = newTLSConfig()
+		log.Printf("TLS enabled (certificate: %s)", config.TLSCertFile)
+		if err := server.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile); err != nil {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+		return
+	}
+
 	if err := server.ListenAndServe(); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snowflakedb/gosnowflake"
+)
+
+func TestScrubSecrets(t *testing.T) {
+	const secret = "p@ss/w rd?&=%"
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"raw", errors.New("login failed for " + secret), "login failed for [REDACTED]"},
+		{"query escaped", errors.New("bad dsn user:" + url.QueryEscape(secret) + "@host"), "bad dsn user:[REDACTED]@host"},
+		{"path escaped", errors.New("bad dsn user:" + url.PathEscape(secret) + "@host"), "bad dsn user:[REDACTED]@host"},
+		{"no secret", errors.New("connection refused"), "connection refused"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scrubSecrets(tt.err, secret)
+			if got.Error() != tt.want {
+				t.Errorf("scrubSecrets() = %q, want %q", got.Error(), tt.want)
+			}
+			if !errors.Is(got, tt.err) {
+				t.Errorf("scrubSecrets() no longer wraps the original error")
+			}
+			if tt.err.Error() == tt.want && got != tt.err {
+				t.Errorf("scrubSecrets() wrapped an error that contained no secret")
+			}
+		})
+	}
+
+	if scrubSecrets(nil, secret) != nil {
+		t.Errorf("scrubSecrets(nil) should be nil")
+	}
+}
+
+func TestGetSnowflakeConnectionHidesPassword(t *testing.T) {
+	config := &AccountConfig{
+		Account:   "testaccount",
+		Host:      "127.0.0.1",
+		Port:      1, // Nothing listens here, so the ping fails fast
+		User:      "dashboard",
+		Password:  "s3cr3t@/:?#&=+% pass",
+		Database:  "SNOWFLAKE",
+		Schema:    "ACCOUNT_USAGE",
+		Warehouse: "COMPUTE_WH",
+		AuthType:  AuthTypePassword,
+		QueryTag:  "failed-queries-dashboard",
+	}
+
+	dsn, err := gosnowflake.DSN(&gosnowflake.Config{
+		Account:       config.Account,
+		Host:          config.Host,
+		Port:          config.Port,
+		User:          config.User,
+		Password:      config.Password,
+		Authenticator: gosnowflake.AuthTypeSnowflake,
+		Database:      config.Database,
+		Schema:        config.Schema,
+		Warehouse:     config.Warehouse,
+		Params:        map[string]*string{"query_tag": &config.QueryTag},
+	})
+	if err != nil {
+		t.Fatalf("building the expected DSN: %v", err)
+	}
+
+	db, _, err := getSnowflakeConnection(config, PoolSettings{MaxOpenConns: 1, MaxIdleConns: 1}, 2*time.Second)
+	if err == nil {
+		db.Close()
+		t.Fatal("expected an error connecting to an unreachable host")
+	}
+
+	msg := err.Error()
+	for _, leak := range []string{config.Password, url.QueryEscape(config.Password), url.PathEscape(config.Password), dsn} {
+		if strings.Contains(msg, leak) {
+			t.Errorf("error %q contains secret %q", msg, leak)
+		}
+	}
+}
+
+func TestRequiredHistoryColumnsCoverFilters(t *testing.T) {
+	required := make(map[string]bool, len(requiredHistoryColumns))
+	for _, name := range requiredHistoryColumns {
+		required[name] = true
+	}
+	for field, f := range filterFields {
+		if !required[f.column] {
+			t.Errorf("?filter= field %s uses %s, which requiredHistoryColumns doesn't list", field, f.column)
+		}
+	}
+	for sort, column := range sortColumns {
+		if !required[column] {
+			t.Errorf("sort %s uses %s, which requiredHistoryColumns doesn't list", sort, column)
+		}
+	}
+}
+
+func TestHistoryStoreMigratesOldDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	// The failed_queries table as the first history release created it
+	old, err := openSQLite(path, `
+		CREATE TABLE failed_queries (
+			account TEXT NOT NULL, query_id TEXT NOT NULL, query_text TEXT NOT NULL, user_name TEXT NOT NULL,
+			warehouse_name TEXT NOT NULL, database_name TEXT NOT NULL, schema_name TEXT NOT NULL,
+			query_type TEXT NOT NULL, error_code TEXT NOT NULL, error_message TEXT NOT NULL,
+			start_time INTEGER NOT NULL, end_time INTEGER NOT NULL, execution_time_seconds REAL NOT NULL,
+			bytes_scanned INTEGER NOT NULL, credits_used_cloud_services REAL NOT NULL,
+			PRIMARY KEY (account, query_id)
+		);
+		INSERT INTO failed_queries VALUES ('default', 'old', 'SELECT 1', 'ALICE', '', '', '', 'SELECT', '1', 'boom', 1000, 2000, 1, 0, 0);`)
+	if err != nil {
+		t.Fatalf("creating the old database: %v", err)
+	}
+	old.Close()
+
+	store, err := openHistoryStore(path)
+	if err != nil {
+		t.Fatalf("openHistoryStore() error = %v", err)
+	}
+	defer store.Close()
+
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if _, err := store.Save("default", []FailedQuery{{QueryID: "new", UserName: "BOB", RoleName: "ANALYST", AccountName: "PROD", StartTime: start, EndTime: start}}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	queries, err := store.Query(context.Background(), "default", time.Time{}, time.Time{}, 10)
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	got := make(map[string]FailedQuery, len(queries))
+	for _, q := range queries {
+		got[q.QueryID] = q
+	}
+	if q := got["new"]; q.AccountName != "PROD" || q.RoleName != "ANALYST" {
+		t.Errorf("new row account_name, role_name = %q, %q, want PROD, ANALYST", q.AccountName, q.RoleName)
+	}
+	if q, ok := got["old"]; !ok || q.AccountName != "" || q.RoleName != "" {
+		t.Errorf("old row = %+v, want it kept with an empty account_name and role_name", q)
+	}
+}
+
+func TestAPIKeyAuthSession(t *testing.T) {
+	const key = "0123456789abcdef0123"
+	var locked bool
+	handler := apiKeyAuth([]string{key}, func(w http.ResponseWriter, r *http.Request) {
+		locked = apiLocked(r.Context())
+	})
+
+	// Only a page load that carries a key gets a session
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if len(rec.Result().Cookies()) != 0 || !locked {
+		t.Fatalf("page without a key: cookies = %v, locked = %v; want none, true", rec.Result().Cookies(), locked)
+	}
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-API-Key", key)
+	handler(rec, req)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || locked {
+		t.Fatalf("page with a key: cookies = %v, locked = %v; want one, false", cookies, locked)
+	}
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/queries", http.StatusOK},
+		{http.MethodPost, "/api/queries/01b2-3c4d/ack", http.StatusOK},
+		{http.MethodPost, "/api/refresh", http.StatusOK},
+		{http.MethodPost, "/api/test-notification", http.StatusUnauthorized},
+		{http.MethodPost, "/api/queries/01b2-3c4d/other/ack", http.StatusUnauthorized},
+		{http.MethodDelete, "/api/queries/01b2-3c4d/ack", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.AddCookie(cookies[0])
+		handler(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s with the session = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestMissingHistoryColumnsIncludesMetrics(t *testing.T) {
+	columns := make(map[string]bool)
+	for _, name := range requiredHistoryColumns {
+		columns[name] = true
+	}
+	columns["WAREHOUSE_SIZE"] = true
+
+	missing, err := missingHistoryColumns(columns)
+	if err != nil {
+		t.Fatalf("missingHistoryColumns() error = %v", err)
+	}
+	if missing["WAREHOUSE_SIZE"] {
+		t.Errorf("WAREHOUSE_SIZE is present but reported missing")
+	}
+	for _, column := range []string{"END_TIME", "QUEUED_OVERLOAD_TIME", "ROWS_PRODUCED"} {
+		if !missing[column] {
+			t.Errorf("%s is absent but not reported missing", column)
+		}
+	}
+}